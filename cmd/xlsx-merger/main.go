@@ -1,22 +1,61 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/ryabkov82/xlsx-merger/internal/config"
-	"github.com/ryabkov82/xlsx-merger/internal/merger"
+	"github.com/ryabkov82/xlsx-merger/xlsxmerger"
+)
+
+// Статусы завершения
+const (
+	StatusOK        = "ok"
+	StatusError     = "error"
+	StatusCancelled = "cancelled" // прервано сигналом или по -timeout
+	StatusPartial   = "partial"   // результат сохранен, но часть входных файлов пропущена (-on-error=skip)
+)
+
+// Коды завершения процесса
+const (
+	exitOK        = 0
+	exitError     = 1 // прочие ошибки
+	exitConfig    = 2 // ошибка конфигурации (флаги, файл конфигурации, колонки не из шаблона)
+	exitPartial   = 3 // результат сохранен, но часть входных файлов пропущена
+	exitNoInput   = 4 // входные файлы не найдены
+	exitTemplate  = 5 // шаблон не найден или не читается
+	exitRead      = 6 // ошибка чтения входного файла
+	exitWrite     = 7 // ошибка записи результата
+	exitCancelled = 8 // прервано сигналом или по -timeout
 )
 
 type Output struct {
-	Success     bool     `json:"success"`
-	OutputFiles []string `json:"output_files,omitempty"`
-	Error       string   `json:"error,omitempty"`
-	Duration    string   `json:"duration"`
-	RowCount    int64    `json:"row_count,omitempty"`
+	Success        bool                        `json:"success"`
+	Status         string                      `json:"status"`
+	ExitCode       int                         `json:"exit_code"`
+	Partial        bool                        `json:"partial,omitempty"`
+	OutputFiles    []string                    `json:"output_files,omitempty"`
+	InputFiles     []string                    `json:"input_files,omitempty"`
+	Error          string                      `json:"error,omitempty"`
+	Duration       string                      `json:"duration"`
+	RowCount       int64                       `json:"row_count,omitempty"`
+	RejectedRows   int64                       `json:"rejected_rows,omitempty"`
+	RejectsFile    string                      `json:"rejects_file,omitempty"`
+	SummaryFile    string                      `json:"summary_file,omitempty"`
+	SpilledRows    int64                       `json:"spilled_rows,omitempty"`
+	Duplicates     int64                       `json:"duplicates_removed,omitempty"`
+	FilteredRows   int64                       `json:"filtered_rows,omitempty"`
+	Filtered       []xlsxmerger.FilteredRows   `json:"filtered,omitempty"`
+	Files          []xlsxmerger.FileStats      `json:"files,omitempty"`
+	FailedFiles    []xlsxmerger.FailedFile     `json:"failed_files,omitempty"`
+	UnknownColumns []xlsxmerger.UnknownColumns `json:"unknown_columns,omitempty"`
 }
 
 func main() {
@@ -25,32 +64,120 @@ func main() {
 
 	cfg, err := config.ParseFlags()
 	if err != nil {
-		emitJSON(Output{
+		exit(Output{
 			Success:  false,
+			Status:   StatusError,
+			ExitCode: exitConfig,
 			Error:    fmt.Sprintf("Ошибка конфигурации: %v", err),
 			Duration: time.Since(start).String(),
 		})
+	}
+
+	if cfg.PrintConfig {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cfg); err != nil {
+			log.Fatalf("Ошибка вывода JSON: %v", err)
+		}
 		return
 	}
 
-	m := merger.NewStreamMerger()
-	outputFiles, RowCount, err := m.MergeFiles(cfg)
+	// SIGINT/SIGTERM прерывают слияние с удалением незавершенных файлов,
+	// повторный сигнал завершает процесс сразу
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	opts := []xlsxmerger.Option{xlsxmerger.WithConfig(cfg)}
+	progress := newProgressPrinter(cfg.Progress, os.Stderr)
+	if progress != nil {
+		opts = append(opts, xlsxmerger.WithProgress(progress.Event))
+	}
+
+	result, err := xlsxmerger.Merge(ctx, opts...)
+	if progress != nil {
+		progress.Done()
+	}
 	if err != nil {
-		emitJSON(Output{
+		out := Output{
 			Success:  false,
+			Status:   StatusError,
+			ExitCode: exitCode(err),
 			Error:    fmt.Sprintf("Ошибка объединения: %v", err),
 			Duration: time.Since(start).String(),
-		})
-		return
+		}
+		if out.ExitCode == exitConfig {
+			out.Error = fmt.Sprintf("Ошибка конфигурации: %v", err)
+		}
+		if result != nil {
+			out.Files = result.Files
+			out.FailedFiles = result.FailedFiles
+		}
+		if result != nil && result.Cancelled {
+			out.Status, out.ExitCode = StatusCancelled, exitCancelled
+			out.InputFiles = result.InputFiles
+			if errors.Is(err, context.DeadlineExceeded) {
+				out.Error = fmt.Sprintf("Слияние прервано: превышено время ожидания %s", cfg.Timeout)
+			} else {
+				out.Error = "Слияние прервано сигналом"
+			}
+		}
+		exit(out)
+	}
+
+	out := Output{
+		Success:        true,
+		Status:         StatusOK,
+		ExitCode:       exitOK,
+		OutputFiles:    result.OutputFiles,
+		InputFiles:     result.InputFiles,
+		RowCount:       result.RowCount,
+		RejectedRows:   result.RejectedRows,
+		RejectsFile:    result.RejectsFile,
+		SummaryFile:    result.SummaryFile,
+		SpilledRows:    result.SpilledRows,
+		Duplicates:     result.Duplicates,
+		FilteredRows:   result.FilteredRows,
+		Filtered:       result.Filtered,
+		Files:          result.Files,
+		FailedFiles:    result.FailedFiles,
+		UnknownColumns: result.UnknownColumns,
+		Duration:       time.Since(start).String(),
+	}
+	if result.Partial {
+		out.Status, out.Partial, out.ExitCode = StatusPartial, true, exitPartial
 	}
+	exit(out)
 
-	emitJSON(Output{
-		Success:     true,
-		OutputFiles: outputFiles,
-		RowCount:    RowCount,
-		Duration:    time.Since(start).String(),
-	})
+}
+
+// exitCode возвращает код завершения по виду ошибки слияния
+func exitCode(err error) int {
+	var cfgErr *xlsxmerger.ConfigError
+	switch {
+	case errors.As(err, &cfgErr):
+		return exitConfig
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return exitCancelled
+	case errors.Is(err, xlsxmerger.ErrNoInputFiles):
+		return exitNoInput
+	case errors.Is(err, xlsxmerger.ErrTemplate):
+		return exitTemplate
+	case errors.Is(err, xlsxmerger.ErrRead):
+		return exitRead
+	case errors.Is(err, xlsxmerger.ErrWrite):
+		return exitWrite
+	}
+	return exitError
+}
 
+// exit выводит итоги в JSON и завершает процесс с кодом out.ExitCode
+func exit(out Output) {
+	emitJSON(out)
+	os.Exit(out.ExitCode)
 }
 
 func emitJSON(out Output) {
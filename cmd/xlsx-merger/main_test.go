@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/ryabkov82/xlsx-merger/xlsxmerger"
+)
+
+func TestExitCodeKinds(t *testing.T) {
+	// коды из таблицы «Коды завершения» в README
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("прочая"), 1},
+		{config.Invalid(errors.New("флаг")), 2},
+		{fmt.Errorf("слияние: %w", config.Invalid(errors.New("колонка"))), 2},
+		{context.Canceled, 8},
+		{fmt.Errorf("чтение: %w", context.DeadlineExceeded), 8},
+		{fmt.Errorf("%w: нет файлов", xlsxmerger.ErrNoInputFiles), 4},
+		{fmt.Errorf("%w: шаблон", xlsxmerger.ErrTemplate), 5},
+		{fmt.Errorf("%w: файл", xlsxmerger.ErrRead), 6},
+		{fmt.Errorf("%w: диск", xlsxmerger.ErrWrite), 7},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, ожидается %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestExitCodeMerge(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	broken := filepath.Join(dir, "broken")
+	for path, content := range map[string]string{
+		filepath.Join(input, "a.csv"):  "Код\n1\n",
+		filepath.Join(broken, "b.csv"): "Код\n\"2\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := xlsxmerger.WithOutput(filepath.Join(dir, "out.csv"))
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		opts []xlsxmerger.Option
+		want int
+	}{
+		{"неизвестный порядок", context.Background(), []xlsxmerger.Option{xlsxmerger.WithInputDir(input), out, xlsxmerger.WithOrder("bad", false)}, exitConfig},
+		{"нет входной папки", context.Background(), []xlsxmerger.Option{xlsxmerger.WithInputDir(filepath.Join(dir, "нет")), out}, exitNoInput},
+		{"нет шаблона", context.Background(), []xlsxmerger.Option{xlsxmerger.WithInputDir(input), out, xlsxmerger.WithTemplate(filepath.Join(dir, "нет.xlsx"))}, exitTemplate},
+		{"ошибка чтения", context.Background(), []xlsxmerger.Option{xlsxmerger.WithInputDir(broken), out, xlsxmerger.WithHeaders(true)}, exitRead},
+		{"ошибка записи", context.Background(), []xlsxmerger.Option{xlsxmerger.WithInputDir(input), xlsxmerger.WithOutput(filepath.Join(dir, "нет", "out.csv"))}, exitWrite},
+		{"отмена", cancelled, []xlsxmerger.Option{xlsxmerger.WithInputDir(input), out}, exitCancelled},
+	}
+	for _, tt := range tests {
+		_, err := xlsxmerger.Merge(tt.ctx, tt.opts...)
+		if err == nil {
+			t.Errorf("%s: ожидается ошибка", tt.name)
+			continue
+		}
+		if got := exitCode(err); got != tt.want {
+			t.Errorf("%s: код %d, ожидается %d (ошибка %v)", tt.name, got, tt.want, err)
+		}
+	}
+}
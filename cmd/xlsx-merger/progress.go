@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/ryabkov82/xlsx-merger/xlsxmerger"
+)
+
+// progressPrinter выводит ход слияния в stderr
+type progressPrinter interface {
+	Event(ev xlsxmerger.ProgressEvent)
+	// Done завершает вывод перед итоговым JSON
+	Done()
+}
+
+// newProgressPrinter создает вывод хода слияния для режима -progress
+// (nil для режима none)
+func newProgressPrinter(mode string, w io.Writer) progressPrinter {
+	switch mode {
+	case config.ProgressBar:
+		return &progressBar{w: w}
+	case config.ProgressJSON:
+		return &progressJSON{enc: json.NewEncoder(w)}
+	}
+	return nil
+}
+
+// progressJSON пишет события построчно в формате JSON Lines
+type progressJSON struct {
+	enc *json.Encoder
+}
+
+func (p *progressJSON) Event(ev xlsxmerger.ProgressEvent) { p.enc.Encode(ev) }
+
+func (p *progressJSON) Done() {}
+
+// progressBar перерисовывает одну строку прогресса:
+// доля прочитанных байт, файлы, записанные строки и номер части
+type progressBar struct {
+	w       io.Writer
+	width   int  // длина последней выведенной строки
+	started bool // строка выводилась и требует перевода строки в конце
+}
+
+const progressBarWidth = 30
+
+func (p *progressBar) Event(ev xlsxmerger.ProgressEvent) {
+	var percent float64
+	if ev.BytesTotal > 0 {
+		percent = float64(ev.BytesRead) / float64(ev.BytesTotal)
+	}
+	if ev.Type == xlsxmerger.EventFinished {
+		percent = 1
+	}
+	percent = min(max(percent, 0), 1)
+
+	filled := int(percent * progressBarWidth)
+	line := fmt.Sprintf("[%s%s] %3.0f%%  файлы %d/%d  строк %s  часть %d",
+		strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled),
+		percent*100, ev.FilesDone, ev.Files, groupDigits(ev.RowsWritten), ev.Part)
+	if ev.Rejected > 0 {
+		line += "  отклонено " + groupDigits(ev.Rejected)
+	}
+
+	// хвост предыдущей, более длинной строки затирается пробелами
+	n := utf8.RuneCountInString(line)
+	pad := ""
+	if n < p.width {
+		pad = strings.Repeat(" ", p.width-n)
+	}
+	p.width = n
+	fmt.Fprint(p.w, "\r"+line+pad)
+	p.started = true
+}
+
+func (p *progressBar) Done() {
+	if p.started {
+		fmt.Fprintln(p.w)
+		p.started = false
+	}
+}
+
+// groupDigits форматирует число с разделением разрядов пробелом: 1 234 567
+func groupDigits(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 && r != '-' && s[i-1] != '-' {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -1,45 +1,792 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Порядок обработки входных файлов
+const (
+	OrderSize    = "size"    // по размеру
+	OrderName    = "name"    // по имени
+	OrderNatural = "natural" // по имени с учетом чисел: file2 < file10
+	OrderModTime = "mtime"   // по времени изменения
+	OrderList    = "list"    // по списку из файла -order-file
+)
+
+// Режимы обработки колонок, отсутствующих в шаблоне
+const (
+	UnknownColumnsAppend = "append" // добавлять в конец схемы
+	UnknownColumnsReport = "report" // пропускать и сообщать в результате
+)
+
+// Режимы слияния (порядок строк в результате)
+const (
+	MergeOrdered     = "ordered"     // файлы по порядку -order (по умолчанию)
+	MergeContiguous  = "contiguous"  // файлы целиком в порядке готовности
+	MergeInterleaved = "interleaved" // строки файлов вперемешку по мере чтения
+)
+
+// Какая из повторяющихся строк остается в результате
+const (
+	DedupFirst = "first" // первая по порядку записи
+	DedupLast  = "last"  // последняя по порядку записи
+)
+
+// Источники значений вычисляемых колонок
+const (
+	DerivedFile    = "file"  // путь входного файла относительно входной папки
+	DerivedSheet   = "sheet" // имя исходного листа
+	DerivedRow     = "row"   // номер строки в исходном листе
+	DerivedModTime = "mtime" // время изменения входного файла
+	DerivedRegex   = "regex" // фрагмент пути входного файла по регулярному выражению
+	DerivedExpr    = "expr"  // выражение над колонками строки
+)
+
+// Обработка ошибок чтения входных файлов
+const (
+	OnErrorFail = "fail" // прервать слияние (по умолчанию)
+	OnErrorSkip = "skip" // пропустить файл и продолжить
+)
+
+// Функции итогов -agg
+const (
+	AggSum      = "sum"      // сумма чисел
+	AggCount    = "count"    // число непустых значений (без колонки - число строк)
+	AggMin      = "min"      // минимум
+	AggMax      = "max"      // максимум
+	AggAvg      = "avg"      // среднее чисел
+	AggDistinct = "distinct" // число различных значений
+)
+
+// Вывод хода слияния
+const (
+	ProgressNone = "none" // не выводить
+	ProgressBar  = "bar"  // строка прогресса
+	ProgressJSON = "json" // события JSON Lines
+)
+
+// Форматы результата
+const (
+	FormatXLSX    = "xlsx"    // Excel на основе шаблона
+	FormatCSV     = "csv"     // CSV в UTF-8
+	FormatJSONL   = "jsonl"   // JSON Lines
+	FormatParquet = "parquet" // Apache Parquet
 )
 
 type Config struct {
-	InputDir      string
-	OutputPath    string
-	SampleRows    int
-	AddSourceFile bool
-	HasHeaders    bool   // Флаг наличия заголовков в исходных файлах
-	MaxRowPerFile int64  // максимальное количество строк в объединенном файле
-	TemplatePath  string // путь к файлу шаблону
+	InputDir       string              `json:"dir"`
+	OutputPath     string              `json:"out"`
+	SampleRows     int                 `json:"sample"`
+	AddSourceFile  bool                `json:"add-source"`
+	HasHeaders     bool                `json:"has-headers"`              // Флаг наличия заголовков в исходных файлах
+	MaxRowPerFile  int64               `json:"max-row"`                  // максимальное количество строк в объединенном файле
+	TemplatePath   string              `json:"template"`                 // путь к файлу шаблону
+	AlignHeaders   bool                `json:"align-headers"`            // сопоставлять колонки исходных файлов с шаблоном по заголовкам
+	HeaderAliases  map[string][]string `json:"header-aliases,omitempty"` // синонимы заголовков: заголовок шаблона -> варианты в исходных файлах
+	UnknownColumns string              `json:"unknown-columns"`          // обработка колонок, отсутствующих в шаблоне: append|report
+	SheetName      string              `json:"sheet"`                    // имя исходного листа
+	SheetRegex     string              `json:"sheet-regex"`              // регулярное выражение для выбора исходных листов
+	SheetIndex     int                 `json:"sheet-index"`              // номер исходного листа (с 1)
+	AllSheets      bool                `json:"all-sheets"`               // читать все листы исходных файлов
+	SheetPerSource bool                `json:"split-sheets"`             // отдельный лист результата для каждого имени исходного листа
+	OutputSheet    string              `json:"out-sheet"`                // имя листа результата
+	Order          string              `json:"order"`                    // порядок обработки входных файлов: size|name|natural|mtime|list
+	OrderDesc      bool                `json:"order-desc"`               // обратный порядок
+	OrderFile      string              `json:"order-file"`               // файл со списком входных файлов для -order=list
+	Recursive      bool                `json:"recursive"`                // искать файлы во вложенных папках
+	Include        []string            `json:"include,omitempty"`        // маски включаемых файлов
+	Exclude        []string            `json:"exclude,omitempty"`        // маски исключаемых файлов
+	IncludeRegex   []string            `json:"include-regex,omitempty"`  // регулярные выражения включаемых файлов
+	ExcludeRegex   []string            `json:"exclude-regex,omitempty"`  // регулярные выражения исключаемых файлов
+	CSVDelimiter   string              `json:"csv-delimiter"`            // разделитель полей CSV (по умолчанию "," для .csv и табуляция для .tsv)
+	CSVQuote       string              `json:"csv-quote"`                // символ кавычек CSV
+	CSVEncoding    string              `json:"csv-encoding"`             // кодировка CSV файлов
+	Format         string              `json:"format"`                   // формат результата: xlsx|csv|jsonl|parquet
+	Validate       bool                `json:"validate"`                 // проверять соответствие значений типам колонок шаблона
+	Rules          []ValidationRule    `json:"rule,omitempty"`           // правила проверки колонок
+	Columns        []DerivedColumn     `json:"column,omitempty"`         // вычисляемые колонки
+	RejectsPath    string              `json:"rejects"`                  // файл отклоненных строк (.xlsx или .csv)
+	Timeout        Duration            `json:"timeout"`                  // ограничение времени слияния (0 - без ограничения)
+	Progress       string              `json:"progress"`                 // вывод хода слияния в stderr: none|bar|json
+	Workers        int                 `json:"workers"`                  // число одновременно читаемых файлов (0 - по числу CPU)
+	BufferRows     int                 `json:"buffer-rows"`              // максимум прочитанных, но не записанных строк (0 - без ограничения)
+	BufferMB       int                 `json:"buffer-mb"`                // максимум памяти под такие строки, МБ (0 - без ограничения)
+	MergeMode      string              `json:"merge-mode"`               // порядок строк: ordered|contiguous|interleaved
+	Spill          bool                `json:"spill"`                    // вытеснять строки файлов, опередивших запись, во временные сегменты на диске
+	SpillDir       string              `json:"spill-dir"`                // папка временных файлов (по умолчанию системная временная папка)
+	Filter         string              `json:"filter"`                   // выражение фильтра строк
+	Dedup          bool                `json:"dedup"`                    // удалять повторяющиеся строки
+	DedupKeys      []string            `json:"dedup-keys,omitempty"`     // колонки ключа повторов (по умолчанию вся строка)
+	DedupKeep      string              `json:"dedup-keep"`               // какую из повторяющихся строк оставлять: first|last
+	DedupMB        int                 `json:"dedup-mb"`                 // память под ключи строк, МБ
+	Sort           []string            `json:"sort,omitempty"`           // колонки сортировки результата: "Дата", "Клиент:desc"
+	SortMB         int                 `json:"sort-mb"`                  // память под сортируемые строки, МБ
+	GroupBy        []string            `json:"group-by,omitempty"`       // колонки группировки итогов
+	Aggregates     []string            `json:"agg,omitempty"`            // итоги: "Сумма:sum", "Клиент:distinct", "count"
+	SummarySheet   string              `json:"summary-sheet"`            // имя листа итогов
+	SummaryPath    string              `json:"summary-out"`              // отдельная книга итогов (по умолчанию лист в каждой книге результата)
+	Manifest       bool                `json:"manifest"`                 // лист Manifest с итогами по входным файлам в первой части
+	OnError        string              `json:"on-error"`                 // ошибка чтения входного файла: fail|skip
+	PrintConfig    bool                `json:"-"`                        // вывести итоговую конфигурацию вместо слияния
+}
+
+// Duration - длительность, которая задается и выводится в JSON строкой вида "1h30m"
+type Duration time.Duration
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("некорректная длительность %q: ожидается вида 90s, 15m, 1h30m", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+// ValidationRule описывает правила проверки значений колонки
+type ValidationRule struct {
+	Column   string   `json:"column"`             // заголовок колонки шаблона или буква колонки
+	Required bool     `json:"required,omitempty"` // значение обязательно
+	Regex    string   `json:"regex,omitempty"`    // регулярное выражение для значения
+	Min      string   `json:"min,omitempty"`      // минимальное значение (число или дата)
+	Max      string   `json:"max,omitempty"`      // максимальное значение (число или дата)
+	Allowed  []string `json:"allowed,omitempty"`  // допустимые значения
+}
+
+// DerivedColumn описывает вычисляемую колонку результата
+type DerivedColumn struct {
+	Header   string `json:"header"`             // заголовок колонки
+	Source   string `json:"source"`             // источник значения: file|sheet|row|mtime|regex|expr
+	Regex    string `json:"regex,omitempty"`    // регулярное выражение для пути файла (source=regex)
+	Expr     string `json:"expr,omitempty"`     // выражение над колонками (source=expr)
+	Position string `json:"position,omitempty"` // позиция: first, last (по умолчанию), буква или номер колонки
+	After    string `json:"after,omitempty"`    // колонка, после которой вставляется вычисляемая
+	Before   string `json:"before,omitempty"`   // колонка, перед которой вставляется вычисляемая
+	Style    string `json:"style,omitempty"`    // колонка шаблона, стили заголовка и значений которой используются
+}
+
+// listFlag - повторяемый строковый флаг
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, "; ") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// Error - ошибка конфигурации: некорректные флаги, файл конфигурации или параметры,
+// не подходящие к входным файлам. Текст ошибки - текст Err.
+type Error struct {
+	Err error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Invalid помечает err как ошибку конфигурации; nil и уже помеченные ошибки возвращаются как есть
+func Invalid(err error) error {
+	var cfgErr *Error
+	if err == nil || errors.As(err, &cfgErr) {
+		return err
+	}
+	return &Error{Err: err}
 }
 
+// ParseFlags читает конфигурацию из флагов командной строки и файла -config.
+// Ошибки возвращаются как *Error.
 func ParseFlags() (*Config, error) {
+	cfg, err := parseFlags()
+	return cfg, Invalid(err)
+}
+
+func parseFlags() (*Config, error) {
 
 	cfg := &Config{}
+	def := Default()
+
+	var aliases, include, exclude, includeRegex, excludeRegex, dedupKeys, sortKeys, groupBy, aggregates string
+	var configPath, profile string
+	var rules, columns listFlag
+
+	flag.StringVar(&configPath, "config", "", "файл конфигурации .yaml, .json или .toml (ключи - имена флагов)")
+	flag.StringVar(&profile, "profile", "", "профиль из файла конфигурации")
+	flag.BoolVar(&cfg.PrintConfig, "print-config", false, "вывести итоговую конфигурацию в JSON без слияния")
 
 	flag.StringVar(&cfg.InputDir, "dir", "", "папка с исходными XLSX файлами")
-	flag.StringVar(&cfg.OutputPath, "out", "./merged.xlsx", "результирующий файл")
-	flag.IntVar(&cfg.SampleRows, "sample", 1000, "количество анализируемых строк")
-	flag.BoolVar(&cfg.AddSourceFile, "add-source", false, "добавлять колонку с именем файла")
+	flag.StringVar(&cfg.OutputPath, "out", def.OutputPath, "результирующий файл")
+	flag.IntVar(&cfg.SampleRows, "sample", def.SampleRows, "количество анализируемых строк")
+	flag.BoolVar(&cfg.AddSourceFile, "add-source", false, "добавлять колонку SourceFile с именем файла (то же, что -column \"SourceFile:file\")")
 	flag.BoolVar(&cfg.HasHeaders, "has-headers", false, "исходные файлы содержат заголовки")
-	flag.Int64Var(&cfg.MaxRowPerFile, "max-row", 600000, "максимальное количество строк в объединенном файле")
+	flag.Int64Var(&cfg.MaxRowPerFile, "max-row", def.MaxRowPerFile, "максимальное количество строк в объединенном файле")
 	flag.StringVar(&cfg.TemplatePath, "template", "", "путь к файлу шаблону")
+	flag.BoolVar(&cfg.AlignHeaders, "align-headers", false, "сопоставлять колонки по заголовкам шаблона")
+	flag.StringVar(&aliases, "header-aliases", "", "синонимы заголовков: \"Сумма=Сумма руб.|Итого;Клиент=Контрагент\"")
+	flag.StringVar(&cfg.UnknownColumns, "unknown-columns", def.UnknownColumns, "колонки, отсутствующие в шаблоне: append|report")
+	flag.StringVar(&cfg.SheetName, "sheet", "", "имя исходного листа")
+	flag.StringVar(&cfg.SheetRegex, "sheet-regex", "", "регулярное выражение для выбора исходных листов")
+	flag.IntVar(&cfg.SheetIndex, "sheet-index", 0, "номер исходного листа (с 1)")
+	flag.BoolVar(&cfg.AllSheets, "all-sheets", false, "читать все листы исходных файлов")
+	flag.BoolVar(&cfg.SheetPerSource, "split-sheets", false, "отдельный лист результата для каждого исходного листа")
+	flag.StringVar(&cfg.OutputSheet, "out-sheet", def.OutputSheet, "имя листа результата")
+	flag.StringVar(&cfg.Order, "order", def.Order, "порядок входных файлов: size|name|natural|mtime|list")
+	flag.BoolVar(&cfg.OrderDesc, "order-desc", false, "обратный порядок входных файлов")
+	flag.StringVar(&cfg.OrderFile, "order-file", "", "файл со списком входных файлов для -order=list")
+	flag.BoolVar(&cfg.Recursive, "recursive", false, "искать файлы во вложенных папках")
+	flag.StringVar(&include, "include", "", "маски включаемых файлов через запятую (\"2024/**/*.xlsx,*_msk.xlsx\")")
+	flag.StringVar(&exclude, "exclude", "", "маски исключаемых файлов через запятую")
+	flag.StringVar(&includeRegex, "include-regex", "", "регулярное выражение для относительного пути включаемых файлов")
+	flag.StringVar(&excludeRegex, "exclude-regex", "", "регулярное выражение для относительного пути исключаемых файлов")
+	flag.StringVar(&cfg.CSVDelimiter, "csv-delimiter", "", "разделитель полей CSV (по умолчанию \",\" для .csv и табуляция для .tsv)")
+	flag.StringVar(&cfg.CSVQuote, "csv-quote", def.CSVQuote, "символ кавычек CSV")
+	flag.StringVar(&cfg.CSVEncoding, "csv-encoding", def.CSVEncoding, "кодировка CSV файлов (utf-8, windows-1251, koi8-r, utf-16le...)")
+	flag.StringVar(&cfg.Format, "format", "", "формат результата: xlsx|csv|jsonl|parquet (по умолчанию по расширению -out)")
+	flag.BoolVar(&cfg.Validate, "validate", false, "отклонять строки со значениями, не соответствующими типам колонок шаблона")
+	flag.Var(&rules, "rule", "правило проверки колонки (повторяемый): \"Сумма:required,min=0\", \"Код:regex=^\\d+$\"")
+	flag.Var(&columns, "column", "вычисляемая колонка (повторяемый): \"Лист:sheet\", \"Регион:after=Клиент,regex=^(\\d+)_\", \"НДС:style=Сумма,expr=Сумма * 0.2\"")
+	flag.StringVar(&cfg.Progress, "progress", def.Progress, "вывод хода слияния в stderr: none|bar|json")
+	flag.Var(&cfg.Timeout, "timeout", "ограничение времени слияния, например 30m (по умолчанию без ограничения)")
+	flag.StringVar(&cfg.RejectsPath, "rejects", "", "файл отклоненных строк .xlsx или .csv (по умолчанию <out>_rejects.xlsx)")
+	flag.IntVar(&cfg.Workers, "workers", def.Workers, "число одновременно читаемых файлов (0 - по числу CPU)")
+	flag.IntVar(&cfg.BufferRows, "buffer-rows", def.BufferRows, "максимум прочитанных, но еще не записанных строк (0 - без ограничения)")
+	flag.IntVar(&cfg.BufferMB, "buffer-mb", def.BufferMB, "максимум памяти под прочитанные, но еще не записанные строки, МБ (0 - без ограничения)")
+	flag.StringVar(&cfg.MergeMode, "merge-mode", def.MergeMode, "порядок строк результата: ordered (файлы по порядку), contiguous (файлы целиком по готовности), interleaved (строки вперемешку)")
+	flag.BoolVar(&cfg.Spill, "spill", false, "при заполнении буфера вытеснять строки файлов, опередивших запись, во временные файлы на диске")
+	flag.StringVar(&cfg.SpillDir, "spill-dir", "", "папка временных файлов для -spill, -dedup и -sort (по умолчанию системная временная папка)")
+	flag.StringVar(&cfg.Filter, "filter", "", "оставлять только строки, удовлетворяющие выражению: Регион = 'Москва' AND Сумма > 0")
+	flag.BoolVar(&cfg.Dedup, "dedup", false, "удалять повторяющиеся строки всех входных файлов")
+	flag.StringVar(&dedupKeys, "dedup-keys", "", "колонки ключа повторов через запятую (заголовки шаблона или буквы); по умолчанию вся строка")
+	flag.StringVar(&cfg.DedupKeep, "dedup-keep", def.DedupKeep, "какую из повторяющихся строк оставлять: first|last")
+	flag.IntVar(&cfg.DedupMB, "dedup-mb", def.DedupMB, "память под ключи строк для -dedup, МБ; остальные ключи хранятся на диске")
+	flag.StringVar(&sortKeys, "sort", "", "сортировка результата по колонкам через запятую, :desc - по убыванию: \"Дата,Клиент:desc\"")
+	flag.IntVar(&cfg.SortMB, "sort-mb", def.SortMB, "память под сортируемые строки для -sort, МБ; остальные строки сортируются на диске")
+	flag.StringVar(&groupBy, "group-by", "", "колонки группировки листа итогов через запятую: \"Регион,Менеджер\"")
+	flag.StringVar(&aggregates, "agg", "", "итоги через запятую: колонка:sum|count|min|max|avg|distinct или count: \"Сумма:sum,Сумма:avg,count\"")
+	flag.StringVar(&cfg.SummarySheet, "summary-sheet", def.SummarySheet, "имя листа итогов")
+	flag.StringVar(&cfg.OnError, "on-error", def.OnError, "ошибка чтения входного файла: fail (прервать слияние) или skip (пропустить файл)")
+	flag.BoolVar(&cfg.Manifest, "manifest", false, "добавить в первую часть результата лист Manifest с итогами по входным файлам")
+	flag.StringVar(&cfg.SummaryPath, "summary-out", "", "отдельная книга итогов .xlsx (по умолчанию лист итогов в каждой книге результата, для csv, jsonl и parquet - <out>_summary.xlsx)")
 
 	flag.Parse()
 
+	if configPath != "" {
+		values, err := loadConfigFile(configPath, profile)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyConfigFile(flag.CommandLine, values); err != nil {
+			return nil, err
+		}
+	} else if profile != "" {
+		return nil, fmt.Errorf("-profile требует файла конфигурации (-config)")
+	}
+
 	if cfg.InputDir == "" {
 		return nil, fmt.Errorf("необходимо указать папку с файлами через -dir")
 	}
 
-	// Нормализация путей
-	cfg.InputDir = filepath.Clean(cfg.InputDir)
-	cfg.OutputPath = filepath.Clean(cfg.OutputPath)
-	if cfg.TemplatePath != "" {
-		cfg.TemplatePath = filepath.Clean(cfg.TemplatePath)
+	var err error
+	if cfg.HeaderAliases, err = ParseHeaderAliases(aliases); err != nil {
+		return nil, err
 	}
 
+	for _, spec := range rules {
+		rule, err := ParseValidationRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Rules = append(cfg.Rules, rule)
+	}
+	for _, spec := range columns {
+		column, err := ParseDerivedColumn(spec)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Columns = append(cfg.Columns, column)
+	}
+
+	cfg.Include = splitList(include)
+	cfg.Exclude = splitList(exclude)
+	cfg.DedupKeys = splitList(dedupKeys)
+	cfg.Sort = splitList(sortKeys)
+	cfg.GroupBy = splitList(groupBy)
+	cfg.Aggregates = splitList(aggregates)
+	if includeRegex != "" {
+		cfg.IncludeRegex = []string{includeRegex}
+	}
+	if excludeRegex != "" {
+		cfg.ExcludeRegex = []string{excludeRegex}
+	}
+
+	if err := cfg.Normalize(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
+
+// Default возвращает конфигурацию со значениями по умолчанию
+func Default() *Config {
+	return &Config{
+		OutputPath:     "./merged.xlsx",
+		SampleRows:     1000,
+		MaxRowPerFile:  600000,
+		UnknownColumns: UnknownColumnsReport,
+		OutputSheet:    "merged",
+		Order:          OrderSize,
+		Progress:       ProgressNone,
+		MergeMode:      MergeOrdered,
+		DedupKeep:      DedupFirst,
+		DedupMB:        256,
+		SortMB:         256,
+		SummarySheet:   "Итоги",
+		OnError:        OnErrorFail,
+		Workers:        4,
+		BufferRows:     100000,
+		BufferMB:       512,
+		CSVQuote:       "\"",
+		CSVEncoding:    "utf-8",
+	}
+}
+
+// Normalize проверяет согласованность параметров, подставляет формат по расширению
+// результата и приводит пути к каноническому виду. Ошибки возвращаются как *Error.
+func (cfg *Config) Normalize() error {
+	return Invalid(cfg.normalize())
+}
+
+func (cfg *Config) normalize() error {
+	if cfg.AlignHeaders && !cfg.HasHeaders {
+		return fmt.Errorf("-align-headers требует наличия заголовков (-has-headers)")
+	}
+
+	switch cfg.UnknownColumns {
+	case UnknownColumnsAppend, UnknownColumnsReport:
+	default:
+		return fmt.Errorf("неизвестное значение -unknown-columns: %q", cfg.UnknownColumns)
+	}
+
+	switch cfg.Order {
+	case OrderSize, OrderName, OrderNatural, OrderModTime:
+	case OrderList:
+		if cfg.OrderFile == "" {
+			return fmt.Errorf("для -order=list необходимо указать -order-file")
+		}
+	default:
+		return fmt.Errorf("неизвестное значение -order: %q", cfg.Order)
+	}
+
+	switch strings.ToLower(cfg.CSVDelimiter) {
+	case "tab", `\t`:
+		cfg.CSVDelimiter = "\t"
+	}
+	for name, v := range map[string]string{"-csv-delimiter": cfg.CSVDelimiter, "-csv-quote": cfg.CSVQuote} {
+		if utf8.RuneCountInString(v) > 1 {
+			return fmt.Errorf("%s должен быть одним символом: %q", name, v)
+		}
+	}
+
+	if cfg.Format == "" {
+		cfg.Format = FormatFromPath(cfg.OutputPath)
+	}
+	switch cfg.Format = strings.ToLower(cfg.Format); cfg.Format {
+	case FormatXLSX:
+	case FormatCSV, FormatJSONL, FormatParquet:
+		if cfg.SheetPerSource {
+			return fmt.Errorf("-split-sheets поддерживается только для формата xlsx")
+		}
+		if cfg.Manifest {
+			return fmt.Errorf("-manifest поддерживается только для формата xlsx")
+		}
+	default:
+		return fmt.Errorf("неизвестное значение -format: %q", cfg.Format)
+	}
+
+	selectors := 0
+	for _, set := range []bool{cfg.SheetName != "", cfg.SheetRegex != "", cfg.SheetIndex != 0, cfg.AllSheets} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return fmt.Errorf("ключи -sheet, -sheet-regex, -sheet-index и -all-sheets взаимоисключающие")
+	}
+	if cfg.SheetIndex < 0 {
+		return fmt.Errorf("номер листа должен быть положительным: %d", cfg.SheetIndex)
+	}
+	if cfg.SheetRegex != "" {
+		if _, err := regexp.Compile(cfg.SheetRegex); err != nil {
+			return fmt.Errorf("некорректное регулярное выражение -sheet-regex: %v", err)
+		}
+	}
+	switch cfg.Progress {
+	case "":
+		cfg.Progress = ProgressNone
+	case ProgressNone, ProgressBar, ProgressJSON:
+	default:
+		return fmt.Errorf("неизвестное значение -progress: %q", cfg.Progress)
+	}
+
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("-timeout не может быть отрицательным: %s", cfg.Timeout)
+	}
+	switch cfg.MergeMode {
+	case "":
+		cfg.MergeMode = MergeOrdered
+	case MergeOrdered, MergeContiguous, MergeInterleaved:
+	default:
+		return fmt.Errorf("неизвестное значение -merge-mode: %q", cfg.MergeMode)
+	}
+	switch cfg.OnError {
+	case "":
+		cfg.OnError = OnErrorFail
+	case OnErrorFail, OnErrorSkip:
+	default:
+		return fmt.Errorf("неизвестное значение -on-error: %q", cfg.OnError)
+	}
+	if len(cfg.DedupKeys) > 0 {
+		cfg.Dedup = true
+	}
+	switch cfg.DedupKeep {
+	case "":
+		cfg.DedupKeep = DedupFirst
+	case DedupFirst, DedupLast:
+	default:
+		return fmt.Errorf("неизвестное значение -dedup-keep: %q", cfg.DedupKeep)
+	}
+	if cfg.Dedup && cfg.DedupMB <= 0 {
+		return fmt.Errorf("-dedup-mb должен быть положительным: %d", cfg.DedupMB)
+	}
+	for _, key := range cfg.Sort {
+		if name, _ := SortKey(key); name == "" {
+			return fmt.Errorf("в ключе сортировки %q не указана колонка", key)
+		}
+	}
+	if len(cfg.Sort) > 0 && cfg.SortMB <= 0 {
+		return fmt.Errorf("-sort-mb должен быть положительным: %d", cfg.SortMB)
+	}
+	for _, spec := range cfg.Aggregates {
+		if _, _, err := Aggregate(spec); err != nil {
+			return err
+		}
+	}
+	if cfg.Summary() {
+		if strings.TrimSpace(cfg.SummarySheet) == "" {
+			return fmt.Errorf("имя листа итогов не может быть пустым")
+		}
+		if cfg.SummaryPath != "" && !strings.EqualFold(filepath.Ext(cfg.SummaryPath), ".xlsx") {
+			return fmt.Errorf("книга итогов должна иметь расширение .xlsx: %s", cfg.SummaryPath)
+		}
+		if cfg.SummaryPath == "" && cfg.Format == FormatXLSX && cfg.SummarySheet == cfg.OutputSheet {
+			return fmt.Errorf("имя листа итогов совпадает с именем листа результата: %s", cfg.SummarySheet)
+		}
+	}
+	if cfg.Workers < 0 {
+		return fmt.Errorf("-workers не может быть отрицательным: %d", cfg.Workers)
+	}
+	if cfg.BufferRows < 0 {
+		return fmt.Errorf("-buffer-rows не может быть отрицательным: %d", cfg.BufferRows)
+	}
+	if cfg.BufferMB < 0 {
+		return fmt.Errorf("-buffer-mb не может быть отрицательным: %d", cfg.BufferMB)
+	}
+	if strings.TrimSpace(cfg.OutputSheet) == "" {
+		return fmt.Errorf("имя листа результата не может быть пустым")
+	}
+
+	for _, rule := range cfg.Rules {
+		if strings.TrimSpace(rule.Column) == "" {
+			return fmt.Errorf("в правиле проверки не указана колонка")
+		}
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("некорректное регулярное выражение в правиле %q: %v", rule.Column, err)
+		}
+	}
+
+	for _, column := range cfg.Columns {
+		if err := column.check(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.RejectsPath != "" {
+		switch strings.ToLower(filepath.Ext(cfg.RejectsPath)) {
+		case ".xlsx", ".csv":
+		default:
+			return fmt.Errorf("файл отклоненных строк должен иметь расширение .xlsx или .csv: %s", cfg.RejectsPath)
+		}
+	}
+
+	for _, expr := range append(append([]string{}, cfg.IncludeRegex...), cfg.ExcludeRegex...) {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("некорректное регулярное выражение фильтра файлов: %v", err)
+		}
+	}
+
+	if cfg.SpillDir != "" {
+		if info, err := os.Stat(cfg.SpillDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("папка временных файлов -spill-dir не существует: %s", cfg.SpillDir)
+		}
+	}
+
+	// Нормализация путей
+	for _, path := range []*string{&cfg.InputDir, &cfg.OutputPath, &cfg.TemplatePath, &cfg.OrderFile, &cfg.RejectsPath, &cfg.SpillDir, &cfg.SummaryPath} {
+		if *path != "" {
+			*path = filepath.Clean(*path)
+		}
+	}
+
+	return nil
+}
+
+// FormatFromPath определяет формат результата по расширению файла,
+// по умолчанию - xlsx
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	case ".parquet":
+		return FormatParquet
+	}
+	return FormatXLSX
+}
+
+// ParseHeaderAliases разбирает описание синонимов заголовков вида
+// "Сумма=Сумма руб.|Итого;Клиент=Контрагент"
+func ParseHeaderAliases(spec string) (map[string][]string, error) {
+	aliases := make(map[string][]string)
+	for _, item := range strings.Split(spec, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("некорректное описание синонимов заголовка: %q", item)
+		}
+		for _, v := range strings.Split(values, "|") {
+			if v = strings.TrimSpace(v); v != "" {
+				aliases[name] = append(aliases[name], v)
+			}
+		}
+	}
+	return aliases, nil
+}
+
+// ParseValidationRule разбирает правило проверки колонки вида
+// "Сумма:required,min=0,max=1000000" или "Регион:allowed=Москва|Казань".
+// Значение regex= занимает остаток строки, поэтому может содержать запятые.
+func ParseValidationRule(spec string) (ValidationRule, error) {
+	var rule ValidationRule
+	column, body, ok := strings.Cut(spec, ":")
+	rule.Column = strings.TrimSpace(column)
+	if !ok || rule.Column == "" {
+		return rule, fmt.Errorf("некорректное правило проверки %q: ожидается \"Колонка:правило,...\"", spec)
+	}
+
+	for body = strings.TrimSpace(body); body != ""; {
+		item := body
+		if !strings.HasPrefix(item, "regex=") {
+			item, body, _ = strings.Cut(body, ",")
+		} else {
+			body = ""
+		}
+		name, value, _ := strings.Cut(item, "=")
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "required":
+			rule.Required = true
+		case "regex":
+			if _, err := regexp.Compile(value); err != nil {
+				return rule, fmt.Errorf("некорректное регулярное выражение в правиле %q: %v", spec, err)
+			}
+			rule.Regex = value
+		case "min":
+			rule.Min = value
+		case "max":
+			rule.Max = value
+		case "allowed":
+			for _, v := range strings.Split(value, "|") {
+				if v = strings.TrimSpace(v); v != "" {
+					rule.Allowed = append(rule.Allowed, v)
+				}
+			}
+		case "":
+		default:
+			return rule, fmt.Errorf("неизвестное правило %q в %q", name, spec)
+		}
+		body = strings.TrimSpace(body)
+	}
+	return rule, nil
+}
+
+// ParseDerivedColumn разбирает вычисляемую колонку вида "Заголовок:источник,параметр=значение,...".
+// Источник - file, sheet, row, mtime, regex=<выражение> или expr=<выражение>; параметры -
+// position=first|last|<буква или номер>, after=<колонка>, before=<колонка>, style=<колонка>.
+// Значения regex= и expr= занимают остаток строки, поэтому могут содержать запятые.
+func ParseDerivedColumn(spec string) (DerivedColumn, error) {
+	var column DerivedColumn
+	header, body, ok := strings.Cut(spec, ":")
+	column.Header = strings.TrimSpace(header)
+	if !ok || column.Header == "" {
+		return column, fmt.Errorf("некорректная вычисляемая колонка %q: ожидается \"Заголовок:источник,...\"", spec)
+	}
+
+	for body = strings.TrimSpace(body); body != ""; {
+		item := body
+		if !strings.HasPrefix(item, "regex=") && !strings.HasPrefix(item, "expr=") {
+			item, body, _ = strings.Cut(body, ",")
+		} else {
+			body = ""
+		}
+		name, value, hasValue := strings.Cut(item, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		switch name {
+		case DerivedFile, DerivedSheet, DerivedRow, DerivedModTime:
+			if hasValue {
+				return column, fmt.Errorf("источник %s в %q не имеет значения", name, spec)
+			}
+			column.Source = name
+		case DerivedRegex:
+			column.Source, column.Regex = name, value
+		case DerivedExpr:
+			column.Source, column.Expr = name, value
+		case "position":
+			column.Position = value
+		case "after":
+			column.After = value
+		case "before":
+			column.Before = value
+		case "style":
+			column.Style = value
+		case "":
+		default:
+			return column, fmt.Errorf("неизвестный параметр %q в %q", name, spec)
+		}
+		body = strings.TrimSpace(body)
+	}
+	if err := column.check(); err != nil {
+		return column, err
+	}
+	return column, nil
+}
+
+// check проверяет согласованность параметров вычисляемой колонки
+func (c DerivedColumn) check() error {
+	if strings.TrimSpace(c.Header) == "" {
+		return fmt.Errorf("у вычисляемой колонки не указан заголовок")
+	}
+	switch c.Source {
+	case DerivedFile, DerivedSheet, DerivedRow, DerivedModTime:
+	case DerivedRegex:
+		if c.Regex == "" {
+			return fmt.Errorf("вычисляемая колонка %q: не задано регулярное выражение", c.Header)
+		}
+		if _, err := regexp.Compile(c.Regex); err != nil {
+			return fmt.Errorf("вычисляемая колонка %q: некорректное регулярное выражение: %v", c.Header, err)
+		}
+	case DerivedExpr:
+		if strings.TrimSpace(c.Expr) == "" {
+			return fmt.Errorf("вычисляемая колонка %q: не задано выражение", c.Header)
+		}
+	case "":
+		return fmt.Errorf("вычисляемая колонка %q: не указан источник (file, sheet, row, mtime, regex= или expr=)", c.Header)
+	default:
+		return fmt.Errorf("вычисляемая колонка %q: неизвестный источник %q", c.Header, c.Source)
+	}
+	placements := 0
+	for _, set := range []bool{c.Position != "", c.After != "", c.Before != ""} {
+		if set {
+			placements++
+		}
+	}
+	if placements > 1 {
+		return fmt.Errorf("вычисляемая колонка %q: position, after и before взаимоисключающие", c.Header)
+	}
+	return nil
+}
+
+// SortKey разбирает ключ сортировки вида "Колонка", "Колонка:asc" или "Колонка:desc"
+func SortKey(key string) (column string, desc bool) {
+	key = strings.TrimSpace(key)
+	if i := strings.LastIndex(key, ":"); i >= 0 {
+		switch strings.ToLower(strings.TrimSpace(key[i+1:])) {
+		case "desc":
+			return strings.TrimSpace(key[:i]), true
+		case "asc":
+			return strings.TrimSpace(key[:i]), false
+		}
+	}
+	return key, false
+}
+
+// Summary сообщает, что нужен лист итогов (-group-by или -agg)
+func (cfg *Config) Summary() bool {
+	return len(cfg.GroupBy) > 0 || len(cfg.Aggregates) > 0
+}
+
+// Aggregate разбирает итог вида "Колонка:функция" или "count" (число строк)
+func Aggregate(spec string) (column, fn string, err error) {
+	spec = strings.TrimSpace(spec)
+	if strings.EqualFold(spec, AggCount) {
+		return "", AggCount, nil
+	}
+	i := strings.LastIndex(spec, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("в итоге %q не указана функция (колонка:sum|count|min|max|avg|distinct)", spec)
+	}
+	column, fn = strings.TrimSpace(spec[:i]), strings.ToLower(strings.TrimSpace(spec[i+1:]))
+	switch fn {
+	case AggSum, AggCount, AggMin, AggMax, AggAvg, AggDistinct:
+	default:
+		return "", "", fmt.Errorf("неизвестная функция итога %q в %q (sum, count, min, max, avg, distinct)", fn, spec)
+	}
+	if column == "" {
+		return "", "", fmt.Errorf("в итоге %q не указана колонка", spec)
+	}
+	return column, fn, nil
+}
+
+// splitList разбирает список значений через запятую, пропуская пустые элементы.
+// Запятые внутри фигурных скобок (маски вида *.{xlsx,xlsm}) не разделяют элементы.
+func splitList(s string) []string {
+	var list []string
+	depth, start := 0, 0
+	add := func(item string) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	for i, r := range s {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				add(s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	add(s[start:])
+	return list
+}
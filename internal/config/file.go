@@ -0,0 +1,423 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// profilesKey - раздел файла конфигурации с именованными профилями
+const profilesKey = "profiles"
+
+// fileOnlyFlags - ключи, которые задаются только в командной строке
+var fileOnlyFlags = map[string]bool{"config": true, "profile": true, "print-config": true}
+
+// envReference - ссылка на переменную окружения: ${VAR} или ${VAR:-значение}; $$ - символ $
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// loadConfigFile читает файл конфигурации (.yaml, .yml, .json, .toml) и возвращает
+// значения параметров: ключи верхнего уровня, поверх которых применяется профиль.
+// Ключи совпадают с именами флагов командной строки.
+func loadConfigFile(path, profile string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файла конфигурации: %v", err)
+	}
+
+	raw := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("неподдерживаемый формат файла конфигурации %q: ожидается .yaml, .yml, .json или .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора файла конфигурации %s: %v", path, err)
+	}
+
+	values := make(map[string]interface{})
+	for key, v := range raw {
+		if key != profilesKey {
+			values[key] = v
+		}
+	}
+
+	if profile != "" {
+		profiles, _ := raw[profilesKey].(map[string]interface{})
+		selected, ok := profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("профиль %q не найден в файле конфигурации %s", profile, path)
+		}
+		params, ok := selected.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("профиль %q в файле конфигурации должен быть набором параметров", profile)
+		}
+		for key, v := range params {
+			values[key] = v
+		}
+	}
+	return values, nil
+}
+
+// applyConfigFile устанавливает флаги из значений файла конфигурации.
+// Флаги, явно заданные в командной строке, имеют приоритет.
+func applyConfigFile(fs *flag.FlagSet, values map[string]interface{}) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if fs.Lookup(key) == nil || fileOnlyFlags[key] {
+			return fmt.Errorf("неизвестный параметр %q в файле конфигурации", key)
+		}
+		if explicit[key] {
+			continue
+		}
+		args, err := flagValues(key, values[key])
+		if err != nil {
+			return fmt.Errorf("параметр %q в файле конфигурации: %v", key, err)
+		}
+		for _, arg := range args {
+			if err := fs.Set(key, arg); err != nil {
+				return fmt.Errorf("параметр %q в файле конфигурации: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flagValues приводит значение из файла конфигурации к строкам для flag.Set.
+// Списки допускаются для масок файлов, правил проверки и вычисляемых колонок, синонимы
+// заголовков можно задать набором "заголовок: [варианты]", правило и колонку - объектом.
+func flagValues(key string, v interface{}) ([]string, error) {
+	switch key {
+	case "rule", "column":
+		items, ok := listValue(v)
+		if !ok {
+			items = []interface{}{v}
+		}
+		spec := ruleSpec
+		if key == "column" {
+			spec = columnSpec
+		}
+		var specs []string
+		for _, item := range items {
+			s, err := spec(item)
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, s)
+		}
+		return specs, nil
+	case "header-aliases":
+		if m, ok := v.(map[string]interface{}); ok {
+			return aliasesSpec(m)
+		}
+	case "include", "exclude", "dedup-keys", "sort", "group-by", "agg":
+		if items, ok := listValue(v); ok {
+			list, err := scalarList(items)
+			if err != nil {
+				return nil, err
+			}
+			return []string{strings.Join(list, ",")}, nil
+		}
+	case "include-regex", "exclude-regex":
+		if items, ok := listValue(v); ok {
+			list, err := scalarList(items)
+			if err != nil {
+				return nil, err
+			}
+			if len(list) == 1 {
+				return list, nil
+			}
+			for i, expr := range list {
+				list[i] = "(?:" + expr + ")"
+			}
+			return []string{strings.Join(list, "|")}, nil
+		}
+	}
+	s, err := scalarValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+// ruleSpec преобразует правило проверки из файла конфигурации в запись для -rule
+func ruleSpec(v interface{}) (string, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return scalarValue(v)
+	}
+	var rule ValidationRule
+	for key, value := range m {
+		switch key {
+		case "allowed":
+			items, ok := listValue(value)
+			if !ok {
+				// строка - список через "|", как в -rule
+				s, err := scalarValue(value)
+				if err != nil {
+					return "", err
+				}
+				rule.Allowed = strings.Split(s, "|")
+				continue
+			}
+			list, err := scalarList(items)
+			if err != nil {
+				return "", err
+			}
+			rule.Allowed = list
+			continue
+		case "required":
+			b, ok := value.(bool)
+			if !ok {
+				return "", fmt.Errorf("значение required должно быть логическим")
+			}
+			rule.Required = b
+			continue
+		}
+		s, err := scalarValue(value)
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case "column":
+			rule.Column = s
+		case "regex":
+			rule.Regex = s
+		case "min":
+			rule.Min = s
+		case "max":
+			rule.Max = s
+		default:
+			return "", fmt.Errorf("неизвестное поле правила %q", key)
+		}
+	}
+	if rule.Column == "" {
+		return "", fmt.Errorf("в правиле не указана колонка (column)")
+	}
+	// запись -rule не поддерживает экранирование разделителей
+	if strings.Contains(rule.Column, ":") {
+		return "", fmt.Errorf("колонка правила %q не может содержать \":\"", rule.Column)
+	}
+	for _, bound := range []string{rule.Min, rule.Max} {
+		if strings.Contains(bound, ",") {
+			return "", fmt.Errorf("граница %q правила колонки %q не может содержать \",\"", bound, rule.Column)
+		}
+	}
+	for _, v := range rule.Allowed {
+		if strings.ContainsAny(v, ",|") {
+			return "", fmt.Errorf("допустимое значение %q правила колонки %q не может содержать \",\" или \"|\"", v, rule.Column)
+		}
+	}
+
+	var parts []string
+	if rule.Required {
+		parts = append(parts, "required")
+	}
+	if rule.Min != "" {
+		parts = append(parts, "min="+rule.Min)
+	}
+	if rule.Max != "" {
+		parts = append(parts, "max="+rule.Max)
+	}
+	if len(rule.Allowed) > 0 {
+		parts = append(parts, "allowed="+strings.Join(rule.Allowed, "|"))
+	}
+	// regex= занимает остаток записи, поэтому идет последним
+	if rule.Regex != "" {
+		parts = append(parts, "regex="+rule.Regex)
+	}
+	return rule.Column + ":" + strings.Join(parts, ","), nil
+}
+
+// columnSpec преобразует вычисляемую колонку из файла конфигурации в запись для -column
+func columnSpec(v interface{}) (string, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return scalarValue(v)
+	}
+	var column DerivedColumn
+	for key, value := range m {
+		s, err := scalarValue(value)
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case "header":
+			column.Header = s
+		case "source":
+			column.Source = s
+		case "regex":
+			column.Regex = s
+		case "expr":
+			column.Expr = s
+		case "position":
+			column.Position = s
+		case "after":
+			column.After = s
+		case "before":
+			column.Before = s
+		case "style":
+			column.Style = s
+		default:
+			return "", fmt.Errorf("неизвестное поле вычисляемой колонки %q", key)
+		}
+	}
+	if column.Header == "" {
+		return "", fmt.Errorf("у вычисляемой колонки не указан заголовок (header)")
+	}
+	switch {
+	case column.Regex != "" && (column.Source == "" || column.Source == DerivedRegex):
+		column.Source = DerivedRegex
+	case column.Expr != "" && (column.Source == "" || column.Source == DerivedExpr):
+		column.Source = DerivedExpr
+	case column.Regex != "" || column.Expr != "":
+		return "", fmt.Errorf("вычисляемая колонка %q: regex и expr задаются только для источников regex и expr", column.Header)
+	}
+
+	var parts []string
+	for _, p := range []struct{ name, value string }{
+		{"position", column.Position}, {"after", column.After}, {"before", column.Before}, {"style", column.Style},
+	} {
+		if p.value != "" {
+			parts = append(parts, p.name+"="+p.value)
+		}
+	}
+	// regex= и expr= занимают остаток записи, поэтому источник идет последним
+	switch column.Source {
+	case DerivedRegex:
+		parts = append(parts, "regex="+column.Regex)
+	case DerivedExpr:
+		parts = append(parts, "expr="+column.Expr)
+	default:
+		parts = append(parts, column.Source)
+	}
+	return column.Header + ":" + strings.Join(parts, ","), nil
+}
+
+// aliasesSpec преобразует синонимы заголовков из файла конфигурации в запись для -header-aliases
+func aliasesSpec(m map[string]interface{}) ([]string, error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var items []string
+	for _, name := range names {
+		values, ok := listValue(m[name])
+		if !ok {
+			values = []interface{}{m[name]}
+		}
+		list, err := scalarList(values)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, name+"="+strings.Join(list, "|"))
+	}
+	return []string{strings.Join(items, ";")}, nil
+}
+
+// listValue возвращает элементы списка из файла конфигурации. Массив таблиц TOML
+// ([[rule]]) разбирается в []map[string]interface{}, остальные списки - в []interface{}.
+func listValue(v interface{}) ([]interface{}, bool) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, true
+	case []map[string]interface{}:
+		items := make([]interface{}, len(v))
+		for i, m := range v {
+			items[i] = m
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+func scalarList(items []interface{}) ([]string, error) {
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		s, err := scalarValue(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// scalarValue приводит строку, число или логическое значение к строке;
+// в строках подставляются переменные окружения
+func scalarValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return expandEnv(v)
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("ожидается строка, число или логическое значение, получено %T", v)
+}
+
+// expandEnv подставляет переменные окружения вида ${VAR} и ${VAR:-значение по умолчанию}.
+// Значение по умолчанию используется, если переменная не задана или пуста, как в shell;
+// незаданная переменная без значения по умолчанию считается ошибкой, пустая - нет.
+func expandEnv(s string) (string, error) {
+	var missing []string
+	s = envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		m := envReference.FindStringSubmatch(ref)
+		v, ok := os.LookupEnv(m[1])
+		if strings.Contains(ref, ":-") {
+			if v != "" {
+				return v
+			}
+			return m[2]
+		}
+		if ok {
+			return v
+		}
+		missing = append(missing, m[1])
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("не заданы переменные окружения: %s", strings.Join(missing, ", "))
+	}
+	return s, nil
+}
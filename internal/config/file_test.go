@@ -0,0 +1,205 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Одинаковые параметры в YAML, JSON и TOML
+var configFiles = map[string]string{
+	"merger.yaml": `
+has-headers: true
+max-row: 1000
+include: ["*.xlsx", "2024/*.csv"]
+header-aliases:
+  Сумма: [Сумма руб., Итого]
+rule:
+  - "Клиент:required"
+  - {column: Сумма, min: 0, allowed: [1, 2]}
+column:
+  - {header: Регион, after: Клиент, regex: '^(\d+)_'}
+profiles:
+  daily:
+    dir: /data/daily
+`,
+	"merger.json": `{
+  "has-headers": true,
+  "max-row": 1000,
+  "include": ["*.xlsx", "2024/*.csv"],
+  "header-aliases": {"Сумма": ["Сумма руб.", "Итого"]},
+  "rule": ["Клиент:required", {"column": "Сумма", "min": 0, "allowed": [1, 2]}],
+  "column": [{"header": "Регион", "after": "Клиент", "regex": "^(\\d+)_"}],
+  "profiles": {"daily": {"dir": "/data/daily"}}
+}`,
+	"merger.toml": `
+has-headers = true
+max-row = 1000
+include = ["*.xlsx", "2024/*.csv"]
+
+[header-aliases]
+"Сумма" = ["Сумма руб.", "Итого"]
+
+[[rule]]
+column = "Клиент"
+required = true
+
+[[rule]]
+column = "Сумма"
+min = 0
+allowed = [1, 2]
+
+[[column]]
+header = "Регион"
+after = "Клиент"
+regex = '^(\d+)_'
+
+[profiles.daily]
+dir = "/data/daily"
+`,
+}
+
+// configArgs возвращает значения флагов из файла конфигурации по ключам
+func configArgs(t *testing.T, values map[string]interface{}) map[string][]string {
+	t.Helper()
+	args := make(map[string][]string)
+	for key, v := range values {
+		list, err := flagValues(key, v)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		args[key] = list
+	}
+	return args
+}
+
+func TestLoadConfigFileFormats(t *testing.T) {
+	want := map[string][]string{
+		"has-headers":    {"true"},
+		"max-row":        {"1000"},
+		"include":        {"*.xlsx,2024/*.csv"},
+		"header-aliases": {"Сумма=Сумма руб.|Итого"},
+		"rule":           {"Клиент:required", "Сумма:min=0,allowed=1|2"},
+		"column":         {`Регион:after=Клиент,regex=^(\d+)_`},
+		"dir":            {"/data/daily"},
+	}
+	dir := t.TempDir()
+	names := make([]string, 0, len(configFiles))
+	for name := range configFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(configFiles[name]), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			values, err := loadConfigFile(path, "daily")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := configArgs(t, values); !reflect.DeepEqual(got, want) {
+				t.Errorf("параметры = %v\nожидается %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileProfileErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "merger.yaml")
+	if err := os.WriteFile(path, []byte("profiles:\n  bad: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, profile := range []string{"missing", "bad"} {
+		if _, err := loadConfigFile(path, profile); err == nil {
+			t.Errorf("профиль %q: ожидается ошибка", profile)
+		}
+	}
+}
+
+func TestFlagValuesErrors(t *testing.T) {
+	tests := []struct {
+		key   string
+		value interface{}
+	}{
+		{"rule", map[string]interface{}{"min": 0}},
+		{"rule", map[string]interface{}{"column": "A", "unknown": 1}},
+		{"rule", map[string]interface{}{"column": "A", "required": "yes"}},
+		{"rule", map[string]interface{}{"column": "A", "allowed": []interface{}{"Москва", "ООО Ромашка, филиал"}}},
+		{"rule", map[string]interface{}{"column": "A", "allowed": "a,b"}},
+		{"rule", map[string]interface{}{"column": "A", "min": "1,5"}},
+		{"rule", map[string]interface{}{"column": "A:B", "required": true}},
+		{"column", map[string]interface{}{"source": "file"}},
+		{"column", map[string]interface{}{"header": "A", "source": "file", "expr": "1"}},
+		{"dir", map[string]interface{}{"a": 1}},
+		{"include", []interface{}{[]interface{}{"nested"}}},
+	}
+	for _, tt := range tests {
+		if got, err := flagValues(tt.key, tt.value); err == nil {
+			t.Errorf("%s = %v: ожидается ошибка, получено %q", tt.key, tt.value, got)
+		}
+	}
+}
+
+func TestRuleSpecAllowed(t *testing.T) {
+	tests := []struct {
+		allowed interface{}
+		want    string
+	}{
+		{[]interface{}{"Москва", "Казань"}, "Регион:allowed=Москва|Казань"},
+		{"Москва|Казань", "Регион:allowed=Москва|Казань"},
+		{[]interface{}{1, 2.5}, "Регион:allowed=1|2.5"},
+	}
+	for _, tt := range tests {
+		got, err := ruleSpec(map[string]interface{}{"column": "Регион", "allowed": tt.allowed})
+		if err != nil || got != tt.want {
+			t.Errorf("allowed %v = %q (%v), ожидается %q", tt.allowed, got, err, tt.want)
+			continue
+		}
+		// запись разбирается в те же значения
+		rule, err := ParseValidationRule(got)
+		if err != nil || len(rule.Allowed) != 2 {
+			t.Errorf("%q: %+v (%v)", got, rule, err)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("XM_SET", "value")
+	t.Setenv("XM_EMPTY", "")
+	os.Unsetenv("XM_UNSET")
+
+	tests := []struct {
+		in      string
+		want    string
+		missing string
+	}{
+		{"plain", "plain", ""},
+		{"${XM_SET}/out", "value/out", ""},
+		{"${XM_EMPTY}", "", ""},
+		{"a${XM_EMPTY}b", "ab", ""},
+		{"${XM_EMPTY:-default}", "default", ""},
+		{"${XM_UNSET:-default}", "default", ""},
+		{"${XM_SET:-default}", "value", ""},
+		{"${XM_UNSET:-}", "", ""},
+		{"$$HOME and $${XM_SET}", "$HOME and ${XM_SET}", ""},
+		{"$HOME", "$HOME", ""},
+		{"${XM_UNSET}", "", "XM_UNSET"},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv(tt.in)
+		if tt.missing != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.missing) {
+				t.Errorf("expandEnv(%q): ошибка = %v, ожидается упоминание %s", tt.in, err, tt.missing)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, %v; ожидается %q", tt.in, got, err, tt.want)
+		}
+	}
+}
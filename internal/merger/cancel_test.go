@@ -0,0 +1,121 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+// nopWriteCloser - приемник CreateFile, отбрасывающий данные
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestMergeFilesCancel(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for f := 0; f < 3; f++ {
+		var b strings.Builder
+		b.WriteString("Код,Имя\n")
+		for r := 0; r < 200; r++ {
+			fmt.Fprintf(&b, "%d,имя %d\n", f*1000+r, r)
+		}
+		files[fmt.Sprintf("f%d.csv", f)] = b.String()
+	}
+	writeFiles(t, dir, files)
+
+	tests := []struct {
+		name   string
+		output string
+		sink   bool
+		setup  func(cfg *config.Config)
+	}{
+		{"CSV", "out.csv", false, nil},
+		// первая часть с листом Manifest ждет конца слияния, отклоненные строки - в книге
+		{"XLSX с отложенной частью и отклоненными строками", "out.xlsx", false, func(cfg *config.Config) {
+			cfg.Manifest = true
+			// нечетные строки отклоняются
+			rule, _ := config.ParseValidationRule("Имя:regex=[02468]$")
+			cfg.Rules = []config.ValidationRule{rule}
+		}},
+		{"CreateFile", "out.csv", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outDir := t.TempDir()
+			cfg := csvConfig(t, dir)
+			cfg.OutputPath = filepath.Join(outDir, tt.output)
+			cfg.MaxRowPerFile = 20
+			cfg.Workers = 1
+			cfg.BufferRows = 1 // читатель не успевает прочитать все файлы до отмены
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			if err := cfg.Normalize(); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sm := NewStreamMerger().(*StreamMerger)
+			var mu sync.Mutex
+			var created []string
+			var rejected int64
+			if tt.sink {
+				sm.CreateFile = func(name string) (io.WriteCloser, error) {
+					mu.Lock()
+					created = append(created, name)
+					mu.Unlock()
+					return nopWriteCloser{io.Discard}, nil
+				}
+			}
+			sm.Progress = func(ev ProgressEvent) {
+				// отмена, когда сохранена или отложена хотя бы одна часть
+				if ev.Type == EventPartStarted && ev.Part >= 3 {
+					rejected = ev.Rejected
+					cancel()
+				}
+			}
+			res, err := sm.MergeFiles(ctx, cfg)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("ошибка %v, ожидается context.Canceled", err)
+			}
+			if !res.Cancelled || res.RowCount == 0 {
+				t.Errorf("Cancelled = %v, записано строк %d", res.Cancelled, res.RowCount)
+			}
+			if len(cfg.Rules) > 0 && rejected == 0 {
+				t.Error("до отмены не отклонено ни одной строки")
+			}
+			skipped := 0
+			for _, f := range res.Files {
+				if f.Status == FileSkipped {
+					skipped++
+				}
+			}
+			if skipped == 0 {
+				t.Errorf("итоги по файлам %+v, ожидаются необработанные файлы", res.Files)
+			}
+
+			entries, _ := os.ReadDir(outDir)
+			if len(entries) > 0 {
+				t.Errorf("остались файлы %v", entries)
+			}
+			if tt.sink {
+				// созданные через CreateFile файлы удаляет вызывающая сторона
+				if len(res.OutputFiles) < 2 || len(res.OutputFiles) != len(created) {
+					t.Errorf("части %v, созданы %v", res.OutputFiles, created)
+				}
+			} else if len(res.OutputFiles) != 0 || res.RejectedRows != 0 {
+				t.Errorf("части %v, отклонено %d: ожидается, что они удалены", res.OutputFiles, res.RejectedRows)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package merger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// csvSource читает текстовые таблицы CSV/TSV как источник с одним листом,
+// названным по имени файла
+type csvSource struct {
+	path      string
+	sheet     string
+	delimiter rune
+	quote     rune
+	enc       encoding.Encoding
+	read      *atomic.Int64 // счетчик прочитанных байт для отчета о ходе слияния
+}
+
+// openCSVSource проверяет доступность файла и готовит параметры разбора.
+// Разделитель по умолчанию - запятая для .csv и табуляция для .tsv.
+func openCSVSource(path string, cfg *config.Config) (*csvSource, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("ошибка открытия файла %s: %v", path, err)
+	}
+
+	src := &csvSource{
+		path:      path,
+		sheet:     strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		delimiter: ',',
+		quote:     '"',
+	}
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		src.delimiter = '\t'
+	}
+	if cfg.CSVDelimiter != "" {
+		src.delimiter, _ = utf8.DecodeRuneInString(cfg.CSVDelimiter)
+	}
+	if cfg.CSVQuote != "" {
+		src.quote, _ = utf8.DecodeRuneInString(cfg.CSVQuote)
+	}
+
+	enc, err := csvEncoding(cfg.CSVEncoding)
+	if err != nil {
+		return nil, err
+	}
+	src.enc = enc
+	return src, nil
+}
+
+// csvEncoding возвращает кодировку по имени (utf-8, windows-1251, koi8-r, utf-16le...)
+func csvEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "utf-8", "utf8":
+		return unicode.UTF8BOM, nil
+	case "cp1251":
+		name = "windows-1251"
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("неизвестная кодировка %q: %v", name, err)
+	}
+	return enc, nil
+}
+
+func (s *csvSource) Sheets() []string { return []string{s.sheet} }
+
+func (s *csvSource) Date1904() bool { return false }
+
+func (s *csvSource) Close() error { return nil }
+
+// CellType у текстовых данных не определен: тип задается шаблоном
+func (s *csvSource) CellType(string, int, int) excelize.CellType {
+	return excelize.CellTypeUnset
+}
+
+func (s *csvSource) Rows(string) (rowIterator, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = f
+	if s.read != nil {
+		r = &countingReader{r: f, n: s.read}
+	}
+	return &csvRows{
+		file:      f,
+		r:         bufio.NewReader(s.enc.NewDecoder().Reader(r)),
+		delimiter: s.delimiter,
+		quote:     s.quote,
+	}, nil
+}
+
+// csvRows разбирает записи CSV с произвольными разделителем и символом кавычек.
+// Поля в кавычках могут содержать разделители и переводы строк,
+// кавычка внутри такого поля удваивается.
+type csvRows struct {
+	file      *os.File
+	r         *bufio.Reader
+	delimiter rune
+	quote     rune
+	record    []string
+	err       error
+}
+
+func (c *csvRows) Next() bool {
+	if c.err != nil {
+		return false
+	}
+	c.record, c.err = c.readRecord()
+	return c.err == nil
+}
+
+func (c *csvRows) Columns() ([]string, error) {
+	return c.record, nil
+}
+
+func (c *csvRows) Height() float64 { return 0 }
+
+// Error возвращает ошибку разбора (конец файла ошибкой не считается)
+func (c *csvRows) Error() error {
+	if errors.Is(c.err, io.EOF) {
+		return nil
+	}
+	return c.err
+}
+
+func (c *csvRows) Close() error { return c.file.Close() }
+
+// readRecord читает одну запись; пустые строки пропускаются
+func (c *csvRows) readRecord() ([]string, error) {
+	var (
+		record   []string
+		field    strings.Builder
+		quoted   bool // внутри поля в кавычках
+		started  bool // в записи прочитан хотя бы один символ
+		wasQuote bool // текущее поле начиналось с кавычки
+	)
+	endRecord := func() bool {
+		record = append(record, field.String())
+		field.Reset()
+		if len(record) == 1 && record[0] == "" && !wasQuote {
+			// пустая строка
+			record, started = nil, false
+			return false
+		}
+		return true
+	}
+
+	for {
+		r, _, err := c.r.ReadRune()
+		if err != nil {
+			if errors.Is(err, io.EOF) && started {
+				if quoted {
+					return nil, fmt.Errorf("незакрытая кавычка в конце файла")
+				}
+				if endRecord() {
+					return record, nil
+				}
+			}
+			return nil, err
+		}
+		started = true
+
+		if quoted {
+			if r == c.quote {
+				next, _, err := c.r.ReadRune()
+				if err == nil && next == c.quote {
+					field.WriteRune(c.quote)
+					continue
+				}
+				if err == nil {
+					_ = c.r.UnreadRune()
+				}
+				quoted = false
+				continue
+			}
+			field.WriteRune(r)
+			continue
+		}
+
+		switch {
+		case r == c.quote && field.Len() == 0 && !wasQuote:
+			quoted, wasQuote = true, true
+		case r == c.delimiter:
+			record = append(record, field.String())
+			field.Reset()
+			wasQuote = false
+		case r == '\r' || r == '\n':
+			// \r\n и одиночные \r, \n завершают запись
+			if r == '\r' {
+				if next, _, err := c.r.ReadRune(); err == nil && next != '\n' {
+					_ = c.r.UnreadRune()
+				}
+			}
+			if endRecord() {
+				return record, nil
+			}
+			wasQuote = false
+		default:
+			field.WriteRune(r)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// readCSVSource читает все записи файла через csvSource
+func readCSVSource(t *testing.T, path string, cfg *config.Config) ([][]string, error) {
+	t.Helper()
+	src, err := openCSVSource(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if sheets := src.Sheets(); len(sheets) != 1 || sheets[0] != strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) {
+		t.Errorf("%s: листы %v", path, sheets)
+	}
+	rows, err := src.Rows(src.Sheets()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var records [][]string
+	for rows.Next() {
+		record, _ := rows.Columns()
+		records = append(records, record)
+	}
+	return records, rows.Error()
+}
+
+func TestCSVSource(t *testing.T) {
+	win1251, _ := charmap.Windows1251.NewEncoder().String("Код;Имя\n1;Ёлка\n")
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		setup   func(cfg *config.Config)
+		want    [][]string
+	}{
+		{
+			name: "запятая, BOM и CRLF", file: "a.csv",
+			content: "\ufeffКод,Имя\r\n1,Анна\r\n",
+			want:    [][]string{{"Код", "Имя"}, {"1", "Анна"}},
+		},
+		{
+			name: "табуляция для .tsv", file: "a.tsv",
+			content: "Код\tИмя\n1\tАнна, Борис\n",
+			want:    [][]string{{"Код", "Имя"}, {"1", "Анна, Борис"}},
+		},
+		{
+			name: "кавычки: разделители, переводы строк и удвоенные кавычки", file: "a.csv",
+			content: "Код,Имя\n1,\"Иванов, Иван\"\n2,\"строка 1\nстрока 2\"\n3,\"ООО \"\"Ромашка\"\"\"\n",
+			want:    [][]string{{"Код", "Имя"}, {"1", "Иванов, Иван"}, {"2", "строка 1\nстрока 2"}, {"3", `ООО "Ромашка"`}},
+		},
+		{
+			name: "пустые строки пропускаются, пустое поле в кавычках - запись", file: "a.csv",
+			content: "Код\n\n1\n\r\n\"\"\n2",
+			want:    [][]string{{"Код"}, {"1"}, {""}, {"2"}},
+		},
+		{
+			name: "кавычка внутри поля без кавычек - обычный символ", file: "a.csv",
+			content: "Имя\nдюйм 5\"\n",
+			want:    [][]string{{"Имя"}, {`дюйм 5"`}},
+		},
+		{
+			name: "свои разделитель и кавычки", file: "a.csv",
+			content: "Код;Имя\n1;'a;b'\n",
+			setup:   func(cfg *config.Config) { cfg.CSVDelimiter, cfg.CSVQuote = ";", "'" },
+			want:    [][]string{{"Код", "Имя"}, {"1", "a;b"}},
+		},
+		{
+			name: "кодировка windows-1251", file: "a.csv",
+			content: win1251,
+			setup:   func(cfg *config.Config) { cfg.CSVDelimiter, cfg.CSVEncoding = ";", "cp1251" },
+			want:    [][]string{{"Код", "Имя"}, {"1", "Ёлка"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cfg := config.Default()
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			got, err := readCSVSource(t, path, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("записи %q, ожидается %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVSourceErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.csv")
+	if err := os.WriteFile(path, []byte("Код\n1\n\"2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readCSVSource(t, path, config.Default())
+	if err == nil || !strings.Contains(err.Error(), "незакрытая кавычка") {
+		t.Errorf("ошибка %v, ожидается незакрытая кавычка", err)
+	}
+	if !reflect.DeepEqual(got, [][]string{{"Код"}, {"1"}}) {
+		t.Errorf("до ошибки прочитано %q", got)
+	}
+
+	cfg := config.Default()
+	cfg.CSVEncoding = "нет-такой"
+	if _, err := openCSVSource(path, cfg); err == nil {
+		t.Error("ожидается ошибка неизвестной кодировки")
+	}
+	if _, err := openCSVSource(filepath.Join(dir, "нет.csv"), config.Default()); err == nil {
+		t.Error("ожидается ошибка открытия файла")
+	}
+}
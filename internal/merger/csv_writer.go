@@ -0,0 +1,148 @@
+package merger
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// csvWriter пишет результат в CSV: UTF-8 без BOM, разделитель - запятая,
+// первая строка - заголовки (если -has-headers).
+// Количество полей в строке равно числу колонок схемы.
+type csvWriter struct {
+	sm    *StreamMerger
+	w     *csv.Writer
+	rows  int64
+	names []string
+}
+
+func (c *csvWriter) NewPart(w io.Writer) error {
+	if c.names == nil {
+		c.names = c.sm.columnNames()
+	}
+	c.w = csv.NewWriter(w)
+	c.rows = 0
+	if c.sm.headerRows() > 0 {
+		if err := c.w.Write(c.names); err != nil {
+			return fmt.Errorf("ошибка записи заголовка: %v", err)
+		}
+		c.rows++
+	}
+	return nil
+}
+
+func (c *csvWriter) RowCount(string) (int64, error) { return c.rows, nil }
+
+func (c *csvWriter) WriteRow(_ string, cells []interface{}, _ float64) error {
+	record := make([]string, len(c.names))
+	for i := range record {
+		if i < len(cells) {
+			record[i] = c.sm.formatPlainValue(c.sm.plainValue(cells[i], i), i)
+		}
+	}
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	c.rows++
+	return nil
+}
+
+func (c *csvWriter) AbortPart() {}
+
+func (c *csvWriter) ClosePart() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlWriter пишет результат в JSON Lines: одна строка - один объект,
+// ключи - имена колонок. Числа и логические значения пишутся как есть,
+// даты - строками 2006-01-02[ 15:04:05], пустые ячейки - null.
+type jsonlWriter struct {
+	sm   *StreamMerger
+	w    *bufio.Writer
+	rows int64
+	keys [][]byte // имена колонок в виде JSON строк
+	line []byte
+}
+
+func (j *jsonlWriter) NewPart(w io.Writer) error {
+	if j.keys == nil {
+		for _, name := range j.sm.columnNames() {
+			key, _ := json.Marshal(name)
+			j.keys = append(j.keys, key)
+		}
+	}
+	j.w = bufio.NewWriter(w)
+	// строки заголовка в JSON Lines нет, но она учитывается при делении на части
+	j.rows = j.sm.headerRows()
+	return nil
+}
+
+func (j *jsonlWriter) RowCount(string) (int64, error) { return j.rows, nil }
+
+func (j *jsonlWriter) WriteRow(_ string, cells []interface{}, _ float64) error {
+	line := append(j.line[:0], '{')
+	for i, key := range j.keys {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(line, key...)
+		line = append(line, ':')
+
+		var v interface{}
+		if i < len(cells) {
+			v = j.sm.plainValue(cells[i], i)
+		}
+		switch val := v.(type) {
+		case nil:
+			line = append(line, "null"...)
+			continue
+		case float64:
+			class := j.sm.formatClass(i)
+			if !math.IsNaN(val) && !math.IsInf(val, 0) && class != NumFmtTime && class != NumFmtDuration {
+				line, _ = appendJSON(line, val)
+				continue
+			}
+		case bool:
+			line, _ = appendJSON(line, val)
+			continue
+		case time.Time:
+		case string:
+			if val == "" {
+				line = append(line, "null"...)
+				continue
+			}
+		}
+		var err error
+		if line, err = appendJSON(line, j.sm.formatPlainValue(v, i)); err != nil {
+			return err
+		}
+	}
+	line = append(line, '}', '\n')
+	j.line = line
+
+	if _, err := j.w.Write(line); err != nil {
+		return err
+	}
+	j.rows++
+	return nil
+}
+
+func (j *jsonlWriter) AbortPart() {}
+
+func (j *jsonlWriter) ClosePart() error {
+	return j.w.Flush()
+}
+
+// appendJSON дописывает значение в формате JSON
+func appendJSON(dst []byte, v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, b...), nil
+}
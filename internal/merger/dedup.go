@@ -0,0 +1,467 @@
+package merger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+// Параметры набора ключей повторов
+const (
+	dedupEntrySize  = 48 // оценка памяти ключа в map
+	dedupMaxRuns    = 8  // число файлов ключей на диске, после которого они сливаются в один
+	bloomBitsPerKey = 10 // около 1% ложных срабатываний фильтра Блума
+	bloomHashes     = 7
+)
+
+// dedupKey - 128-битный хеш значений ключевых колонок строки
+type dedupKey [16]byte
+
+// deduper удаляет повторяющиеся строки между обработкой входных файлов и записью результата.
+// Ключ строки - хеш значений колонок ключа (или всех колонок данных).
+// Режим first пропускает строку, если ее ключ уже встречался. В режиме last строки
+// сохраняются во временный сегмент, а ключи - в отдельный файл; после чтения всех файлов
+// ключи просматриваются с конца, и при воспроизведении сегмента остаются только
+// последние вхождения.
+type deduper struct {
+	sm      *StreamMerger
+	keys    []int // колонки ключа (nil - все колонки данных)
+	last    bool
+	set     *dedupSet
+	hasher  hash.Hash
+	buf     []byte
+	tempDir string
+
+	// режим last
+	rows    *spillSegment
+	keyFile *os.File
+	keyW    *bufio.Writer
+	count   int64
+}
+
+// newDeduper создает этап удаления повторов по настройкам -dedup
+func (sm *StreamMerger) newDeduper() (*deduper, error) {
+	d := &deduper{
+		sm:     sm,
+		last:   sm.Cfg.DedupKeep == config.DedupLast,
+		hasher: fnv.New128a(),
+	}
+	for _, name := range sm.Cfg.DedupKeys {
+		col := sm.columnIndex(name)
+		if col < 0 {
+			return nil, fmt.Errorf("ключ повторов: колонка %q не найдена в шаблоне", name)
+		}
+		d.keys = append(d.keys, col)
+	}
+	d.set = &dedupSet{
+		mem:     make(map[dedupKey]struct{}),
+		maxKeys: max(sm.Cfg.DedupMB<<20/dedupEntrySize, 1),
+		dir:     d.dir,
+	}
+	if d.last {
+		d.rows = &spillSegment{dir: d.dir}
+	}
+	return d, nil
+}
+
+// dir создает при первом вызове папку временных файлов
+func (d *deduper) dir() (string, error) {
+	if d.tempDir == "" {
+		dir, err := os.MkdirTemp(d.sm.Cfg.SpillDir, "xlsx-merger-dedup-")
+		if err != nil {
+			return "", err
+		}
+		d.tempDir = dir
+	}
+	return d.tempDir, nil
+}
+
+// key вычисляет ключ строки. Стиль ячеек не учитывается; при отдельных листах
+// результата ключ включает имя листа.
+func (d *deduper) key(p RowPayload) (dedupKey, error) {
+	buf := d.buf[:0]
+	if d.sm.Cfg.SheetPerSource {
+		buf = appendString(buf, p.Sheet)
+	}
+	var err error
+	if d.keys != nil {
+		for _, col := range d.keys {
+			if buf, err = appendValue(buf, cellValue(p.Cells, col)); err != nil {
+				return dedupKey{}, err
+			}
+		}
+	} else {
+		// колонки данных без вычисляемых; пустые ячейки в конце не различают строки
+		n := d.sm.DataColumns
+		for n > 0 && cellValue(p.Cells, d.sm.dataPosition(n-1)) == nil {
+			n--
+		}
+		for i := 0; i < n; i++ {
+			if buf, err = appendValue(buf, cellValue(p.Cells, d.sm.dataPosition(i))); err != nil {
+				return dedupKey{}, err
+			}
+		}
+	}
+	d.buf = buf
+	d.hasher.Reset()
+	d.hasher.Write(buf)
+	var k dedupKey
+	d.hasher.Sum(k[:0])
+	return k, nil
+}
+
+// Write пропускает строку через этап удаления повторов.
+// В режиме first строка сразу записывается функцией write, если ее ключ новый;
+// в режиме last откладывается до Finish.
+func (d *deduper) Write(p RowPayload, write func(RowPayload) error) error {
+	k, err := d.key(p)
+	if err != nil {
+		return fmt.Errorf("ошибка вычисления ключа повторов: %v", err)
+	}
+	if d.last {
+		if d.keyFile == nil {
+			dir, err := d.dir()
+			if err == nil {
+				d.keyFile, err = os.CreateTemp(dir, "keys-*.bin")
+			}
+			if err != nil {
+				return fmt.Errorf("ошибка создания временного файла ключей: %v", err)
+			}
+			d.keyW = bufio.NewWriterSize(d.keyFile, spillBufferSize)
+		}
+		if _, err := d.keyW.Write(k[:]); err != nil {
+			return fmt.Errorf("ошибка записи временного файла ключей: %v", err)
+		}
+		if _, err := d.rows.Put(p); err != nil {
+			return err
+		}
+		d.count++
+		return nil
+	}
+	added, err := d.set.Add(k)
+	if err != nil {
+		return err
+	}
+	if !added {
+		d.sm.DuplicatesRemoved++
+		return nil
+	}
+	return write(p)
+}
+
+// Finish в режиме last записывает последние вхождения отложенных строк
+func (d *deduper) Finish(ctx context.Context, write func(RowPayload) error) error {
+	if !d.last || d.count == 0 {
+		return nil
+	}
+	if err := d.keyW.Flush(); err != nil {
+		return fmt.Errorf("ошибка записи временного файла ключей: %v", err)
+	}
+
+	// ключи с конца: строка остается, если ее ключ не встречался позже
+	keep := make([]uint64, (d.count+63)/64)
+	block := make([]byte, 4096*len(dedupKey{}))
+	for end := d.count; end > 0; {
+		n := min(end, int64(len(block)/len(dedupKey{})))
+		start := end - n
+		chunk := block[:n*int64(len(dedupKey{}))]
+		if _, err := d.keyFile.ReadAt(chunk, start*int64(len(dedupKey{}))); err != nil {
+			return fmt.Errorf("ошибка чтения временного файла ключей: %v", err)
+		}
+		for i := n - 1; i >= 0; i-- {
+			var k dedupKey
+			copy(k[:], chunk[i*int64(len(k)):])
+			added, err := d.set.Add(k)
+			if err != nil {
+				return err
+			}
+			if added {
+				row := start + i
+				keep[row/64] |= 1 << (row % 64)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end = start
+	}
+
+	if err := d.rows.seal(); err != nil {
+		return err
+	}
+	for row := int64(0); ; row++ {
+		p, ok, err := d.rows.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if row%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if keep[row/64]&(1<<(row%64)) == 0 {
+			d.sm.DuplicatesRemoved++
+			continue
+		}
+		if err := write(p); err != nil {
+			return err
+		}
+	}
+}
+
+// Close удаляет временные файлы
+func (d *deduper) Close() {
+	if d.rows != nil {
+		d.rows.remove()
+	}
+	if d.keyFile != nil {
+		d.keyFile.Close()
+	}
+	d.set.Close()
+	if d.tempDir != "" {
+		os.RemoveAll(d.tempDir)
+	}
+}
+
+// dedupSet - множество ключей, которое не помещается в память целиком.
+// Новые ключи накапливаются в map; при превышении maxKeys они сортируются и
+// сохраняются в файл на диске (run) с фильтром Блума в памяти. Поиск по файлу -
+// двоичный, и выполняется только если фильтр Блума допускает наличие ключа.
+type dedupSet struct {
+	mem     map[dedupKey]struct{}
+	maxKeys int
+	runs    []*dedupRun
+	dir     func() (string, error)
+}
+
+// dedupRun - отсортированный файл ключей
+type dedupRun struct {
+	file  *os.File
+	n     int64
+	bloom bloomFilter
+}
+
+// Add добавляет ключ и сообщает, что его еще не было
+func (s *dedupSet) Add(k dedupKey) (bool, error) {
+	if _, ok := s.mem[k]; ok {
+		return false, nil
+	}
+	for _, run := range s.runs {
+		found, err := run.contains(k)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return false, nil
+		}
+	}
+	s.mem[k] = struct{}{}
+	if len(s.mem) >= s.maxKeys {
+		if err := s.flush(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// flush сохраняет ключи из памяти в новый файл и при необходимости сливает файлы
+func (s *dedupSet) flush() error {
+	keys := make([]dedupKey, 0, len(s.mem))
+	for k := range s.mem {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b dedupKey) int { return bytes.Compare(a[:], b[:]) })
+	clear(s.mem)
+
+	next := func() (dedupKey, bool, error) {
+		if len(keys) == 0 {
+			return dedupKey{}, false, nil
+		}
+		k := keys[0]
+		keys = keys[1:]
+		return k, true, nil
+	}
+	run, err := s.writeRun(int64(len(keys)), next)
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	if len(s.runs) > dedupMaxRuns {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact сливает все файлы ключей в один
+func (s *dedupSet) compact() error {
+	var total int64
+	readers := make([]*bufio.Reader, len(s.runs))
+	heads := make([]dedupKey, len(s.runs))
+	left := make([]int64, len(s.runs))
+	for i, run := range s.runs {
+		total += run.n
+		readers[i] = bufio.NewReaderSize(io.NewSectionReader(run.file, 0, run.n*int64(len(dedupKey{}))), spillBufferSize)
+		left[i] = run.n
+	}
+	advance := func(i int) error {
+		if left[i] == 0 {
+			return nil
+		}
+		left[i]--
+		_, err := io.ReadFull(readers[i], heads[i][:])
+		return err
+	}
+	for i := range s.runs {
+		if err := advance(i); err != nil {
+			return fmt.Errorf("ошибка чтения файла ключей: %v", err)
+		}
+	}
+	// в файлах ключи не повторяются: каждый ключ добавлялся, только если его не было
+	next := func() (dedupKey, bool, error) {
+		best := -1
+		for i := range heads {
+			if left[i] < 0 {
+				continue
+			}
+			if best < 0 || bytes.Compare(heads[i][:], heads[best][:]) < 0 {
+				best = i
+			}
+		}
+		if best < 0 {
+			return dedupKey{}, false, nil
+		}
+		k := heads[best]
+		if left[best] == 0 {
+			left[best] = -1
+		} else if err := advance(best); err != nil {
+			return dedupKey{}, false, fmt.Errorf("ошибка чтения файла ключей: %v", err)
+		}
+		return k, true, nil
+	}
+	run, err := s.writeRun(total, next)
+	if err != nil {
+		return err
+	}
+	s.Close()
+	s.runs = []*dedupRun{run}
+	return nil
+}
+
+// writeRun записывает n отсортированных ключей в новый файл и строит для него фильтр Блума
+func (s *dedupSet) writeRun(n int64, next func() (dedupKey, bool, error)) (*dedupRun, error) {
+	dir, err := s.dir()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания папки временных файлов: %v", err)
+	}
+	file, err := os.CreateTemp(dir, "run-*.bin")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания файла ключей: %v", err)
+	}
+	run := &dedupRun{file: file, n: n, bloom: newBloomFilter(n)}
+	w := bufio.NewWriterSize(file, spillBufferSize)
+	for {
+		k, ok, err := next()
+		if err != nil {
+			run.remove()
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		run.bloom.add(k)
+		if _, err := w.Write(k[:]); err != nil {
+			run.remove()
+			return nil, fmt.Errorf("ошибка записи файла ключей: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		run.remove()
+		return nil, fmt.Errorf("ошибка записи файла ключей: %v", err)
+	}
+	return run, nil
+}
+
+// Close удаляет файлы ключей
+func (s *dedupSet) Close() {
+	for _, run := range s.runs {
+		run.remove()
+	}
+	s.runs = nil
+}
+
+// contains ищет ключ в файле двоичным поиском
+func (r *dedupRun) contains(k dedupKey) (bool, error) {
+	if !r.bloom.has(k) {
+		return false, nil
+	}
+	var cur dedupKey
+	lo, hi := int64(0), r.n
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if _, err := r.file.ReadAt(cur[:], mid*int64(len(cur))); err != nil {
+			return false, fmt.Errorf("ошибка чтения файла ключей: %v", err)
+		}
+		switch c := bytes.Compare(cur[:], k[:]); {
+		case c == 0:
+			return true, nil
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+func (r *dedupRun) remove() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+}
+
+// bloomFilter - фильтр Блума по ключам, которые уже являются хешами:
+// позиции вычисляются двойным хешированием из двух половин ключа
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+}
+
+func newBloomFilter(n int64) bloomFilter {
+	m := uint64(max(n, 1)) * bloomBitsPerKey
+	return bloomFilter{bits: make([]uint64, (m+63)/64), m: m}
+}
+
+func (b *bloomFilter) positions(k dedupKey, fn func(uint64) bool) bool {
+	h1 := binary.LittleEndian.Uint64(k[:8])
+	h2 := binary.LittleEndian.Uint64(k[8:]) | 1
+	for i := uint64(0); i < bloomHashes; i++ {
+		if !fn((h1 + i*h2) % b.m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) add(k dedupKey) {
+	b.positions(k, func(p uint64) bool {
+		b.bits[p/64] |= 1 << (p % 64)
+		return true
+	})
+}
+
+func (b *bloomFilter) has(k dedupKey) bool {
+	return b.positions(k, func(p uint64) bool {
+		return b.bits[p/64]&(1<<(p%64)) != 0
+	})
+}
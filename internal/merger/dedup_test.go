@@ -0,0 +1,160 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+// dedupRows пропускает строки через этап удаления повторов и возвращает
+// номера исходных строк в порядке записи
+func dedupRows(t *testing.T, cfg *config.Config, rows [][]interface{}) ([]int, int64) {
+	t.Helper()
+	sm := schemaMerger(cfg, "Код", "Имя", "Сумма")
+	d, err := sm.newDeduper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	var out []int
+	write := func(p RowPayload) error {
+		out = append(out, p.SourceRow)
+		return nil
+	}
+	for i, cells := range rows {
+		if err := d.Write(RowPayload{Cells: cells, SourceRow: i + 1}, write); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Finish(context.Background(), write); err != nil {
+		t.Fatal(err)
+	}
+	return out, sm.DuplicatesRemoved
+}
+
+func TestDeduperOrder(t *testing.T) {
+	rows := [][]interface{}{
+		{1.0, "a", 10.0},         // 1
+		{2.0, "b", 20.0},         // 2
+		{1.0, "a", 10.0},         // 3: повтор 1
+		{1.0, "a", 11.0},         // 4: тот же код, другая сумма
+		{"1", "a", 10.0},         // 5: текст "1" не равен числу 1
+		{2.0, "b", 20.0, nil},    // 6: пустые ячейки в конце не различают строки
+		{3.0, nil, nil},          // 7
+		{1.0, "a", 10.0},         // 8: повтор 1
+		{3.0, "c", int64(30)},    // 9
+		{2.0, "B", 20.0},         // 10: регистр различает строки
+		{3.0, nil, nil, "хвост"}, // 11: хвост за колонками данных не учитывается
+	}
+	tests := []struct {
+		name  string
+		keep  string
+		keys  []string
+		want  []int
+		dupes int64
+	}{
+		{"first, вся строка", config.DedupFirst, nil, []int{1, 2, 4, 5, 7, 9, 10}, 4},
+		{"last, вся строка", config.DedupLast, nil, []int{4, 5, 6, 8, 9, 10, 11}, 4},
+		{"first, ключ Код", config.DedupFirst, []string{"Код"}, []int{1, 2, 5, 7}, 7},
+		{"last, ключ Код", config.DedupLast, []string{"Код"}, []int{5, 8, 10, 11}, 7},
+		{"first, ключ Код и Имя", config.DedupFirst, []string{"код", "B"}, []int{1, 2, 5, 7, 9, 10}, 5},
+		{"last, ключ Код и Имя", config.DedupLast, []string{"код", "B"}, []int{5, 6, 8, 9, 10, 11}, 5},
+	}
+	for _, tt := range tests {
+		// 0 МБ - каждый ключ сразу уходит на диск, файлы ключей сливаются
+		for _, mb := range []int{64, 0} {
+			t.Run(fmt.Sprintf("%s/%dMB", tt.name, mb), func(t *testing.T) {
+				cfg := config.Default()
+				cfg.HasHeaders = true
+				cfg.SpillDir = t.TempDir()
+				cfg.DedupKeep = tt.keep
+				cfg.DedupKeys = tt.keys
+				cfg.DedupMB = mb
+				got, dupes := dedupRows(t, cfg, rows)
+				if !reflect.DeepEqual(got, tt.want) || dupes != tt.dupes {
+					t.Errorf("строки %v (повторов %d), ожидается %v (%d)", got, dupes, tt.want, tt.dupes)
+				}
+			})
+		}
+	}
+}
+
+func TestDeduperManyKeysOnDisk(t *testing.T) {
+	cfg := config.Default()
+	cfg.HasHeaders = true
+	cfg.SpillDir = t.TempDir()
+	cfg.DedupMB = 0
+	// больше dedupMaxRuns файлов ключей: проверяется их слияние
+	const n = dedupMaxRuns*4 + 3
+	var rows [][]interface{}
+	var want []int
+	for i := 0; i < 2*n; i++ {
+		rows = append(rows, []interface{}{float64(i % n)})
+		if i < n {
+			want = append(want, i+1)
+		}
+	}
+	got, dupes := dedupRows(t, cfg, rows)
+	if !reflect.DeepEqual(got, want) || dupes != n {
+		t.Errorf("строки %v (повторов %d), ожидаются первые %d строк", got, dupes, n)
+	}
+}
+
+func TestDedupSetRuns(t *testing.T) {
+	cfg := config.Default()
+	cfg.SpillDir = t.TempDir()
+	cfg.DedupMB = 0 // каждый ключ сразу уходит в отдельный файл
+	d, err := schemaMerger(cfg, "Код").newDeduper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	key := func(i int) dedupKey {
+		k, err := d.key(RowPayload{Cells: []interface{}{float64(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	const n = dedupMaxRuns*2 + 3
+	for i := 0; i < n; i++ {
+		if added, err := d.set.Add(key(i)); err != nil || !added {
+			t.Fatalf("ключ %d: добавлен %v, ошибка %v", i, added, err)
+		}
+		if len(d.set.mem) != 0 || len(d.set.runs) > dedupMaxRuns {
+			t.Fatalf("ключ %d: в памяти %d, файлов %d", i, len(d.set.mem), len(d.set.runs))
+		}
+	}
+	// после двух слияний: один общий файл и по файлу на ключ после него
+	var total int64
+	for _, run := range d.set.runs {
+		total += run.n
+	}
+	if total != n || d.set.runs[0].n != 2*(dedupMaxRuns+1)-1 {
+		t.Errorf("ключей в файлах %d, в первом %d", total, d.set.runs[0].n)
+	}
+
+	for i := 0; i < n; i++ {
+		if added, err := d.set.Add(key(i)); err != nil || added {
+			t.Errorf("повтор ключа %d: добавлен %v, ошибка %v", i, added, err)
+		}
+	}
+	for _, run := range d.set.runs {
+		if found, err := run.contains(key(n)); err != nil || found {
+			t.Errorf("отсутствующий ключ найден: %v, ошибка %v", found, err)
+		}
+	}
+}
+
+func TestDeduperUnknownKey(t *testing.T) {
+	cfg := config.Default()
+	cfg.HasHeaders = true
+	cfg.DedupKeys = []string{"Город"}
+	if _, err := schemaMerger(cfg, "Код").newDeduper(); err == nil {
+		t.Error("ожидается ошибка неизвестной колонки ключа")
+	}
+}
@@ -0,0 +1,277 @@
+package merger
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+)
+
+// derivedColumn - вычисляемая колонка, привязанная к позиции в схеме результата
+type derivedColumn struct {
+	pos      int // позиция колонки в результате
+	source   string
+	pattern  *regexp.Regexp // для source=regex
+	expr     filterValue    // для source=expr
+	styleID  int            // стиль значений
+	template int            // колонка шаблона, с которой берется ширина (-1 - нет)
+}
+
+// dataPosition возвращает позицию колонки данных i в результате.
+// Колонки за пределами схемы (строки длиннее шаблона) идут после всех колонок схемы.
+func (sm *StreamMerger) dataPosition(i int) int {
+	switch {
+	case sm.dataPos == nil:
+		return i
+	case i < len(sm.dataPos):
+		return sm.dataPos[i]
+	}
+	return len(sm.Headers) + i - sm.DataColumns
+}
+
+// isDerived сообщает, что позиция col занята вычисляемой колонкой
+func (sm *StreamMerger) isDerived(col int) bool {
+	for _, d := range sm.derived {
+		if d.pos == col {
+			return true
+		}
+	}
+	return false
+}
+
+// templateColumn возвращает колонку шаблона для позиции результата (-1, если ее нет)
+func (sm *StreamMerger) templateColumn(pos int) int {
+	for _, d := range sm.derived {
+		if d.pos == pos {
+			return d.template
+		}
+	}
+	if sm.dataPos == nil {
+		return pos
+	}
+	if i := slices.Index(sm.dataPos, pos); i >= 0 {
+		return i
+	}
+	return -1
+}
+
+// addDerivedColumns вставляет вычисляемые колонки (-column и -add-source) в схему результата.
+// Вызывается после расширения схемы колонками исходных файлов: позиции, after и before
+// отсчитываются от колонок данных и уже добавленных вычисляемых колонок.
+func (sm *StreamMerger) addDerivedColumns() error {
+	sm.derived, sm.dataPos = nil, nil
+	columns := sm.Cfg.Columns
+	if sm.Cfg.AddSourceFile {
+		columns = append([]config.DerivedColumn{{Header: "SourceFile", Source: config.DerivedFile}}, columns...)
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	sm.dataPos = make([]int, sm.DataColumns)
+	for i := range sm.dataPos {
+		sm.dataPos[i] = i
+	}
+	headerStyle := 0
+	if sm.DataColumns > 0 {
+		headerStyle = sm.HeaderStyles[sm.DataColumns-1]
+	}
+
+	for _, c := range columns {
+		pos, err := sm.derivedPosition(c)
+		if err != nil {
+			return err
+		}
+		d := derivedColumn{pos: pos, source: c.Source, template: -1}
+		hStyle := headerStyle
+		if c.Style != "" {
+			col := sm.columnIndex(c.Style)
+			if col < 0 {
+				return fmt.Errorf("вычисляемая колонка %q: колонка стиля %q не найдена в шаблоне", c.Header, c.Style)
+			}
+			hStyle, d.styleID, d.template = sm.HeaderStyles[col], sm.RowStyles[col], sm.templateColumn(col)
+		}
+		if c.Source == config.DerivedRegex {
+			if d.pattern, err = regexp.Compile(c.Regex); err != nil {
+				return fmt.Errorf("вычисляемая колонка %q: некорректное регулярное выражение: %v", c.Header, err)
+			}
+		}
+		valType, class := excelize.CellTypeInlineString, NumFmtGeneral
+		switch c.Source {
+		case config.DerivedRow:
+			valType = excelize.CellTypeNumber
+		case config.DerivedModTime:
+			valType, class = excelize.CellTypeDate, NumFmtDate
+		}
+
+		for i, p := range sm.dataPos {
+			if p >= pos {
+				sm.dataPos[i]++
+			}
+		}
+		for i := range sm.derived {
+			if sm.derived[i].pos >= pos {
+				sm.derived[i].pos++
+			}
+		}
+		sm.Headers = slices.Insert(sm.Headers, pos, c.Header)
+		sm.HeaderStyles = slices.Insert(sm.HeaderStyles, pos, hStyle)
+		sm.RowStyles = slices.Insert(sm.RowStyles, pos, d.styleID)
+		sm.ValueTypes = slices.Insert(sm.ValueTypes, pos, valType)
+		sm.FormatClasses = slices.Insert(sm.FormatClasses, pos, class)
+		sm.derived = append(sm.derived, d)
+	}
+
+	// выражения разбираются после размещения всех колонок и ссылаются на итоговые позиции;
+	// значения вычисляются по порядку объявления колонок
+	for i, c := range columns {
+		if c.Source != config.DerivedExpr {
+			continue
+		}
+		d := &sm.derived[i]
+		expr, err := sm.compileValue(c.Expr)
+		if err != nil {
+			return fmt.Errorf("вычисляемая колонка %q: %v", c.Header, err)
+		}
+		d.expr = expr
+		sm.ValueTypes[d.pos], sm.FormatClasses[d.pos] = sm.exprType(expr)
+	}
+	return nil
+}
+
+// derivedPosition определяет позицию вставки вычисляемой колонки в текущую схему
+func (sm *StreamMerger) derivedPosition(c config.DerivedColumn) (int, error) {
+	switch {
+	case c.After != "" || c.Before != "":
+		ref := c.After + c.Before
+		col := sm.columnIndex(ref)
+		if col < 0 {
+			return 0, fmt.Errorf("вычисляемая колонка %q: колонка %q не найдена в шаблоне", c.Header, ref)
+		}
+		if c.After != "" {
+			col++
+		}
+		return col, nil
+	}
+	pos := strings.TrimSpace(c.Position)
+	switch strings.ToLower(pos) {
+	case "", "last":
+		return len(sm.Headers), nil
+	case "first":
+		return 0, nil
+	}
+	n, err := strconv.Atoi(pos)
+	if err != nil {
+		n, err = excelize.ColumnNameToNumber(pos)
+	}
+	if err != nil || n < 1 || n > len(sm.Headers)+1 {
+		return 0, fmt.Errorf("вычисляемая колонка %q: некорректная позиция %q (first, last, буква или номер до %d)", c.Header, c.Position, len(sm.Headers)+1)
+	}
+	return n - 1, nil
+}
+
+// exprType определяет тип значений и класс формата колонки по выражению
+func (sm *StreamMerger) exprType(v filterValue) (excelize.CellType, NumFmtClass) {
+	switch e := v.(type) {
+	case filterOperand:
+		if e.col >= 0 {
+			valType := excelize.CellTypeInlineString
+			if e.col < len(sm.ValueTypes) {
+				valType = sm.ValueTypes[e.col]
+			}
+			return valType, sm.formatClass(e.col)
+		}
+		switch e.literal.(type) {
+		case float64:
+			return excelize.CellTypeNumber, NumFmtGeneral
+		case bool:
+			return excelize.CellTypeBool, NumFmtGeneral
+		}
+	case filterBool:
+		return excelize.CellTypeBool, NumFmtGeneral
+	case filterNeg:
+		if _, class := sm.exprType(e.v); class == NumFmtTime || class == NumFmtDuration {
+			return excelize.CellTypeNumber, class
+		}
+		return excelize.CellTypeNumber, NumFmtGeneral
+	case filterArith:
+		if e.op == "&" {
+			break
+		}
+		_, l := sm.exprType(e.l)
+		_, r := sm.exprType(e.r)
+		switch {
+		case e.op == "-" && l == NumFmtDate && r == NumFmtDate:
+			return excelize.CellTypeNumber, NumFmtGeneral
+		case (e.op == "+" || e.op == "-") && (l == NumFmtDate || r == NumFmtDate):
+			return excelize.CellTypeDate, NumFmtDate
+		case (e.op == "+" || e.op == "-") && (l == NumFmtTime || l == NumFmtDuration):
+			return excelize.CellTypeNumber, l
+		case (e.op == "+" || e.op == "-") && (r == NumFmtTime || r == NumFmtDuration):
+			return excelize.CellTypeNumber, r
+		}
+		return excelize.CellTypeNumber, NumFmtGeneral
+	}
+	return excelize.CellTypeInlineString, NumFmtGeneral
+}
+
+// fileDerived вычисляет значения вычисляемых колонок, общие для всех строк файла
+func (sm *StreamMerger) fileDerived(job FileJob) []interface{} {
+	if len(sm.derived) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(sm.derived))
+	for i, d := range sm.derived {
+		switch d.source {
+		case config.DerivedFile:
+			values[i] = job.RelPath
+		case config.DerivedModTime:
+			if !job.ModTime.IsZero() {
+				values[i] = job.ModTime.Truncate(time.Second)
+			}
+		case config.DerivedRegex:
+			// значение - первая группа выражения или все совпадение
+			if m := d.pattern.FindStringSubmatch(job.RelPath); m != nil {
+				v := m[0]
+				if len(m) > 1 {
+					v = m[1]
+				}
+				if v != "" {
+					values[i] = v
+				}
+			}
+		}
+	}
+	return values
+}
+
+// fillDerived записывает в строку значения вычисляемых колонок: file - значения
+// из fileDerived, sheet и row - исходный лист и номер строки. Исходный текст значений
+// попадает в raw, чтобы фильтр и правила проверки работали и с вычисляемыми колонками.
+func (sm *StreamMerger) fillDerived(cells []interface{}, raw []string, file []interface{}, sheet string, row int) {
+	for i, d := range sm.derived {
+		var v interface{}
+		switch d.source {
+		case config.DerivedSheet:
+			v = sheet
+		case config.DerivedRow:
+			v = float64(row)
+		case config.DerivedExpr:
+			v = d.expr.value(sm, cells)
+		default:
+			v = file[i]
+		}
+		if v == nil {
+			continue
+		}
+		cells[d.pos] = excelize.Cell{Value: v, StyleID: d.styleID}
+		if raw != nil {
+			raw[d.pos] = sm.formatPlainValue(v, d.pos)
+		}
+	}
+}
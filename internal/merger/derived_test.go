@@ -0,0 +1,180 @@
+package merger
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+)
+
+// derivedMerger создает схему Регион, Сумма, Дата с вычисляемыми колонками columns
+func derivedMerger(columns ...config.DerivedColumn) (*StreamMerger, error) {
+	cfg := config.Default()
+	cfg.HasHeaders = true
+	cfg.Columns = columns
+	sm := schemaMerger(cfg, "Регион", "Сумма", "Дата")
+	sm.ValueTypes = []excelize.CellType{excelize.CellTypeInlineString, excelize.CellTypeNumber, excelize.CellTypeDate}
+	sm.FormatClasses = []NumFmtClass{NumFmtGeneral, NumFmtNumber, NumFmtDate}
+	return sm, sm.addDerivedColumns()
+}
+
+func TestDerivedColumns(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	modTime := time.Date(2024, 6, 1, 10, 20, 30, 500, time.UTC)
+	job := FileJob{RelPath: "2024/msk-05.xlsx", ModTime: modTime}
+	data := []interface{}{"Москва", 100.0, day(1)}
+	tests := []struct {
+		name    string
+		column  config.DerivedColumn
+		headers []string
+		want    interface{}
+		valType excelize.CellType
+		class   NumFmtClass
+	}{
+		{
+			name:    "путь файла в конце",
+			column:  config.DerivedColumn{Header: "Файл", Source: config.DerivedFile},
+			headers: []string{"Регион", "Сумма", "Дата", "Файл"},
+			want:    "2024/msk-05.xlsx", valType: excelize.CellTypeInlineString, class: NumFmtGeneral,
+		},
+		{
+			name:    "лист первой колонкой",
+			column:  config.DerivedColumn{Header: "Лист", Source: config.DerivedSheet, Position: "first"},
+			headers: []string{"Лист", "Регион", "Сумма", "Дата"},
+			want:    "Лист1", valType: excelize.CellTypeInlineString, class: NumFmtGeneral,
+		},
+		{
+			name:    "номер строки по букве позиции",
+			column:  config.DerivedColumn{Header: "Строка", Source: config.DerivedRow, Position: "B"},
+			headers: []string{"Регион", "Строка", "Сумма", "Дата"},
+			want:    7.0, valType: excelize.CellTypeNumber, class: NumFmtGeneral,
+		},
+		{
+			name:    "время изменения до секунд",
+			column:  config.DerivedColumn{Header: "Изменен", Source: config.DerivedModTime, Before: "дата"},
+			headers: []string{"Регион", "Сумма", "Изменен", "Дата"},
+			want:    modTime.Truncate(time.Second), valType: excelize.CellTypeDate, class: NumFmtDate,
+		},
+		{
+			name:    "группа регулярного выражения",
+			column:  config.DerivedColumn{Header: "Код", Source: config.DerivedRegex, Regex: `([a-z]+)-\d+`, After: "Регион"},
+			headers: []string{"Регион", "Код", "Сумма", "Дата"},
+			want:    "msk", valType: excelize.CellTypeInlineString, class: NumFmtGeneral,
+		},
+		{
+			name:    "регулярное выражение без совпадения",
+			column:  config.DerivedColumn{Header: "Код", Source: config.DerivedRegex, Regex: `spb`},
+			headers: []string{"Регион", "Сумма", "Дата", "Код"},
+			want:    nil, valType: excelize.CellTypeInlineString, class: NumFmtGeneral,
+		},
+		{
+			name:    "арифметика - число",
+			column:  config.DerivedColumn{Header: "НДС", Source: config.DerivedExpr, Expr: "Сумма * 0.2"},
+			headers: []string{"Регион", "Сумма", "Дата", "НДС"},
+			want:    20.0, valType: excelize.CellTypeNumber, class: NumFmtGeneral,
+		},
+		{
+			name:    "дата плюс дни - дата",
+			column:  config.DerivedColumn{Header: "Срок", Source: config.DerivedExpr, Expr: "Дата + 30"},
+			headers: []string{"Регион", "Сумма", "Дата", "Срок"},
+			want:    day(31), valType: excelize.CellTypeDate, class: NumFmtDate,
+		},
+		{
+			name:    "разность дат - число",
+			column:  config.DerivedColumn{Header: "Дней", Source: config.DerivedExpr, Expr: "Дата - Дата"},
+			headers: []string{"Регион", "Сумма", "Дата", "Дней"},
+			want:    0.0, valType: excelize.CellTypeNumber, class: NumFmtGeneral,
+		},
+		{
+			name:    "ссылка на колонку наследует ее тип",
+			column:  config.DerivedColumn{Header: "Копия", Source: config.DerivedExpr, Expr: "Дата"},
+			headers: []string{"Регион", "Сумма", "Дата", "Копия"},
+			want:    day(1), valType: excelize.CellTypeDate, class: NumFmtDate,
+		},
+		{
+			name:    "сцепление - текст",
+			column:  config.DerivedColumn{Header: "Метка", Source: config.DerivedExpr, Expr: "Регион & '/' & 'итог'"},
+			headers: []string{"Регион", "Сумма", "Дата", "Метка"},
+			want:    "Москва/итог", valType: excelize.CellTypeInlineString, class: NumFmtGeneral,
+		},
+		{
+			name:    "сравнение - логическое",
+			column:  config.DerivedColumn{Header: "Крупная", Source: config.DerivedExpr, Expr: "Сумма >= 100"},
+			headers: []string{"Регион", "Сумма", "Дата", "Крупная"},
+			want:    true, valType: excelize.CellTypeBool, class: NumFmtGeneral,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, err := derivedMerger(tt.column)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sm.Headers, tt.headers) {
+				t.Fatalf("заголовки %v, ожидается %v", sm.Headers, tt.headers)
+			}
+			pos := sm.derived[0].pos
+			if sm.ValueTypes[pos] != tt.valType || sm.FormatClasses[pos] != tt.class {
+				t.Errorf("тип %v, класс %v, ожидается %v, %v", sm.ValueTypes[pos], sm.FormatClasses[pos], tt.valType, tt.class)
+			}
+			cells := make([]interface{}, len(sm.Headers))
+			for i, v := range data {
+				cells[sm.dataPosition(i)] = v
+			}
+			sm.fillDerived(cells, nil, sm.fileDerived(job), "Лист1", 7)
+			if got := cellValue(cells, pos); got != tt.want {
+				t.Errorf("значение %#v, ожидается %#v", got, tt.want)
+			}
+			for i, v := range data {
+				if got := cellValue(cells, sm.dataPosition(i)); got != v {
+					t.Errorf("колонка данных %d: %#v, ожидается %#v", i, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestDerivedColumnsOrder(t *testing.T) {
+	// выражение ссылается на вычисляемую колонку, объявленную раньше
+	sm, err := derivedMerger(
+		config.DerivedColumn{Header: "Двойная", Source: config.DerivedExpr, Expr: "Сумма * 2"},
+		config.DerivedColumn{Header: "Итого", Source: config.DerivedExpr, Expr: "Двойная + 1", Position: "first"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Итого", "Регион", "Сумма", "Дата", "Двойная"}; !reflect.DeepEqual(sm.Headers, want) {
+		t.Fatalf("заголовки %v, ожидается %v", sm.Headers, want)
+	}
+	cells := make([]interface{}, len(sm.Headers))
+	cells[sm.dataPosition(1)] = 5.0
+	sm.fillDerived(cells, nil, sm.fileDerived(FileJob{}), "", 2)
+	if got := []interface{}{cellValue(cells, 0), cellValue(cells, 4)}; !reflect.DeepEqual(got, []interface{}{11.0, 10.0}) {
+		t.Errorf("значения %v, ожидается [11 10]", got)
+	}
+}
+
+func TestDerivedColumnsErrors(t *testing.T) {
+	tests := []struct {
+		column config.DerivedColumn
+		msg    string
+	}{
+		{config.DerivedColumn{Header: "X", Source: config.DerivedExpr, Expr: "Город * 2"}, "Город"},
+		{config.DerivedColumn{Header: "X", Source: config.DerivedExpr, Expr: "Сумма *"}, `"X"`},
+		{config.DerivedColumn{Header: "X", Source: config.DerivedFile, After: "Город"}, `колонка "Город" не найдена`},
+		{config.DerivedColumn{Header: "X", Source: config.DerivedFile, Before: "Город"}, `колонка "Город" не найдена`},
+		{config.DerivedColumn{Header: "X", Source: config.DerivedFile, Style: "Город"}, `колонка стиля "Город"`},
+		{config.DerivedColumn{Header: "X", Source: config.DerivedRegex, Regex: "("}, "регулярное выражение"},
+		{config.DerivedColumn{Header: "X", Source: config.DerivedFile, Position: "0"}, "некорректная позиция"},
+		{config.DerivedColumn{Header: "X", Source: config.DerivedFile, Position: "5"}, "до 4"},
+	}
+	for _, tt := range tests {
+		_, err := derivedMerger(tt.column)
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%+v: ошибка %v, ожидается %q", tt.column, err, tt.msg)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package merger
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+// fileFilter отбирает входные файлы по маскам и регулярным выражениям.
+// Маски без "/" сравниваются с именем файла, остальные - с относительным путем.
+// Регулярные выражения всегда применяются к относительному пути с "/" в качестве разделителя.
+type fileFilter struct {
+	include []filePattern
+	exclude []filePattern
+}
+
+// filePattern - скомпилированная маска или регулярное выражение
+type filePattern struct {
+	re   *regexp.Regexp
+	base bool // сравнивать с именем файла (маска без "/")
+}
+
+// newFileFilter компилирует маски и регулярные выражения из конфигурации
+func newFileFilter(cfg *config.Config) (*fileFilter, error) {
+	ff := &fileFilter{}
+	compile := func(globs, patterns []string) ([]filePattern, error) {
+		var res []filePattern
+		for _, g := range globs {
+			re, err := globToRegexp(g)
+			if err != nil {
+				return nil, fmt.Errorf("некорректная маска %q: %v", g, err)
+			}
+			res = append(res, filePattern{re: re, base: !strings.Contains(filepath.ToSlash(g), "/")})
+		}
+		for _, p := range patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("некорректное регулярное выражение %q: %v", p, err)
+			}
+			res = append(res, filePattern{re: re})
+		}
+		return res, nil
+	}
+
+	var err error
+	if ff.include, err = compile(cfg.Include, cfg.IncludeRegex); err != nil {
+		return nil, err
+	}
+	if ff.exclude, err = compile(cfg.Exclude, cfg.ExcludeRegex); err != nil {
+		return nil, err
+	}
+	return ff, nil
+}
+
+// match проверяет относительный путь файла с "/" в качестве разделителя
+func (ff *fileFilter) match(relPath string) bool {
+	matchAny := func(list []filePattern) bool {
+		for _, p := range list {
+			name := relPath
+			if p.base {
+				name = path.Base(relPath)
+			}
+			if p.re.MatchString(name) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(ff.include) > 0 && !matchAny(ff.include) {
+		return false
+	}
+	return !matchAny(ff.exclude)
+}
+
+// globToRegexp преобразует маску в регулярное выражение без учета регистра.
+// Поддерживаются *, ?, **, [...] и {a,b}; * и ? не совпадают с "/".
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	glob = filepath.ToSlash(glob)
+	var sb strings.Builder
+	sb.WriteString("(?i)^")
+
+	inGroup := false
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("незакрытая скобка [")
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end
+		case '{':
+			if inGroup {
+				return nil, fmt.Errorf("вложенные группы {} не поддерживаются")
+			}
+			inGroup = true
+			sb.WriteString("(?:")
+		case '}':
+			if !inGroup {
+				return nil, fmt.Errorf("лишняя скобка }")
+			}
+			inGroup = false
+			sb.WriteString(")")
+		case ',':
+			if inGroup {
+				sb.WriteString("|")
+			} else {
+				sb.WriteString(",")
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if inGroup {
+		return nil, fmt.Errorf("незакрытая скобка {")
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// isTempFileName определяет служебные файлы Excel и LibreOffice:
+// файлы блокировки (~$book.xlsx, .~lock.book.xlsx#) и скрытые файлы
+func isTempFileName(name string) bool {
+	return strings.HasPrefix(name, "~$") ||
+		strings.HasPrefix(name, ".~") ||
+		strings.HasPrefix(name, ".") ||
+		strings.HasSuffix(name, "~")
+}
+
+// inputExtensions - расширения входных файлов для сообщений об ошибках
+const inputExtensions = ".xlsx, .xlsm, .xltx, .xltm, .xls, .csv, .tsv"
+
+// isInputFileName проверяет расширение входного файла без учета регистра:
+// книги Office Open XML, Excel 97-2003 (.xls) и текстовые таблицы
+func isInputFileName(name string) bool {
+	return isOpenXMLFileName(name) || strings.EqualFold(filepath.Ext(name), ".xls") || isCSVFileName(name)
+}
+
+// collectInputFiles ищет входные файлы в cfg.InputDir (рекурсивно при cfg.Recursive),
+// пропуская служебные файлы и применяя фильтры -include/-exclude
+func collectInputFiles(cfg *config.Config) ([]inputFile, error) {
+	filter, err := newFileFilter(cfg)
+	if err != nil {
+		return nil, config.Invalid(err)
+	}
+
+	var files []inputFile
+	err = filepath.WalkDir(cfg.InputDir, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if fullPath == cfg.InputDir {
+				return err
+			}
+			// недоступные вложенные папки пропускаются
+			return nil
+		}
+		if entry.IsDir() {
+			if fullPath == cfg.InputDir {
+				return nil
+			}
+			if !cfg.Recursive || isTempFileName(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || isTempFileName(entry.Name()) || !isInputFileName(entry.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(cfg.InputDir, fullPath)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if !filter.match(rel) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, inputFile{
+			Path:    fullPath,
+			RelPath: rel,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, withKind(ErrNoInputFiles, fmt.Errorf("ошибка при чтении директории: %w", err))
+	}
+	return files, nil
+}
@@ -0,0 +1,61 @@
+package merger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+func TestFileFilterMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		incRe   []string
+		excRe   []string
+		path    string
+		want    bool
+	}{
+		{name: "маска без / - имя файла", include: []string{"*.xlsx"}, path: "2024/q1/a.xlsx", want: true},
+		{name: "маска без / без учета регистра", include: []string{"a.XLSX"}, path: "sub/A.xlsx", want: true},
+		{name: "маска с / - путь", include: []string{"2024/*.xlsx"}, path: "2024/a.xlsx", want: true},
+		{name: "маска с / не совпадает с именем", include: []string{"2024/*.xlsx"}, path: "2024/q1/a.xlsx", want: false},
+		{name: "маска с **", include: []string{"2024/**/*.xlsx"}, path: "2024/q1/a.xlsx", want: true},
+		{name: "исключение по имени", exclude: []string{"*_old.xlsx"}, path: "2024/a_old.xlsx", want: false},
+		{name: "регулярное выражение - путь", incRe: []string{`^2024/.*\.xlsx$`}, path: "2024/q1/a.xlsx", want: true},
+		{name: "регулярное выражение не применяется к имени", incRe: []string{`^a\.xlsx$`}, path: "2024/a.xlsx", want: false},
+		{name: "регулярное выражение без корня пути", incRe: []string{`^a\.xlsx$`}, path: "a.xlsx", want: true},
+		{name: "исключение регулярным выражением", excRe: []string{`^tmp/`}, path: "tmp/a.xlsx", want: false},
+		{name: "исключение регулярным выражением не по имени", excRe: []string{`^a`}, path: "b/a.xlsx", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Include, cfg.Exclude = tt.include, tt.exclude
+			cfg.IncludeRegex, cfg.ExcludeRegex = tt.incRe, tt.excRe
+			ff, err := newFileFilter(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ff.match(tt.path); got != tt.want {
+				t.Errorf("match(%q) = %v, ожидается %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInputExtensions(t *testing.T) {
+	for _, ext := range strings.Split(inputExtensions, ", ") {
+		for _, name := range []string{"book" + ext, "BOOK" + strings.ToUpper(ext)} {
+			if !isInputFileName(name) {
+				t.Errorf("%s не принимается как входной файл", name)
+			}
+		}
+	}
+	for _, name := range []string{"book.ods", "book.txt", "book", "xlsx"} {
+		if isInputFileName(name) {
+			t.Errorf("%s не должен приниматься", name)
+		}
+	}
+}
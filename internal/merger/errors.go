@@ -0,0 +1,40 @@
+package merger
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+// Виды ошибок слияния. Ошибка MergeFiles сохраняет исходный текст и проверяется
+// errors.Is по виду; ошибки параметров возвращаются как *config.Error,
+// прерывание - как ошибка контекста.
+var (
+	ErrNoInputFiles = errors.New("нет входных файлов")
+	ErrTemplate     = errors.New("ошибка шаблона")
+	ErrRead         = errors.New("ошибка чтения входного файла")
+	ErrWrite        = errors.New("ошибка записи результата")
+)
+
+// kindError - ошибка err вида kind
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// withKind помечает err видом kind; nil, ошибки контекста, ошибки конфигурации
+// и уже помеченные ошибки возвращаются как есть
+func withKind(kind, err error) error {
+	var ke *kindError
+	var cfgErr *config.Error
+	if err == nil || errors.As(err, &ke) || errors.As(err, &cfgErr) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &kindError{kind: kind, err: err}
+}
@@ -0,0 +1,804 @@
+package merger
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Фильтр строк (-filter) - выражение над значениями колонок схемы:
+//
+//	"Регион" = 'Москва' AND Сумма > 0
+//	NOT (Статус IN ('отменен', 'черновик')) OR Дата >= '2024-01-01'
+//	Телефон ~ '^\+7' AND Комментарий IS NOT NULL
+//
+// Колонка - заголовок шаблона (с учетом синонимов) в двойных кавычках или без них,
+// если он состоит из одного слова, либо буква колонки (A, B, ...). Строки - в одинарных
+// кавычках ('' внутри строки - кавычка). Операторы: = != <> < <= > >=, IN (...),
+// ~ и !~ (регулярное выражение по исходному тексту значения), IS [NOT] NULL, AND, OR, NOT, скобки.
+// Ключевые слова не зависят от регистра.
+//
+// Значения сравниваются по типу колонки: текст литерала приводится к числу, дате
+// или логическому значению. Пустое значение - NULL: любое сравнение с ним ложно.
+//
+// Вместо колонки или литерала можно использовать выражение: + - * / над числами
+// (к дате прибавляются и вычитаются дни, разность дат - число дней) и & - склеивание текста.
+// Арифметика с NULL дает NULL, при склеивании NULL - пустая строка.
+// Тот же язык используется в выражениях вычисляемых колонок (-column "...:expr=...").
+
+// filterExpr - узел выражения фильтра
+type filterExpr interface {
+	eval(sm *StreamMerger, cells []interface{}, raw []string) bool
+}
+
+// filterValue - выражение, вычисляющее значение: колонка, литерал или арифметика
+type filterValue interface {
+	value(sm *StreamMerger, cells []interface{}) interface{}
+}
+
+// filterOperand - колонка или литерал в сравнении
+type filterOperand struct {
+	col     int         // позиция колонки схемы (-1 для литерала)
+	literal interface{} // string, float64 или bool
+}
+
+func (o filterOperand) value(sm *StreamMerger, cells []interface{}) interface{} {
+	if o.col < 0 {
+		return o.literal
+	}
+	v := sm.plainValue(cellValue(cells, o.col), o.col)
+	if s, ok := v.(string); ok && strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return v
+}
+
+// text возвращает исходный текст значения колонки (как во входном файле)
+func (o filterOperand) text(sm *StreamMerger, cells []interface{}, raw []string) (string, bool) {
+	v := o.value(sm, cells)
+	if v == nil {
+		return "", false
+	}
+	if o.col < 0 {
+		return fmt.Sprint(v), true
+	}
+	if o.col < len(raw) {
+		return strings.TrimSpace(raw[o.col]), true
+	}
+	return sm.formatPlainValue(v, o.col), true
+}
+
+// valueText возвращает текст значения выражения v: для колонки - исходный текст
+func valueText(sm *StreamMerger, v filterValue, cells []interface{}, raw []string) (string, bool) {
+	if o, ok := v.(filterOperand); ok {
+		return o.text(sm, cells, raw)
+	}
+	value := v.value(sm, cells)
+	if value == nil {
+		return "", false
+	}
+	return formatExprValue(value), true
+}
+
+// formatExprValue форматирует результат выражения как текст
+func formatExprValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return formatTime(v, NumFmtDate)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	}
+	return fmt.Sprint(v)
+}
+
+// filterArith - арифметика или склеивание текста (&)
+type filterArith struct {
+	op   string
+	l, r filterValue
+}
+
+func (e filterArith) value(sm *StreamMerger, cells []interface{}) interface{} {
+	a, b := e.l.value(sm, cells), e.r.value(sm, cells)
+	if e.op == "&" {
+		var s string
+		for _, side := range []struct {
+			expr filterValue
+			v    interface{}
+		}{{e.l, a}, {e.r, b}} {
+			if side.v == nil {
+				continue
+			}
+			if o, ok := side.expr.(filterOperand); ok && o.col >= 0 {
+				s += sm.formatPlainValue(side.v, o.col)
+			} else {
+				s += formatExprValue(side.v)
+			}
+		}
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	// дата плюс или минус дни, разность дат - дни
+	ta, aTime := a.(time.Time)
+	tb, bTime := b.(time.Time)
+	switch {
+	case aTime && bTime:
+		if e.op == "-" {
+			return ta.Sub(tb).Hours() / 24
+		}
+		return nil
+	case aTime || bTime:
+		if e.op != "+" && !(aTime && e.op == "-") {
+			return nil
+		}
+		t, other := ta, b
+		if bTime {
+			t, other = tb, a
+		}
+		days, ok := arithNumber(other)
+		if !ok {
+			return nil
+		}
+		if e.op == "-" {
+			days = -days
+		}
+		return t.Add(time.Duration(days * 24 * float64(time.Hour)))
+	}
+	x, okA := arithNumber(a)
+	y, okB := arithNumber(b)
+	if !okA || !okB {
+		return nil
+	}
+	switch e.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		if y == 0 {
+			return nil
+		}
+		return x / y
+	}
+	return nil
+}
+
+// arithNumber приводит значение к числу для арифметики: логические значения - 1 и 0
+func arithNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		if n, ok := parseNumber(v); ok {
+			return n, true
+		}
+		return parseClock(v)
+	}
+	return 0, false
+}
+
+// filterNeg - смена знака
+type filterNeg struct{ v filterValue }
+
+func (e filterNeg) value(sm *StreamMerger, cells []interface{}) interface{} {
+	if n, ok := arithNumber(e.v.value(sm, cells)); ok {
+		return -n
+	}
+	return nil
+}
+
+// filterBool - логическое выражение как значение (TRUE/FALSE)
+type filterBool struct{ e filterExpr }
+
+func (e filterBool) value(sm *StreamMerger, cells []interface{}) interface{} {
+	return e.e.eval(sm, cells, nil)
+}
+
+type filterAnd struct{ l, r filterExpr }
+
+func (e filterAnd) eval(sm *StreamMerger, cells []interface{}, raw []string) bool {
+	return e.l.eval(sm, cells, raw) && e.r.eval(sm, cells, raw)
+}
+
+type filterOr struct{ l, r filterExpr }
+
+func (e filterOr) eval(sm *StreamMerger, cells []interface{}, raw []string) bool {
+	return e.l.eval(sm, cells, raw) || e.r.eval(sm, cells, raw)
+}
+
+type filterNot struct{ e filterExpr }
+
+func (e filterNot) eval(sm *StreamMerger, cells []interface{}, raw []string) bool {
+	return !e.e.eval(sm, cells, raw)
+}
+
+// filterCompare - сравнение двух операндов
+type filterCompare struct {
+	op   string
+	l, r filterValue
+}
+
+func (e filterCompare) eval(sm *StreamMerger, cells []interface{}, raw []string) bool {
+	c, ok := compareFilterValues(e.l.value(sm, cells), e.r.value(sm, cells))
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// filterIn - проверка вхождения в список
+type filterIn struct {
+	v    filterValue
+	list []filterValue
+	not  bool
+}
+
+func (e filterIn) eval(sm *StreamMerger, cells []interface{}, raw []string) bool {
+	v := e.v.value(sm, cells)
+	if v == nil {
+		return false
+	}
+	for _, item := range e.list {
+		if c, ok := compareFilterValues(v, item.value(sm, cells)); ok && c == 0 {
+			return !e.not
+		}
+	}
+	return e.not
+}
+
+// filterNull - проверка пустого значения
+type filterNull struct {
+	v   filterValue
+	not bool
+}
+
+func (e filterNull) eval(sm *StreamMerger, cells []interface{}, raw []string) bool {
+	return (e.v.value(sm, cells) == nil) != e.not
+}
+
+// filterMatch - регулярное выражение по тексту значения
+type filterMatch struct {
+	v   filterValue
+	re  *regexp.Regexp
+	not bool
+}
+
+func (e filterMatch) eval(sm *StreamMerger, cells []interface{}, raw []string) bool {
+	s, ok := valueText(sm, e.v, cells, raw)
+	if !ok {
+		return false
+	}
+	return e.re.MatchString(s) != e.not
+}
+
+// compareFilterValues сравнивает значения: -1, 0, 1. Если типы различаются,
+// текст приводится к типу другого значения. ok = false для NULL и несравнимых значений.
+func compareFilterValues(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if _, ok := a.(string); ok {
+		a = coerceFilterValue(a, b)
+	} else {
+		b = coerceFilterValue(b, a)
+	}
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return cmp.Compare(x, y), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	}
+	return 0, false
+}
+
+// coerceFilterValue приводит текстовое значение v к типу значения like
+func coerceFilterValue(v, like interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	switch like.(type) {
+	case float64:
+		if n, ok := parseNumber(s); ok {
+			return n
+		}
+		// время и длительность хранятся долей суток
+		if days, ok := parseClock(s); ok {
+			return days
+		}
+	case time.Time:
+		if t, ok := parseDate(s); ok {
+			return t
+		}
+	case bool:
+		if b, ok := parseBool(s); ok {
+			return b
+		}
+	}
+	return v
+}
+
+// compileFilter разбирает выражение -filter и сопоставляет колонки со схемой
+func (sm *StreamMerger) compileFilter() error {
+	sm.filter = nil
+	if strings.TrimSpace(sm.Cfg.Filter) == "" {
+		return nil
+	}
+	tokens, err := lexFilter(sm.Cfg.Filter)
+	if err != nil {
+		return fmt.Errorf("фильтр: %v", err)
+	}
+	p := &filterParser{sm: sm, tokens: tokens}
+	expr, err := p.parseOr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = p.errorf("лишний текст %q", p.peek().text)
+	}
+	if err != nil {
+		return fmt.Errorf("фильтр: %v", err)
+	}
+	sm.filter = expr
+	return nil
+}
+
+// compileValue разбирает выражение вычисляемой колонки: значение или условие (TRUE/FALSE)
+func (sm *StreamMerger) compileValue(text string) (filterValue, error) {
+	tokens, err := lexFilter(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{sm: sm, tokens: tokens}
+	if e, err := p.parseOr(); err == nil && p.peek().kind == tokEOF {
+		return filterBool{e}, nil
+	}
+	p.i = 0
+	v, err := p.parseValue()
+	if err == nil && p.peek().kind != tokEOF {
+		err = p.errorf("лишний текст %q", p.peek().text)
+	}
+	return v, err
+}
+
+// FilteredRows - число строк входного файла, отброшенных фильтром
+type FilteredRows struct {
+	File string `json:"file"`
+	Rows int64  `json:"rows"`
+}
+
+// reportFiltered запоминает число строк файла, отброшенных фильтром
+func (sm *StreamMerger) reportFiltered(fileIndex int, file string, rows int64) {
+	if rows == 0 {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.filteredRows[fileIndex] = rows
+}
+
+// filtered сообщает, что строка не проходит фильтр. cells - значения после приведения
+// к типам колонок, raw - исходный текст по позициям схемы.
+func (sm *StreamMerger) filtered(cells []interface{}, raw []string) bool {
+	return sm.filter != nil && !sm.filter.eval(sm, cells, raw)
+}
+
+// Лексемы фильтра
+const (
+	tokEOF    = iota
+	tokIdent  // слово: колонка или ключевое слово
+	tokColumn // "колонка"
+	tokString // 'строка'
+	tokNumber
+	tokSymbol // ( ) , и операторы
+)
+
+type filterToken struct {
+	kind int
+	text string
+	pos  int // позиция в символах (с 1)
+}
+
+func lexFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	pos := 0 // номер символа
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		pos++
+		start, startPos := i, pos
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '\'' || r == '"':
+			var b strings.Builder
+			i += size
+			closed := false
+			for i < len(s) {
+				c, n := utf8.DecodeRuneInString(s[i:])
+				i += n
+				pos++
+				if c == r {
+					if i < len(s) && rune(s[i]) == r {
+						// удвоенная кавычка - кавычка внутри строки
+						b.WriteRune(r)
+						i++
+						pos++
+						continue
+					}
+					closed = true
+					break
+				}
+				b.WriteRune(c)
+			}
+			if !closed {
+				return nil, fmt.Errorf("незакрытая кавычка в позиции %d", startPos)
+			}
+			kind := tokString
+			if r == '"' {
+				kind = tokColumn
+			}
+			tokens = append(tokens, filterToken{kind: kind, text: b.String(), pos: startPos})
+		case r >= '0' && r <= '9' || r == '.':
+			for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.' || s[i] == 'e' || s[i] == 'E' ||
+				(s[i] == '-' || s[i] == '+') && (s[i-1] == 'e' || s[i-1] == 'E')) {
+				i++
+				pos++
+			}
+			pos--
+			tokens = append(tokens, filterToken{kind: tokNumber, text: s[start:i], pos: startPos})
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(s) {
+				c, n := utf8.DecodeRuneInString(s[i:])
+				if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+					break
+				}
+				i += n
+				pos++
+			}
+			pos--
+			tokens = append(tokens, filterToken{kind: tokIdent, text: s[start:i], pos: startPos})
+		default:
+			op := ""
+			for _, candidate := range []string{"<=", ">=", "<>", "!=", "==", "!~", "=", "<", ">", "~", "(", ")", ",", "-", "+", "*", "/", "&"} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("неожиданный символ %q в позиции %d", r, startPos)
+			}
+			i += len(op)
+			pos += len(op) - 1
+			tokens = append(tokens, filterToken{kind: tokSymbol, text: op, pos: startPos})
+		}
+	}
+	return append(tokens, filterToken{kind: tokEOF, pos: pos + 1}), nil
+}
+
+// filterParser - разбор выражения рекурсивным спуском:
+//
+//	or      = and { OR and }
+//	and     = not { AND not }
+//	not     = NOT not | "(" or ")" | predicate
+//	predicate = value ( cmp value | [NOT] IN "(" value { "," value } ")"
+//	            | IS [NOT] NULL | ["!"]~ 'regex' | [NOT] MATCHES 'regex' )
+//	value   = term { ("+" | "-" | "&") term }
+//	term    = factor { ("*" | "/") factor }
+//	factor  = "-" factor | "(" value ")" | operand
+type filterParser struct {
+	sm     *StreamMerger
+	tokens []filterToken
+	i      int
+}
+
+func (p *filterParser) peek() filterToken { return p.tokens[p.i] }
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// keyword проверяет, что очередная лексема - ключевое слово kw, и пропускает ее
+func (p *filterParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokIdent && strings.EqualFold(t.text, kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) symbol(sym string) bool {
+	if t := p.peek(); t.kind == tokSymbol && t.text == sym {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return fmt.Errorf(format+" в конце выражения", args...)
+	}
+	return fmt.Errorf(format+" в позиции %d", append(args, t.pos)...)
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = filterOr{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = filterAnd{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseNot() (filterExpr, error) {
+	if p.keyword("NOT") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{e}, nil
+	}
+	if start := p.i; p.symbol("(") {
+		e, err := p.parseOr()
+		if err == nil && !p.symbol(")") {
+			err = p.errorf("ожидается )")
+		}
+		if err == nil {
+			return e, nil
+		}
+		// скобки могут открывать выражение значения: (Сумма + НДС) > 0
+		p.i = start
+		if e, perr := p.parsePredicate(); perr == nil {
+			return e, nil
+		}
+		return nil, err
+	}
+	return p.parsePredicate()
+}
+
+func (p *filterParser) parsePredicate() (filterExpr, error) {
+	l, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("IS") {
+		not := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, p.errorf("ожидается NULL")
+		}
+		return filterNull{v: l, not: not}, nil
+	}
+
+	not := p.keyword("NOT")
+	switch {
+	case p.keyword("IN"):
+		if !p.symbol("(") {
+			return nil, p.errorf("ожидается ( после IN")
+		}
+		e := filterIn{v: l, not: not}
+		for {
+			item, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			e.list = append(e.list, item)
+			if p.symbol(")") {
+				return e, nil
+			}
+			if !p.symbol(",") {
+				return nil, p.errorf("ожидается , или )")
+			}
+		}
+	case p.keyword("MATCHES") || p.symbol("~"):
+		return p.parseMatch(l, not)
+	case not:
+		return nil, p.errorf("ожидается IN или MATCHES после NOT")
+	case p.symbol("!~"):
+		return p.parseMatch(l, true)
+	}
+
+	op := p.peek()
+	if op.kind != tokSymbol {
+		return nil, p.errorf("ожидается оператор сравнения")
+	}
+	switch op.text {
+	case "=", "==":
+		op.text = "="
+	case "<>", "!=":
+		op.text = "!="
+	case "<", "<=", ">", ">=":
+	default:
+		return nil, p.errorf("ожидается оператор сравнения")
+	}
+	p.i++
+	r, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return filterCompare{op: op.text, l: l, r: r}, nil
+}
+
+func (p *filterParser) parseValue() (filterValue, error) {
+	l, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op.kind != tokSymbol || (op.text != "+" && op.text != "-" && op.text != "&") {
+			return l, nil
+		}
+		p.i++
+		r, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		l = filterArith{op: op.text, l: l, r: r}
+	}
+}
+
+func (p *filterParser) parseTerm() (filterValue, error) {
+	l, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op.kind != tokSymbol || (op.text != "*" && op.text != "/") {
+			return l, nil
+		}
+		p.i++
+		r, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		l = filterArith{op: op.text, l: l, r: r}
+	}
+}
+
+func (p *filterParser) parseFactor() (filterValue, error) {
+	if p.symbol("-") {
+		v, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		if o, ok := v.(filterOperand); ok && o.col < 0 {
+			if n, ok := o.literal.(float64); ok {
+				return filterOperand{col: -1, literal: -n}, nil
+			}
+		}
+		return filterNeg{v}, nil
+	}
+	if p.symbol("(") {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if !p.symbol(")") {
+			return nil, p.errorf("ожидается )")
+		}
+		return v, nil
+	}
+	return p.parseOperand()
+}
+
+func (p *filterParser) parseMatch(v filterValue, not bool) (filterExpr, error) {
+	t := p.peek()
+	if t.kind != tokString {
+		return nil, p.errorf("ожидается регулярное выражение в одинарных кавычках")
+	}
+	re, err := regexp.Compile(t.text)
+	if err != nil {
+		return nil, p.errorf("некорректное регулярное выражение: %v", err)
+	}
+	p.i++
+	return filterMatch{v: v, re: re, not: not}, nil
+}
+
+func (p *filterParser) parseOperand() (filterOperand, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokString:
+		p.i++
+		return filterOperand{col: -1, literal: t.text}, nil
+	case tokNumber:
+		p.i++
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return filterOperand{}, fmt.Errorf("некорректное число %q в позиции %d", t.text, t.pos)
+		}
+		return filterOperand{col: -1, literal: n}, nil
+	case tokIdent:
+		switch strings.ToUpper(t.text) {
+		case "TRUE", "FALSE":
+			p.i++
+			return filterOperand{col: -1, literal: strings.EqualFold(t.text, "TRUE")}, nil
+		case "AND", "OR", "NOT", "IN", "IS", "NULL", "MATCHES":
+			return filterOperand{}, p.errorf("ожидается колонка или значение, получено %s", strings.ToUpper(t.text))
+		}
+		fallthrough
+	case tokColumn:
+		p.i++
+		col := p.sm.columnIndex(t.text)
+		if col < 0 {
+			return filterOperand{}, fmt.Errorf("колонка %q не найдена в шаблоне (позиция %d)", t.text, t.pos)
+		}
+		return filterOperand{col: col}, nil
+	}
+	return filterOperand{}, p.errorf("ожидается колонка или значение")
+}
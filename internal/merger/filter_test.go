@@ -0,0 +1,133 @@
+package merger
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+// filterMerger создает схему Регион, Сумма, Дата, Флаг, Телефон для разбора выражений
+func filterMerger(filter string) *StreamMerger {
+	cfg := config.Default()
+	cfg.HasHeaders = true
+	cfg.Filter = filter
+	cfg.HeaderAliases = map[string][]string{"Сумма": {"Итого"}}
+	sm := schemaMerger(cfg, "Регион", "Сумма", "Дата", "Флаг", "Телефон")
+	sm.FormatClasses = []NumFmtClass{NumFmtGeneral, NumFmtNumber, NumFmtDate, NumFmtGeneral, NumFmtText}
+	return sm
+}
+
+func TestFilterEval(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	rows := [][]interface{}{
+		{"Москва", 100.0, day(1), true, "+79001234567"},
+		{"Казань", -5.0, day(10), false, "8 900"},
+		{"москва", nil, nil, nil, " "},
+	}
+	tests := []struct {
+		filter string
+		want   []bool
+	}{
+		{`Регион = 'Москва'`, []bool{true, false, false}},
+		{`"Регион" != 'Москва'`, []bool{false, true, true}},
+		{`регион <> 'Казань'`, []bool{true, false, true}},
+		{`Сумма > 0`, []bool{true, false, false}},
+		{`Итого >= '-5'`, []bool{true, true, false}},
+		{`B < 0`, []bool{false, true, false}},
+		{`Сумма > 0 AND Регион = 'Москва' OR Регион = 'Казань'`, []bool{true, true, false}},
+		{`Сумма > 0 and (Регион = 'Москва' or Регион = 'Казань')`, []bool{true, false, false}},
+		{`NOT Сумма > 0`, []bool{false, true, true}},
+		{`Дата >= '2024-05-05'`, []bool{false, true, false}},
+		{`Дата < '01.05.2024'`, []bool{false, false, false}},
+		{`Флаг = TRUE`, []bool{true, false, false}},
+		{`Регион IN ('Казань', 'Тула')`, []bool{false, true, false}},
+		{`Регион NOT IN ('Казань')`, []bool{true, false, true}},
+		{`Сумма IS NULL`, []bool{false, false, true}},
+		{`Телефон IS NOT NULL`, []bool{true, true, false}},
+		{`Телефон ~ '^\+7'`, []bool{true, false, false}},
+		{`Телефон !~ '^\+7'`, []bool{false, true, false}},
+		{`Телефон NOT MATCHES '^\+7'`, []bool{false, true, false}},
+		{`Сумма * 2 + 10 = 210`, []bool{true, false, false}},
+		{`-Сумма > 0`, []bool{false, true, false}},
+		{`Дата + 9 = '2024-05-10'`, []bool{true, false, false}},
+		{`Дата - Дата = 0`, []bool{true, true, false}},
+		{`Регион & '/' & Флаг = 'Москва/true'`, []bool{true, false, false}},
+		{`'it''s' = 'it''s'`, []bool{true, true, true}},
+	}
+	for _, tt := range tests {
+		sm := filterMerger(tt.filter)
+		if err := sm.compileFilter(); err != nil {
+			t.Errorf("%s: %v", tt.filter, err)
+			continue
+		}
+		for i, row := range rows {
+			if got := !sm.filtered(row, nil); got != tt.want[i] {
+				t.Errorf("%s: строка %d = %v, ожидается %v", tt.filter, i+1, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestFilterParseErrors(t *testing.T) {
+	tests := []struct {
+		filter string
+		msg    string
+	}{
+		{`Город = 'Москва'`, "Город"},
+		{`Регион = 'Москва`, ""},
+		{`Регион =`, ""},
+		{`(Сумма > 0`, ""},
+		{`Сумма > 0)`, "лишний текст"},
+		{`Регион IN 'Москва'`, ""},
+		{`Сумма IS 0`, ""},
+		{`Телефон ~ '('`, ""},
+		{`AND Сумма > 0`, "AND"},
+	}
+	for _, tt := range tests {
+		sm := filterMerger(tt.filter)
+		err := sm.compileFilter()
+		if err == nil {
+			t.Errorf("%s: ожидается ошибка", tt.filter)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "фильтр: ") || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: ошибка %q", tt.filter, err)
+		}
+	}
+}
+
+func TestCompileValue(t *testing.T) {
+	row := []interface{}{"Москва", 100.0, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true, nil}
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		{`Сумма / 4`, 25.0},
+		{`Сумма / 0`, nil},
+		{`Сумма + Телефон`, nil},
+		{`(Сумма - 10) * 2`, 180.0},
+		{`Регион & '-' & Телефон`, "Москва-"},
+		{`Сумма > 50`, true},
+		{`Регион = 'Казань' OR Флаг = FALSE`, false},
+		{`Дата + 1`, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{`Флаг + 1`, 2.0},
+	}
+	sm := filterMerger("")
+	for _, tt := range tests {
+		v, err := sm.compileValue(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := v.value(sm, row); got != tt.want {
+			t.Errorf("%s = %#v, ожидается %#v", tt.expr, got, tt.want)
+		}
+	}
+	for _, expr := range []string{`Сумма +`, `Сумма 1`, `Город`} {
+		if _, err := sm.compileValue(expr); err == nil {
+			t.Errorf("%s: ожидается ошибка", expr)
+		}
+	}
+}
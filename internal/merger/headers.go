@@ -0,0 +1,176 @@
+package merger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+)
+
+// UnknownColumns описывает колонки исходного файла,
+// которые не удалось сопоставить с заголовками шаблона
+type UnknownColumns struct {
+	File    string   `json:"file"`    // путь относительно входной папки
+	Columns []string `json:"columns"` // несопоставленные заголовки
+}
+
+// normalizeHeader приводит заголовок к виду для сравнения:
+// нижний регистр, ё -> е, неразрывные пробелы и повторяющиеся пробелы схлопываются
+func normalizeHeader(h string) string {
+	h = strings.ToLower(h)
+	h = strings.ReplaceAll(h, "ё", "е")
+	h = strings.ReplaceAll(h, " ", " ")
+	return strings.Join(strings.Fields(h), " ")
+}
+
+// headerIndex строит индекс заголовков схемы: нормализованное имя -> позиции колонок.
+// Синонимы из конфигурации указывают на позицию своего заголовка шаблона.
+func (sm *StreamMerger) headerIndex() map[string][]int {
+	index := make(map[string][]int)
+	for i := 0; i < sm.DataColumns; i++ {
+		pos := sm.dataPosition(i)
+		key := normalizeHeader(sm.Headers[pos])
+		index[key] = append(index[key], pos)
+	}
+	for name, aliases := range sm.Cfg.HeaderAliases {
+		positions, ok := index[normalizeHeader(name)]
+		if !ok {
+			continue
+		}
+		for _, alias := range aliases {
+			key := normalizeHeader(alias)
+			if _, exists := index[key]; !exists {
+				index[key] = positions
+			}
+		}
+	}
+	return index
+}
+
+// mapHeaders сопоставляет заголовки исходного файла со схемой.
+// Возвращает для каждой исходной колонки индекс колонки схемы (-1 если колонка неизвестна)
+// и список несопоставленных заголовков.
+// Повторяющиеся заголовки занимают позиции схемы с тем же именем по порядку.
+func (sm *StreamMerger) mapHeaders(headers []string) ([]int, []string) {
+	index := sm.headerIndex()
+	used := make(map[int]bool)
+	mapping := make([]int, len(headers))
+	var unknown []string
+
+	for i, h := range headers {
+		mapping[i] = -1
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		for _, pos := range index[normalizeHeader(h)] {
+			if !used[pos] {
+				used[pos] = true
+				mapping[i] = pos
+				break
+			}
+		}
+		if mapping[i] < 0 {
+			unknown = append(unknown, h)
+		}
+	}
+	return mapping, unknown
+}
+
+// readHeaderRows читает первые строки выбранных листов файла
+func (sm *StreamMerger) readHeaderRows(path string) ([][]string, error) {
+	src, err := openSource(path, sm.Cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var headers [][]string
+	for _, sheet := range sm.sourceSheets(src) {
+		rows, err := src.Rows(sheet)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения строк из %s: %v", path, err)
+		}
+		if rows.Next() {
+			row, err := rows.Columns()
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("ошибка чтения заголовка из %s: %v", path, err)
+			}
+			headers = append(headers, row)
+		}
+		rows.Close()
+	}
+	return headers, nil
+}
+
+// extendSchema просматривает заголовки всех входных файлов и добавляет
+// неизвестные колонки в конец схемы (до добавления вычисляемых колонок).
+// Используется в режиме -unknown-columns=append до начала записи,
+// чтобы заголовок выходного файла содержал полный набор колонок.
+func (sm *StreamMerger) extendSchema(inputFiles []inputFile) error {
+	var extra []string
+	seen := make(map[string]bool)
+
+	for _, file := range inputFiles {
+		sheetHeaders, err := sm.readHeaderRows(file.Path)
+		if err != nil {
+			if sm.Cfg.OnError == config.OnErrorSkip {
+				// ошибка файла будет учтена при чтении строк
+				continue
+			}
+			return err
+		}
+		for _, headers := range sheetHeaders {
+			_, unknown := sm.mapHeaders(headers)
+			for _, h := range unknown {
+				key := normalizeHeader(h)
+				if !seen[key] {
+					seen[key] = true
+					extra = append(extra, h)
+				}
+			}
+		}
+	}
+
+	if len(extra) == 0 {
+		return nil
+	}
+
+	headerStyle := 0
+	if sm.DataColumns > 0 {
+		headerStyle = sm.HeaderStyles[sm.DataColumns-1]
+	}
+
+	tail := sm.Headers[sm.DataColumns:]
+	headers := append(append(append([]string{}, sm.Headers[:sm.DataColumns]...), extra...), tail...)
+	headerStyles := append([]int{}, sm.HeaderStyles[:sm.DataColumns]...)
+	rowStyles := append([]int{}, sm.RowStyles[:sm.DataColumns]...)
+	valueTypes := append([]excelize.CellType{}, sm.ValueTypes[:sm.DataColumns]...)
+	formatClasses := append([]NumFmtClass{}, sm.FormatClasses[:sm.DataColumns]...)
+	for range extra {
+		headerStyles = append(headerStyles, headerStyle)
+		rowStyles = append(rowStyles, 0)
+		valueTypes = append(valueTypes, excelize.CellTypeInlineString)
+		formatClasses = append(formatClasses, NumFmtGeneral)
+	}
+
+	sm.Headers = headers
+	sm.HeaderStyles = append(headerStyles, sm.HeaderStyles[sm.DataColumns:]...)
+	sm.RowStyles = append(rowStyles, sm.RowStyles[sm.DataColumns:]...)
+	sm.ValueTypes = append(valueTypes, sm.ValueTypes[sm.DataColumns:]...)
+	sm.FormatClasses = append(formatClasses, sm.FormatClasses[sm.DataColumns:]...)
+	sm.DataColumns += len(extra)
+
+	return nil
+}
+
+// reportUnknownColumns сохраняет список несопоставленных колонок файла
+func (sm *StreamMerger) reportUnknownColumns(fileIndex int, file string, columns []string) {
+	if len(columns) == 0 || sm.Cfg.UnknownColumns != config.UnknownColumnsReport {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.unknownColumns[fileIndex] = UnknownColumns{File: file, Columns: columns}
+}
@@ -0,0 +1,213 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Состояния входного файла в итогах по файлам
+const (
+	FileOK      = "ok"      // файл прочитан, строки есть
+	FileEmpty   = "empty"   // файл прочитан, строк данных нет
+	FileSkipped = "skipped" // файл не читался: нет подходящих листов или слияние прервано
+	FileError   = "error"   // ошибка чтения
+)
+
+// ManifestSheet - имя листа итогов по входным файлам (-manifest)
+const ManifestSheet = "Manifest"
+
+// InputFileStats - итоги обработки входного файла
+type InputFileStats struct {
+	File        string   `json:"file"`                 // путь относительно входной папки
+	Size        int64    `json:"size"`                 // размер в байтах
+	Status      string   `json:"status"`               // FileOK, FileEmpty, FileSkipped или FileError
+	Sheets      []string `json:"sheets,omitempty"`     // прочитанные листы
+	RowsRead    int64    `json:"rows_read"`            // прочитано строк данных
+	RowsWritten int64    `json:"rows_written"`         // записано строк в результат
+	Rejected    int64    `json:"rejected,omitempty"`   // отклонено проверкой
+	Filtered    int64    `json:"filtered,omitempty"`   // отброшено фильтром
+	Duplicates  int64    `json:"duplicates,omitempty"` // удалено как повторы
+	Duration    string   `json:"duration,omitempty"`   // время чтения
+	Error       string   `json:"error,omitempty"`      // причина пропуска или ошибка чтения
+}
+
+// FailedFile - входной файл, пропущенный из-за ошибки чтения
+type FailedFile struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// manifestHeader - заголовки листа Manifest
+var manifestHeader = []interface{}{
+	"Файл", "Размер", "Статус", "Листы", "Прочитано строк", "Записано строк",
+	"Отклонено", "Отфильтровано", "Повторы", "Время чтения", "Ошибка",
+}
+
+// fileRead запоминает итоги прочитанного файла: листы, счетчики строк и время чтения
+func (sm *StreamMerger) fileRead(job FileJob, sheets []string, stats fileStats, start time.Time) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	f := &sm.inputStats[job.Index]
+	f.Sheets = sheets
+	f.RowsRead, f.Filtered = stats.Rows, stats.Filtered
+	f.Duration = time.Since(start).Round(time.Millisecond).String()
+	switch {
+	case len(sheets) == 0:
+		f.Status, f.Error = FileSkipped, "нет подходящих листов"
+	case stats.Rows == 0:
+		f.Status = FileEmpty
+	default:
+		f.Status = FileOK
+	}
+}
+
+// fileFailed запоминает ошибку чтения файла; файл, чтение которого прервано
+// из-за отмены или ошибки в другом файле, считается пропущенным
+func (sm *StreamMerger) fileFailed(ctx context.Context, job FileJob, err error, start time.Time) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	f := &sm.inputStats[job.Index]
+	f.Duration = time.Since(start).Round(time.Millisecond).String()
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		f.Status, f.Error = FileSkipped, "чтение прервано"
+		return
+	}
+	f.Status, f.Error = FileError, err.Error()
+}
+
+// errNoFilesRead - все входные файлы пропущены из-за ошибок чтения (-on-error=skip)
+var errNoFilesRead = errors.New("не удалось прочитать ни одного входного файла")
+
+// checkFilesRead возвращает ошибку, если ни один входной файл не прочитан
+func (sm *StreamMerger) checkFilesRead() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, f := range sm.inputStats {
+		if f.Status != FileError {
+			return nil
+		}
+	}
+	if len(sm.inputStats) == 0 {
+		return nil
+	}
+	return withKind(ErrRead, fmt.Errorf("%w: %s", errNoFilesRead, sm.inputStats[0].Error))
+}
+
+// filesResult возвращает итоги по входным файлам в порядке обработки
+func (sm *StreamMerger) filesResult() []InputFileStats {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	files := slices.Clone(sm.inputStats)
+	for i := range files {
+		f := &files[i]
+		if f.Status == "" {
+			f.Status, f.Error = FileSkipped, "не обработан: слияние прервано"
+		}
+		if sm.Cfg.Dedup && f.Status == FileOK {
+			f.Duplicates = f.RowsRead - f.Filtered - f.Rejected - f.RowsWritten
+		}
+	}
+	return files
+}
+
+// writeManifest добавляет в книгу лист Manifest с итогами по входным файлам
+func (x *xlsxWriter) writeManifest() error {
+	for _, sheet := range x.sheets {
+		if sheet.Name == ManifestSheet {
+			return fmt.Errorf("имя листа %s совпадает с листом результата", ManifestSheet)
+		}
+	}
+	if _, err := x.file.NewSheet(ManifestSheet); err != nil {
+		return fmt.Errorf("ошибка создания листа %s: %v", ManifestSheet, err)
+	}
+	x.file.SetColWidth(ManifestSheet, "A", "A", 40)
+	x.file.SetColWidth(ManifestSheet, "B", "J", 14)
+	x.file.SetColWidth(ManifestSheet, "K", "K", 60)
+	sw, err := x.file.NewStreamWriter(ManifestSheet)
+	if err != nil {
+		return fmt.Errorf("ошибка создания StreamWriter: %v", err)
+	}
+	sheet := &OutputSheet{Name: ManifestSheet, StreamWriter: sw}
+	x.sheets = append(x.sheets, sheet)
+
+	bold, err := x.file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return fmt.Errorf("ошибка создания стиля листа %s: %v", ManifestSheet, err)
+	}
+	header := make([]interface{}, len(manifestHeader))
+	for i, h := range manifestHeader {
+		header[i] = excelize.Cell{Value: h, StyleID: bold}
+	}
+	rows := [][]interface{}{header}
+	for _, f := range x.sm.filesResult() {
+		rows = append(rows, []interface{}{
+			f.File, f.Size, f.Status, strings.Join(f.Sheets, ", "), f.RowsRead, f.RowsWritten,
+			f.Rejected, f.Filtered, f.Duplicates, f.Duration, f.Error,
+		})
+	}
+	for _, row := range rows {
+		sheet.RowCounter++
+		cell, _ := excelize.CoordinatesToCellName(1, int(sheet.RowCounter))
+		if err := sw.SetRow(cell, row); err != nil {
+			return fmt.Errorf("ошибка записи листа %s: %v", ManifestSheet, err)
+		}
+	}
+	return nil
+}
+
+// heldPart - часть результата, сохранение которой отложено до конца слияния
+type heldPart struct {
+	output *xlsxWriter
+	file   io.WriteCloser
+	name   string
+}
+
+// holdPart сообщает, что заполненная часть XLSX сохраняется в конце слияния:
+// в первую часть пишется лист Manifest, а в каждую часть - лист итогов,
+// которые известны только после записи всех строк
+func (sm *StreamMerger) holdPart() bool {
+	if _, ok := sm.output.(*xlsxWriter); !ok {
+		return false
+	}
+	return sm.PartCounter == 1 && sm.Cfg.Manifest || sm.summary != nil && sm.summary.path == ""
+}
+
+// holdOutput откладывает сохранение текущей части до конца слияния.
+// Пустой лист результата дописывается сразу.
+func (sm *StreamMerger) holdOutput() error {
+	x := sm.output.(*xlsxWriter)
+	if err := x.complete(); err != nil {
+		return err
+	}
+	held := *x
+	sm.held = append(sm.held, &heldPart{output: &held, file: sm.partFile, name: sm.partName})
+	sm.partFile = nil
+	return nil
+}
+
+// saveHeld сохраняет отложенные части; они предшествуют уже сохраненным
+func (sm *StreamMerger) saveHeld() error {
+	for i := 0; len(sm.held) > 0; i++ {
+		h := sm.held[0]
+		sm.held = sm.held[1:]
+		if err := h.output.ClosePart(); err != nil {
+			h.file.Close()
+			sm.OutputFiles = append(sm.OutputFiles, h.name)
+			return err
+		}
+		if err := h.file.Close(); err != nil {
+			sm.OutputFiles = append(sm.OutputFiles, h.name)
+			return fmt.Errorf("ошибка сохранения файла: %w", err)
+		}
+		sm.OutputFiles = slices.Insert(sm.OutputFiles, i, h.name)
+		sm.emit(ProgressEvent{Type: EventPartSaved, PartFile: h.name})
+	}
+	return nil
+}
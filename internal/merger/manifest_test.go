@@ -0,0 +1,95 @@
+package merger
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestManifestSheet(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		// 5 строк: одна отфильтрована, одна отклонена, одна - повтор
+		"a.csv": "Код,Сумма\n1,10\n2,-5\n1,10\n4,40\n3,30\n",
+		"b.csv": "Код,Сумма\n",
+		"c.csv": "Код,Сумма\n5,\"50\n",
+	})
+	// у книги нет листа, подходящего под -sheet-regex (к CSV правило не применяется)
+	writeBook(t, filepath.Join(dir, "d.xlsx"), nil, [][]interface{}{{"Код", "Сумма"}})
+	cfg := csvConfig(t, dir)
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.xlsx")
+	cfg.MaxRowPerFile = 2 // заголовок и одна строка
+	cfg.Manifest = true
+	cfg.SheetRegex = "^Данные$"
+	cfg.OnError = config.OnErrorSkip
+	cfg.Filter = "Сумма > 0"
+	cfg.Dedup = true
+	rule, err := config.ParseValidationRule("Код:allowed=1|2|3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Rules = []config.ValidationRule{rule}
+	res, err := runMerge(t, cfg)
+	if err != nil {
+		t.Fatalf("MergeFiles: %v", err)
+	}
+	if len(res.OutputFiles) != 2 || filepath.Base(res.OutputFiles[0]) != "out_part1.xlsx" {
+		t.Fatalf("части %v, ожидаются две части", res.OutputFiles)
+	}
+
+	// состояние, листы, прочитано, записано, отклонено, отфильтровано, повторы
+	want := map[string][]string{
+		"a.csv":  {FileOK, "a", "5", "2", "1", "1", "1"},
+		"b.csv":  {FileEmpty, "b", "0", "0", "0", "0", "0"},
+		"c.csv":  {FileError, "", "0", "0", "0", "0", "0"},
+		"d.xlsx": {FileSkipped, "", "0", "0", "0", "0", "0"},
+	}
+	for _, f := range res.Files {
+		got := []string{f.Status, "", "", "", "", "", ""}
+		if len(f.Sheets) > 0 {
+			got[1] = f.Sheets[0]
+		}
+		for i, n := range []int64{f.RowsRead, f.RowsWritten, f.Rejected, f.Filtered, f.Duplicates} {
+			got[i+2] = strconv.FormatInt(n, 10)
+		}
+		if !reflect.DeepEqual(got, want[f.File]) {
+			t.Errorf("итоги %s = %v, ожидается %v", f.File, got, want[f.File])
+		}
+	}
+
+	for i, name := range res.OutputFiles {
+		f, err := excelize.OpenFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := f.GetRows(ManifestSheet)
+		f.Close()
+		if i > 0 {
+			if err == nil {
+				t.Errorf("%s: лист %s только в первой части", name, ManifestSheet)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(rows) != 5 || len(rows[0]) != len(manifestHeader) {
+			t.Fatalf("%s: лист %s = %v", name, ManifestSheet, rows)
+		}
+		for _, row := range rows[1:] {
+			row = append(row, make([]string, len(manifestHeader)-len(row))...)
+			// без размера и времени чтения
+			got := append([]string{row[2], row[3]}, row[4:9]...)
+			if !reflect.DeepEqual(got, want[row[0]]) {
+				t.Errorf("строка %s = %v, ожидается %v", row[0], got, want[row[0]])
+			}
+			if hasError := row[10] != ""; hasError != (row[2] == FileError || row[2] == FileSkipped) {
+				t.Errorf("строка %s: ошибка %q", row[0], row[10])
+			}
+		}
+	}
+}
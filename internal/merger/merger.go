@@ -1,11 +1,13 @@
 package merger
 
 import (
+	"context"
+
 	"github.com/ryabkov82/xlsx-merger/internal/config"
 )
 
 type FileMerger interface {
-	MergeFiles(cfg *config.Config) ([]string, int64, error)
+	MergeFiles(ctx context.Context, cfg *config.Config) (*MergeResult, error)
 }
 
 type BaseMerger struct {
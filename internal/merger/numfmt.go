@@ -0,0 +1,284 @@
+package merger
+
+import (
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// NumFmtClass - класс числового формата ячейки
+type NumFmtClass int
+
+const (
+	NumFmtGeneral  NumFmtClass = iota // Общий формат
+	NumFmtNumber                      // Число
+	NumFmtPercent                     // Процент
+	NumFmtCurrency                    // Денежный формат
+	NumFmtDate                        // Дата (возможно со временем)
+	NumFmtTime                        // Время без даты
+	NumFmtDuration                    // Длительность ([h]:mm:ss)
+	NumFmtText                        // Текст (@)
+)
+
+// String возвращает название класса формата
+func (c NumFmtClass) String() string {
+	switch c {
+	case NumFmtNumber:
+		return "number"
+	case NumFmtPercent:
+		return "percent"
+	case NumFmtCurrency:
+		return "currency"
+	case NumFmtDate:
+		return "date"
+	case NumFmtTime:
+		return "time"
+	case NumFmtDuration:
+		return "duration"
+	case NumFmtText:
+		return "text"
+	}
+	return "general"
+}
+
+// IsTemporal сообщает, хранит ли формат дату, время или длительность
+func (c NumFmtClass) IsTemporal() bool {
+	return c == NumFmtDate || c == NumFmtTime || c == NumFmtDuration
+}
+
+// IsNumeric сообщает, хранит ли формат число
+func (c NumFmtClass) IsNumeric() bool {
+	return c == NumFmtNumber || c == NumFmtPercent || c == NumFmtCurrency
+}
+
+// builtInNumFmtClasses - классы встроенных форматов Excel (ECMA-376, 18.8.30)
+var builtInNumFmtClasses = map[int]NumFmtClass{
+	0: NumFmtGeneral,
+	1: NumFmtNumber, 2: NumFmtNumber, 3: NumFmtNumber, 4: NumFmtNumber,
+	5: NumFmtCurrency, 6: NumFmtCurrency, 7: NumFmtCurrency, 8: NumFmtCurrency,
+	9: NumFmtPercent, 10: NumFmtPercent,
+	11: NumFmtNumber, 12: NumFmtNumber, 13: NumFmtNumber,
+	14: NumFmtDate, 15: NumFmtDate, 16: NumFmtDate, 17: NumFmtDate,
+	18: NumFmtTime, 19: NumFmtTime, 20: NumFmtTime, 21: NumFmtTime,
+	22: NumFmtDate,
+	27: NumFmtDate, 28: NumFmtDate, 29: NumFmtDate, 30: NumFmtDate, 31: NumFmtDate,
+	32: NumFmtTime, 33: NumFmtTime, 34: NumFmtTime, 35: NumFmtTime, 36: NumFmtDate,
+	37: NumFmtNumber, 38: NumFmtNumber, 39: NumFmtNumber, 40: NumFmtNumber,
+	41: NumFmtNumber, 42: NumFmtCurrency, 43: NumFmtNumber, 44: NumFmtCurrency,
+	45: NumFmtTime, 46: NumFmtDuration, 47: NumFmtTime,
+	48: NumFmtNumber,
+	49: NumFmtText,
+	50: NumFmtDate, 51: NumFmtDate, 52: NumFmtDate, 53: NumFmtDate, 54: NumFmtDate,
+	55: NumFmtTime, 56: NumFmtTime, 57: NumFmtDate, 58: NumFmtDate,
+}
+
+// currencySigns - символы и сокращения валют, встречающиеся в кодах форматов
+var currencySigns = []string{"₽", "$", "€", "£", "¥", "₸", "₴", "руб", "р.", "rub", "usd", "eur"}
+
+// classifyStyle определяет класс формата стиля ячейки
+func classifyStyle(style *excelize.Style) NumFmtClass {
+	if style == nil {
+		return NumFmtGeneral
+	}
+	if style.CustomNumFmt != nil {
+		return classifyNumFmtCode(*style.CustomNumFmt)
+	}
+	return builtInNumFmtClasses[style.NumFmt]
+}
+
+// classifyNumFmtCode разбирает код пользовательского формата.
+// Анализируется первая непустая секция (формат положительных чисел):
+// текст в кавычках и экранированные символы проверяются только на знак валюты,
+// блоки в квадратных скобках - на длительность ([h], [mm], [ss]) и валюту ([$₽-419]).
+func classifyNumFmtCode(code string) NumFmtClass {
+	section := code
+	for _, s := range splitNumFmtSections(code) {
+		if strings.TrimSpace(s) != "" {
+			section = s
+			break
+		}
+	}
+
+	var (
+		hasDate, hasTime, hasDuration bool
+		hasDigits, hasPercent, hasAt  bool
+		hasCurrency                   bool
+		lastDateTimeToken             byte // предыдущий элемент даты/времени: для различения месяцев и минут
+	)
+
+	checkCurrency := func(literal string) {
+		lower := strings.ToLower(literal)
+		for _, sign := range currencySigns {
+			if strings.Contains(lower, sign) {
+				hasCurrency = true
+				return
+			}
+		}
+	}
+
+	for i := 0; i < len(section); i++ {
+		c := section[i]
+		switch c {
+		case '"':
+			end := strings.IndexByte(section[i+1:], '"')
+			if end < 0 {
+				end = len(section) - i - 1
+			}
+			checkCurrency(section[i+1 : i+1+end])
+			i += end + 1
+			continue
+		case '\\':
+			if i+1 < len(section) {
+				checkCurrency(section[i+1 : i+2])
+			}
+			i++
+			continue
+		case '_', '*':
+			// _x - отступ шириной символа, *x - заполнение символом
+			i++
+			continue
+		case '[':
+			end := strings.IndexByte(section[i:], ']')
+			if end < 0 {
+				i = len(section)
+				continue
+			}
+			block := section[i+1 : i+end]
+			lower := strings.ToLower(block)
+			switch {
+			case lower != "" && strings.Trim(lower, "hms") == "":
+				hasDuration = true
+			case strings.HasPrefix(block, "$"):
+				// [$₽-419]: символ валюты до "-", после - код языка
+				if sign, _, _ := strings.Cut(block[1:], "-"); sign != "" {
+					hasCurrency = true
+				}
+			}
+			i += end
+			continue
+		case '0', '#', '?':
+			hasDigits = true
+		case '%':
+			hasPercent = true
+		case '@':
+			hasAt = true
+		}
+
+		switch c | 0x20 {
+		case 'y', 'd':
+			hasDate = true
+			lastDateTimeToken = 'd'
+		case 'h':
+			hasTime = true
+			lastDateTimeToken = 'h'
+		case 's':
+			hasTime = true
+			lastDateTimeToken = 's'
+		case 'm':
+			// повторы mm/mmm считаем одним элементом
+			for i+1 < len(section) && section[i+1]|0x20 == 'm' {
+				i++
+			}
+			// m после часов или перед секундами - минуты, иначе месяц
+			if lastDateTimeToken == 'h' || followedBySeconds(section[i+1:]) {
+				hasTime = true
+			} else {
+				hasDate = true
+			}
+			lastDateTimeToken = 'm'
+		case 'a':
+			// AM/PM, A/P
+			upper := strings.ToUpper(section[i:])
+			switch {
+			case strings.HasPrefix(upper, "AM/PM"):
+				hasTime = true
+				i += len("AM/PM") - 1
+			case strings.HasPrefix(upper, "A/P"):
+				hasTime = true
+				i += len("A/P") - 1
+			}
+		}
+
+		if c == '$' || c >= 0x80 {
+			// символ валюты без кавычек, в том числе многобайтовый
+			checkCurrency(section[i:min(i+8, len(section))])
+		}
+	}
+
+	switch {
+	case hasDuration:
+		return NumFmtDuration
+	case hasDate:
+		return NumFmtDate
+	case hasTime:
+		return NumFmtTime
+	case hasAt && !hasDigits:
+		return NumFmtText
+	case hasPercent:
+		return NumFmtPercent
+	case hasCurrency:
+		return NumFmtCurrency
+	case hasDigits:
+		return NumFmtNumber
+	}
+	return NumFmtGeneral
+}
+
+// followedBySeconds проверяет, идут ли после элемента m секунды (mm:ss),
+// пропуская разделители
+func followedBySeconds(rest string) bool {
+	for i := 0; i < len(rest); i++ {
+		switch rest[i] | 0x20 {
+		case ':', ' ', '.', ',':
+			continue
+		case 's':
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// splitNumFmtSections делит код формата на секции по ";" вне кавычек и скобок
+func splitNumFmtSections(code string) []string {
+	var sections []string
+	inQuotes, inBrackets, start := false, false, 0
+	for i := 0; i < len(code); i++ {
+		switch code[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case '[':
+			if !inQuotes {
+				inBrackets = true
+			}
+		case ']':
+			inBrackets = false
+		case ';':
+			if !inQuotes && !inBrackets {
+				sections = append(sections, code[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(sections, code[start:])
+}
+
+// valueTypeForClass сопоставляет класс формата шаблона с типом значения колонки.
+// Для общего формата сохраняется тип ячейки шаблона.
+func valueTypeForClass(class NumFmtClass, cellType excelize.CellType) excelize.CellType {
+	switch {
+	case class.IsTemporal():
+		return excelize.CellTypeDate
+	case class.IsNumeric():
+		return excelize.CellTypeNumber
+	case class == NumFmtText:
+		return excelize.CellTypeInlineString
+	}
+	switch cellType {
+	case excelize.CellTypeNumber, excelize.CellTypeBool, excelize.CellTypeDate:
+		return cellType
+	}
+	return excelize.CellTypeInlineString
+}
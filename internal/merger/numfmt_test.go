@@ -0,0 +1,83 @@
+package merger
+
+import (
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestClassifyNumFmtCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want NumFmtClass
+	}{
+		{"General", NumFmtGeneral},
+		{"0.00", NumFmtNumber},
+		{"# ##0,00", NumFmtNumber},
+		{"0.0%", NumFmtPercent},
+		{`#,##0.00\ "₽"`, NumFmtCurrency},
+		{"[$$-409]#,##0.00", NumFmtCurrency},
+		{`#,##0 "руб."`, NumFmtCurrency},
+		{"dd.mm.yyyy", NumFmtDate},
+		{"yyyy-mm-dd hh:mm", NumFmtDate},
+		{`d mmmm yyyy "г."`, NumFmtDate},
+		{"hh:mm:ss", NumFmtTime},
+		{"h:mm AM/PM", NumFmtTime},
+		{"[h]:mm:ss", NumFmtDuration},
+		{"[mm]:ss", NumFmtDuration},
+		{"@", NumFmtText},
+		{`"Итого: "0`, NumFmtNumber},
+		{`"мм.дд"`, NumFmtGeneral},
+		{"[Red]0.00;[Blue]-0.00", NumFmtNumber},
+		{";;;@", NumFmtText},
+	}
+	for _, tt := range tests {
+		if got := classifyNumFmtCode(tt.code); got != tt.want {
+			t.Errorf("classifyNumFmtCode(%q) = %v, ожидается %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyStyle(t *testing.T) {
+	custom := "dd/mm/yy"
+	tests := []struct {
+		style *excelize.Style
+		want  NumFmtClass
+	}{
+		{nil, NumFmtGeneral},
+		{&excelize.Style{}, NumFmtGeneral},
+		{&excelize.Style{NumFmt: 4}, NumFmtNumber},
+		{&excelize.Style{NumFmt: 9}, NumFmtPercent},
+		{&excelize.Style{NumFmt: 14}, NumFmtDate},
+		{&excelize.Style{NumFmt: 21}, NumFmtTime},
+		{&excelize.Style{NumFmt: 46}, NumFmtDuration},
+		{&excelize.Style{NumFmt: 49}, NumFmtText},
+		{&excelize.Style{NumFmt: 2, CustomNumFmt: &custom}, NumFmtDate},
+	}
+	for _, tt := range tests {
+		if got := classifyStyle(tt.style); got != tt.want {
+			t.Errorf("classifyStyle(%+v) = %v, ожидается %v", tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestValueTypeForClass(t *testing.T) {
+	tests := []struct {
+		class    NumFmtClass
+		cellType excelize.CellType
+		want     excelize.CellType
+	}{
+		{NumFmtDate, excelize.CellTypeInlineString, excelize.CellTypeDate},
+		{NumFmtDuration, excelize.CellTypeNumber, excelize.CellTypeDate},
+		{NumFmtCurrency, excelize.CellTypeSharedString, excelize.CellTypeNumber},
+		{NumFmtText, excelize.CellTypeNumber, excelize.CellTypeInlineString},
+		{NumFmtGeneral, excelize.CellTypeBool, excelize.CellTypeBool},
+		{NumFmtGeneral, excelize.CellTypeNumber, excelize.CellTypeNumber},
+		{NumFmtGeneral, excelize.CellTypeSharedString, excelize.CellTypeInlineString},
+	}
+	for _, tt := range tests {
+		if got := valueTypeForClass(tt.class, tt.cellType); got != tt.want {
+			t.Errorf("valueTypeForClass(%v, %v) = %v, ожидается %v", tt.class, tt.cellType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package merger
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+// inputFile описывает найденный входной файл
+type inputFile struct {
+	Path    string
+	RelPath string // путь относительно входной папки с "/" в качестве разделителя
+	Size    int64
+	ModTime time.Time
+}
+
+// sortInputFiles упорядочивает входные файлы согласно cfg.Order.
+// При равенстве основного ключа файлы упорядочиваются по имени,
+// поэтому результат не зависит от порядка чтения директории.
+func sortInputFiles(files []inputFile, cfg *config.Config) ([]inputFile, error) {
+	if cfg.Order == config.OrderList {
+		return orderByListFile(files, cfg)
+	}
+
+	var less func(a, b inputFile) bool
+	switch cfg.Order {
+	case config.OrderName:
+		less = func(a, b inputFile) bool {
+			return strings.ToLower(a.Path) < strings.ToLower(b.Path)
+		}
+	case config.OrderNatural:
+		less = func(a, b inputFile) bool {
+			return naturalLess(a.Path, b.Path)
+		}
+	case config.OrderModTime:
+		less = func(a, b inputFile) bool {
+			return a.ModTime.Before(b.ModTime)
+		}
+	default: // config.OrderSize
+		less = func(a, b inputFile) bool {
+			return a.Size < b.Size
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if cfg.OrderDesc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return naturalLess(a.Path, b.Path)
+	})
+	return files, nil
+}
+
+// orderByListFile упорядочивает файлы по списку из cfg.OrderFile.
+// Строки списка - пути относительно входной папки или абсолютные пути,
+// пустые строки и строки с # игнорируются.
+// Файлы, отсутствующие в списке, добавляются в конец в естественном порядке имен.
+func orderByListFile(files []inputFile, cfg *config.Config) ([]inputFile, error) {
+	list, err := os.Open(cfg.OrderFile)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия списка файлов: %w", err)
+	}
+	defer list.Close()
+
+	byPath := make(map[string]int, len(files))
+	for i, f := range files {
+		byPath[filepath.Clean(f.Path)] = i
+	}
+
+	used := make([]bool, len(files))
+	var ordered []inputFile
+
+	scanner := bufio.NewScanner(list)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		path := line
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cfg.InputDir, path)
+		}
+		i, ok := byPath[filepath.Clean(path)]
+		if !ok {
+			return nil, fmt.Errorf("файл из списка не найден среди входных файлов: %s", line)
+		}
+		if !used[i] {
+			used[i] = true
+			ordered = append(ordered, files[i])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения списка файлов: %w", err)
+	}
+
+	var rest []inputFile
+	for i, f := range files {
+		if !used[i] {
+			rest = append(rest, f)
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool {
+		return naturalLess(rest[i].Path, rest[j].Path)
+	})
+
+	return append(ordered, rest...), nil
+}
+
+// naturalLess сравнивает строки без учета регистра,
+// числовые фрагменты сравниваются как числа: "file2" < "file10"
+func naturalLess(a, b string) bool {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			si := i
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			sj := j
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ra[si:i]), "0")
+			nb := strings.TrimLeft(string(rb[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if ra[i] != rb[j] {
+			return ra[i] < rb[j]
+		}
+		i++
+		j++
+	}
+	if len(ra)-i != len(rb)-j {
+		return len(ra)-i < len(rb)-j
+	}
+	return a < b
+}
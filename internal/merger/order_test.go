@@ -0,0 +1,115 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+func TestSortInputFiles(t *testing.T) {
+	dir := "/in"
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	files := func() []inputFile {
+		return []inputFile{
+			{Path: filepath.Join(dir, "file10.csv"), Size: 30, ModTime: day(1)},
+			{Path: filepath.Join(dir, "File2.csv"), Size: 10, ModTime: day(3)},
+			{Path: filepath.Join(dir, "file1.csv"), Size: 10, ModTime: day(2)},
+			{Path: filepath.Join(dir, "b.csv"), Size: 20, ModTime: day(2)},
+		}
+	}
+	tests := []struct {
+		order string
+		desc  bool
+		want  []string
+	}{
+		// равные размеры и даты - в естественном порядке имен
+		{config.OrderSize, false, []string{"file1.csv", "File2.csv", "b.csv", "file10.csv"}},
+		{config.OrderSize, true, []string{"file10.csv", "b.csv", "File2.csv", "file1.csv"}},
+		{config.OrderName, false, []string{"b.csv", "file1.csv", "file10.csv", "File2.csv"}},
+		{config.OrderNatural, false, []string{"b.csv", "file1.csv", "File2.csv", "file10.csv"}},
+		{config.OrderNatural, true, []string{"file10.csv", "File2.csv", "file1.csv", "b.csv"}},
+		{config.OrderModTime, false, []string{"file10.csv", "b.csv", "file1.csv", "File2.csv"}},
+	}
+	for _, tt := range tests {
+		cfg := config.Default()
+		cfg.InputDir = dir
+		cfg.Order, cfg.OrderDesc = tt.order, tt.desc
+		got, err := sortInputFiles(files(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range got {
+			names = append(names, filepath.Base(f.Path))
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%s desc=%v: %v, ожидается %v", tt.order, tt.desc, names, tt.want)
+		}
+	}
+}
+
+func TestOrderByListFile(t *testing.T) {
+	dir := t.TempDir()
+	var files []inputFile
+	for _, name := range []string{"a.csv", "b.csv", "sub/c10.csv", "sub/c9.csv", "d.csv"} {
+		files = append(files, inputFile{Path: filepath.Join(dir, filepath.FromSlash(name))})
+	}
+	list := "\ufeff# порядок обработки\nd.csv\n\n" + filepath.Join(dir, "b.csv") + "\n  d.csv  \n"
+	cfg := config.Default()
+	cfg.InputDir = dir
+	cfg.Order = config.OrderList
+	cfg.OrderFile = filepath.Join(dir, "order.txt")
+	if err := os.WriteFile(cfg.OrderFile, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := sortInputFiles(files, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range got {
+		rel, _ := filepath.Rel(dir, f.Path)
+		names = append(names, filepath.ToSlash(rel))
+	}
+	// файлы не из списка - в конце в естественном порядке
+	if want := []string{"d.csv", "b.csv", "a.csv", "sub/c9.csv", "sub/c10.csv"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("порядок %v, ожидается %v", names, want)
+	}
+
+	if err := os.WriteFile(cfg.OrderFile, []byte("e.csv\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sortInputFiles(files, cfg); err == nil || !strings.Contains(err.Error(), "e.csv") {
+		t.Errorf("ошибка %v, ожидается файл не найден", err)
+	}
+	cfg.OrderFile = filepath.Join(dir, "нет.txt")
+	if _, err := sortInputFiles(files, cfg); err == nil {
+		t.Error("ожидается ошибка открытия списка")
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"file2", "file10", true},
+		{"file10", "file2", false},
+		{"File2", "file10", true},
+		// равные числа различаются посимвольно, чтобы порядок был строгим
+		{"a007", "a7", true},
+		{"a7", "a007", false},
+		{"a", "a1", true},
+		{"отчет 9", "Отчет 10", true},
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, ожидается %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
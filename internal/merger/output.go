@@ -0,0 +1,158 @@
+package merger
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+)
+
+// outputWriter записывает строки результата в выходной файл конкретного формата.
+// Результат делится на части: каждая часть начинается вызовом NewPart
+// и завершается ClosePart, после чего писатель готов к следующей части.
+type outputWriter interface {
+	// NewPart начинает новую часть результата, записываемую в w
+	NewPart(w io.Writer) error
+	// RowCount возвращает количество строк листа sheet в текущей части.
+	// Строка заголовка учитывается во всех форматах, поэтому при одинаковом
+	// -max-row части разных форматов содержат одни и те же строки данных.
+	RowCount(sheet string) (int64, error)
+	// WriteRow записывает строку исходного листа sheet
+	WriteRow(sheet string, cells []interface{}, height float64) error
+	// ClosePart завершает текущую часть и дописывает ее в w
+	ClosePart() error
+	// AbortPart прерывает текущую часть без записи и освобождает ресурсы
+	AbortPart()
+}
+
+// newOutputWriter создает писатель результата для формата из конфигурации
+func newOutputWriter(sm *StreamMerger) (outputWriter, error) {
+	switch sm.Cfg.Format {
+	case config.FormatXLSX, "":
+		return &xlsxWriter{sm: sm}, nil
+	case config.FormatCSV:
+		return &csvWriter{sm: sm}, nil
+	case config.FormatJSONL:
+		return &jsonlWriter{sm: sm}, nil
+	case config.FormatParquet:
+		return &parquetWriter{sm: sm}, nil
+	}
+	return nil, fmt.Errorf("неизвестный формат результата: %q", sm.Cfg.Format)
+}
+
+// outputBase возвращает путь результата без расширения и расширение частей.
+// Расширение -out отбрасывается, если оно соответствует формату результата.
+func outputBase(cfg *config.Config) (string, string) {
+	format := cfg.Format
+	if format == "" {
+		format = config.FormatXLSX
+	}
+	ext := "." + format
+	base := cfg.OutputPath
+	if e := filepath.Ext(base); strings.EqualFold(e, ext) || (format == config.FormatJSONL && strings.EqualFold(e, ".ndjson")) {
+		base = strings.TrimSuffix(base, e)
+	}
+	return base, ext
+}
+
+// partFileName возвращает имя файла текущей части результата
+func (sm *StreamMerger) partFileName() string {
+	base, ext := outputBase(sm.Cfg)
+	return fmt.Sprintf("%s_part%d%s", base, sm.PartCounter, ext)
+}
+
+// columnNames возвращает имена колонок для форматов без листов (CSV, JSON Lines, Parquet).
+// Без заголовков и для пустых заголовков используются имена col_N,
+// повторяющиеся имена дополняются номером.
+func (sm *StreamMerger) columnNames() []string {
+	names := make([]string, len(sm.Headers))
+	seen := make(map[string]bool)
+	for i, h := range sm.Headers {
+		name := strings.TrimSpace(h)
+		if !sm.Cfg.HasHeaders || name == "" {
+			name = fmt.Sprintf("col_%d", i+1)
+		}
+		for base, n := name, 2; seen[name]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		seen[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// headerRows возвращает число строк заголовка в каждой части результата
+func (sm *StreamMerger) headerRows() int64 {
+	if sm.Cfg.HasHeaders && len(sm.Headers) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// plainValue извлекает значение ячейки для форматов без стилей.
+// Серийные номера в колонках с датой преобразуются в time.Time
+// (в колонках времени и длительности остаются долей суток).
+func (sm *StreamMerger) plainValue(cell interface{}, col int) interface{} {
+	if c, ok := cell.(excelize.Cell); ok {
+		cell = c.Value
+	}
+	if serial, ok := cell.(float64); ok && sm.formatClass(col) == NumFmtDate {
+		if t, err := excelize.ExcelDateToTime(serial, sm.Date1904); err == nil {
+			return t
+		}
+	}
+	return cell
+}
+
+// formatPlainValue возвращает текстовое представление значения ячейки:
+// даты в виде 2006-01-02 или 2006-01-02 15:04:05, время и длительность - ч:мм:сс,
+// числа без экспоненты и разделителей разрядов
+func (sm *StreamMerger) formatPlainValue(v interface{}, col int) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		switch sm.formatClass(col) {
+		case NumFmtTime, NumFmtDuration:
+			return formatClock(val)
+		}
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case time.Time:
+		return formatTime(val, sm.formatClass(col))
+	}
+	return fmt.Sprint(v)
+}
+
+// formatTime форматирует дату; время без даты - ч:мм:сс
+func formatTime(t time.Time, class NumFmtClass) string {
+	switch {
+	case class == NumFmtTime || class == NumFmtDuration:
+		return t.Format("15:04:05")
+	case t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0:
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// formatClock форматирует долю суток как ч:мм:сс (часы могут превышать 24)
+func formatClock(days float64) string {
+	if math.IsNaN(days) || math.IsInf(days, 0) {
+		return strconv.FormatFloat(days, 'f', -1, 64)
+	}
+	sign := ""
+	if days < 0 {
+		sign, days = "-", -days
+	}
+	seconds := int64(math.Round(days * 86400))
+	return fmt.Sprintf("%s%d:%02d:%02d", sign, seconds/3600, seconds/60%60, seconds%60)
+}
@@ -0,0 +1,420 @@
+package merger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Parquet пишется без сжатия и словарей: каждая группа строк содержит по одной
+// странице данных (DATA_PAGE v1, кодировка PLAIN) на колонку, все колонки OPTIONAL.
+// Метаданные кодируются протоколом Thrift Compact.
+
+// parquetRowGroupRows - максимальное число строк в группе строк
+const parquetRowGroupRows = 65536
+
+// parquetRowGroupBytes - ориентировочный предел объема данных группы строк в памяти
+const parquetRowGroupBytes = 64 << 20
+
+// Физические типы Parquet
+const (
+	parquetBoolean   int32 = 0
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// Логические типы (ConvertedType) Parquet
+const (
+	parquetUTF8            int32 = 0
+	parquetTimestampMillis int32 = 9
+)
+
+// parquetMagic - сигнатура в начале и конце файла Parquet
+var parquetMagic = []byte("PAR1")
+
+// parquetColumn накапливает значения колонки текущей группы строк
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 - без логического типа
+	defLevels []bool
+	values    bytes.Buffer // значения в кодировке PLAIN (кроме BOOLEAN)
+	bools     []bool
+}
+
+// parquetWriter пишет результат в Apache Parquet.
+// Типы колонок определяются шаблоном: числа - DOUBLE, логические - BOOLEAN,
+// даты - INT64 TIMESTAMP_MILLIS, остальное (в том числе время и длительность) - строки UTF8.
+// Значения, не соответствующие типу колонки, записываются как null.
+type parquetWriter struct {
+	sm        *StreamMerger
+	w         *bufio.Writer
+	offset    int64 // позиция в текущем файле
+	rows      int64 // строки текущей части с учетом заголовка
+	columns   []*parquetColumn
+	groupRows int64
+	rowGroups []parquetRowGroup
+	totalRows int64
+}
+
+// parquetRowGroup - метаданные записанной группы строк
+type parquetRowGroup struct {
+	columns   []parquetChunk
+	byteSize  int64
+	numRows   int64
+	firstByte int64
+}
+
+// parquetChunk - метаданные записанной колонки группы строк
+type parquetChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+func (p *parquetWriter) NewPart(w io.Writer) error {
+	if p.columns == nil {
+		sm := p.sm
+		for i, name := range sm.columnNames() {
+			col := &parquetColumn{name: name, typ: parquetByteArray, converted: parquetUTF8}
+			var valType excelize.CellType
+			if i < len(sm.ValueTypes) {
+				valType = sm.ValueTypes[i]
+			}
+			switch {
+			case sm.formatClass(i) == NumFmtDate:
+				col.typ, col.converted = parquetInt64, parquetTimestampMillis
+			case valType == excelize.CellTypeNumber:
+				col.typ, col.converted = parquetDouble, -1
+			case valType == excelize.CellTypeBool:
+				col.typ, col.converted = parquetBoolean, -1
+			}
+			p.columns = append(p.columns, col)
+		}
+	}
+
+	p.w = bufio.NewWriter(w)
+	p.offset = 0
+	p.rows = p.sm.headerRows()
+	p.rowGroups = nil
+	p.totalRows = 0
+	p.groupRows = 0
+	return p.write(parquetMagic)
+}
+
+func (p *parquetWriter) RowCount(string) (int64, error) { return p.rows, nil }
+
+func (p *parquetWriter) WriteRow(_ string, cells []interface{}, _ float64) error {
+	size := 0
+	for i, col := range p.columns {
+		var v interface{}
+		if i < len(cells) {
+			v = p.sm.plainValue(cells[i], i)
+		}
+		col.add(v, p.sm.formatPlainValue(v, i))
+		size += col.values.Len()
+	}
+	p.rows++
+	p.groupRows++
+	if p.groupRows >= parquetRowGroupRows || size >= parquetRowGroupBytes {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+// add добавляет значение в колонку; text - текстовое представление для строковых колонок
+func (c *parquetColumn) add(v interface{}, text string) {
+	present := true
+	switch c.typ {
+	case parquetDouble:
+		n, ok := v.(float64)
+		if present = ok; ok {
+			binary.Write(&c.values, binary.LittleEndian, math.Float64bits(n))
+		}
+	case parquetBoolean:
+		b, ok := v.(bool)
+		if present = ok; ok {
+			c.bools = append(c.bools, b)
+		}
+	case parquetInt64:
+		t, ok := v.(time.Time)
+		if present = ok; ok {
+			binary.Write(&c.values, binary.LittleEndian, t.UnixMilli())
+		}
+	default:
+		if present = v != nil && text != ""; present {
+			binary.Write(&c.values, binary.LittleEndian, uint32(len(text)))
+			c.values.WriteString(text)
+		}
+	}
+	c.defLevels = append(c.defLevels, present)
+}
+
+// flushRowGroup записывает накопленные строки группой строк
+func (p *parquetWriter) flushRowGroup() error {
+	if p.groupRows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{numRows: p.groupRows, firstByte: p.offset}
+	for _, col := range p.columns {
+		chunk, err := p.writeColumn(col)
+		if err != nil {
+			return err
+		}
+		group.columns = append(group.columns, chunk)
+		group.byteSize += chunk.size
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.totalRows += p.groupRows
+	p.groupRows = 0
+	return nil
+}
+
+// writeColumn записывает страницу данных колонки и очищает ее буферы
+func (p *parquetWriter) writeColumn(col *parquetColumn) (parquetChunk, error) {
+	var page bytes.Buffer
+
+	// уровни определения: длина и RLE/bit-packed гибрид с разрядностью 1
+	levels := encodeDefinitionLevels(col.defLevels)
+	binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+	page.Write(levels)
+
+	if col.typ == parquetBoolean {
+		page.Write(packBits(col.bools))
+	} else {
+		page.Write(col.values.Bytes())
+	}
+
+	var header thriftWriter
+	header.i32(1, 0) // DATA_PAGE
+	header.i32(2, int32(page.Len()))
+	header.i32(3, int32(page.Len()))
+	header.beginStruct(5) // DataPageHeader
+	header.i32(1, int32(len(col.defLevels)))
+	header.i32(2, 0) // PLAIN
+	header.i32(3, 3) // RLE
+	header.i32(4, 3) // RLE
+	header.endStruct()
+	header.stop()
+
+	chunk := parquetChunk{
+		offset:    p.offset,
+		size:      int64(header.buf.Len() + page.Len()),
+		numValues: int64(len(col.defLevels)),
+	}
+	if err := p.write(header.buf.Bytes()); err != nil {
+		return chunk, err
+	}
+	if err := p.write(page.Bytes()); err != nil {
+		return chunk, err
+	}
+
+	col.defLevels = col.defLevels[:0]
+	col.bools = col.bools[:0]
+	col.values.Reset()
+	return chunk, nil
+}
+
+// AbortPart отбрасывает накопленную группу строк
+func (p *parquetWriter) AbortPart() {
+	for _, col := range p.columns {
+		col.defLevels = col.defLevels[:0]
+		col.bools = col.bools[:0]
+		col.values.Reset()
+	}
+	p.groupRows = 0
+}
+
+// ClosePart дописывает оставшиеся строки и метаданные файла
+func (p *parquetWriter) ClosePart() error {
+	if err := p.flushRowGroup(); err != nil {
+		return err
+	}
+
+	meta := p.fileMetadata()
+	if err := p.write(meta); err != nil {
+		return err
+	}
+	var tail [4]byte
+	binary.LittleEndian.PutUint32(tail[:], uint32(len(meta)))
+	if err := p.write(tail[:]); err != nil {
+		return err
+	}
+	if err := p.write(parquetMagic); err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+// fileMetadata кодирует FileMetaData: схему и расположение групп строк
+func (p *parquetWriter) fileMetadata() []byte {
+	var t thriftWriter
+	t.i32(1, 1) // version
+
+	t.listBegin(2, thriftStruct, len(p.columns)+1)
+	t.beginElem()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.endStruct()
+	for _, col := range p.columns {
+		t.beginElem()
+		t.i32(1, col.typ)
+		t.i32(3, 1) // OPTIONAL
+		t.binary(4, col.name)
+		if col.converted >= 0 {
+			t.i32(6, col.converted)
+		}
+		t.endStruct()
+	}
+
+	t.i64(3, p.totalRows)
+
+	t.listBegin(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		t.beginElem()
+		t.listBegin(1, thriftStruct, len(group.columns))
+		for i, chunk := range group.columns {
+			col := p.columns[i]
+			t.beginElem()
+			t.i64(2, chunk.offset)
+			t.beginStruct(3) // ColumnMetaData
+			t.i32(1, col.typ)
+			t.listBegin(2, thriftI32, 2)
+			t.varint(0) // PLAIN
+			t.varint(3) // RLE
+			t.listBegin(3, thriftBinary, 1)
+			t.rawBinary(col.name)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, chunk.numValues)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, group.byteSize)
+		t.i64(3, group.numRows)
+		t.i64(5, group.firstByte)
+		t.endStruct()
+	}
+
+	t.binary(6, "xlsx-merger")
+	t.stop()
+	return t.buf.Bytes()
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	if err != nil {
+		return fmt.Errorf("ошибка записи parquet: %v", err)
+	}
+	return nil
+}
+
+// encodeDefinitionLevels кодирует уровни определения (0 - null, 1 - значение)
+// одним bit-packed блоком гибридной кодировки RLE
+func encodeDefinitionLevels(levels []bool) []byte {
+	groups := (len(levels) + 7) / 8
+	out := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	return append(out, packBits(levels)...)
+}
+
+// packBits упаковывает логические значения по 8 в байт, начиная с младшего бита
+func packBits(values []bool) []byte {
+	out := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			out[i/8] |= 1 << (i % 8)
+		}
+	}
+	return out
+}
+
+// Типы полей протокола Thrift Compact
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// thriftWriter кодирует структуры в протоколе Thrift Compact
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // номер последнего поля для каждой вложенной структуры
+	id   int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.id; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.id = id
+}
+
+// varint пишет целое в zigzag-кодировке
+func (t *thriftWriter) varint(v int64) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64(v<<1^v>>63)))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.rawBinary(s)
+}
+
+func (t *thriftWriter) rawBinary(s string) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	t.buf.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+// beginStruct начинает поле-структуру
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElem()
+}
+
+// beginElem начинает структуру - элемент списка
+func (t *thriftWriter) beginElem() {
+	t.last = append(t.last, t.id)
+	t.id = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.id = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) stop() { t.buf.WriteByte(0) }
@@ -0,0 +1,157 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+// writeBook создает книгу с заголовком и строками; колонки данных получают
+// встроенные форматы numFmts (0 - общий)
+func writeBook(t *testing.T, path string, numFmts []int, rows [][]interface{}) {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow("Sheet1", cell, &row); err != nil {
+			t.Fatal(err)
+		}
+		for col, numFmt := range numFmts {
+			if i == 0 || numFmt == 0 {
+				continue
+			}
+			style, err := f.NewStyle(&excelize.Style{NumFmt: numFmt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cell, _ := excelize.CoordinatesToCellName(col+1, i+1)
+			f.SetCellStyle("Sheet1", cell, cell, style)
+		}
+	}
+	if err := f.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readParquet читает файл библиотекой parquet-go и возвращает схему и значения строк
+func readParquet(t *testing.T, path string) (*parquet.Schema, [][]interface{}) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	var rows [][]interface{}
+	reader := parquet.NewReader(pf)
+	defer reader.Close()
+	buf := make([]parquet.Row, 16)
+	for {
+		n, err := reader.ReadRows(buf)
+		for _, row := range buf[:n] {
+			values := make([]interface{}, len(row))
+			for _, v := range row {
+				values[v.Column()] = parquetValue(v)
+			}
+			rows = append(rows, values)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if int64(len(rows)) != pf.NumRows() {
+		t.Errorf("%s: прочитано %d строк из %d", path, len(rows), pf.NumRows())
+	}
+	return pf.Schema(), rows
+}
+
+// parquetValue преобразует значение parquet-go в значение Go
+func parquetValue(v parquet.Value) interface{} {
+	switch {
+	case v.IsNull():
+		return nil
+	case v.Kind() == parquet.Double:
+		return v.Double()
+	case v.Kind() == parquet.Boolean:
+		return v.Boolean()
+	case v.Kind() == parquet.Int64:
+		return time.UnixMilli(v.Int64()).UTC()
+	}
+	return v.String()
+}
+
+func TestParquetWriterReadBack(t *testing.T) {
+	dir := t.TempDir()
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	// Сумма - формат 0.00, Дата - встроенный формат даты
+	numFmts := []int{0, 2, 0, 14}
+	writeBook(t, filepath.Join(dir, "a.xlsx"), numFmts, [][]interface{}{
+		{"Клиент", "Сумма", "Флаг", "Дата"},
+		{"Альфа", 10.5, true, day(1)},
+		{"Бета", 2, false, day(2)},
+	})
+	writeBook(t, filepath.Join(dir, "b.xlsx"), numFmts, [][]interface{}{
+		{"Клиент", "Сумма", "Флаг", "Дата"},
+		{"Гамма", "нет", nil, nil},
+		{nil, -3.25, true, day(31)},
+	})
+	cfg := csvConfig(t, dir)
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.parquet")
+	cfg.MaxRowPerFile = 3
+	res, err := runMerge(t, cfg)
+	if err != nil {
+		t.Fatalf("MergeFiles: %v", err)
+	}
+	if len(res.OutputFiles) < 2 {
+		t.Fatalf("частей %d, ожидается несколько", len(res.OutputFiles))
+	}
+
+	var got [][]interface{}
+	for _, name := range res.OutputFiles {
+		schema, rows := readParquet(t, name)
+		fields := schema.Fields()
+		var names []string
+		for _, f := range fields {
+			names = append(names, f.Name())
+			if f.Required() || f.Repeated() {
+				t.Errorf("колонка %s должна быть OPTIONAL", f.Name())
+			}
+		}
+		if want := []string{"Клиент", "Сумма", "Флаг", "Дата"}; !reflect.DeepEqual(names, want) {
+			t.Fatalf("%s: колонки %v, ожидается %v", name, names, want)
+		}
+		kinds := []parquet.Kind{parquet.ByteArray, parquet.Double, parquet.Boolean, parquet.Int64}
+		for i, f := range fields {
+			if f.Type().Kind() != kinds[i] {
+				t.Errorf("колонка %s: тип %v, ожидается %v", f.Name(), f.Type().Kind(), kinds[i])
+			}
+		}
+		if lt := fields[3].Type().LogicalType(); lt == nil || lt.Timestamp == nil {
+			t.Errorf("колонка Дата: логический тип %v, ожидается TIMESTAMP", lt)
+		}
+		got = append(got, rows...)
+	}
+	want := [][]interface{}{
+		{"Альфа", 10.5, true, day(1)},
+		{"Бета", 2.0, false, day(2)},
+		// значения, не соответствующие типу колонки, - null
+		{"Гамма", nil, nil, nil},
+		{nil, -3.25, true, day(31)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("строки = %v\nожидается %v", got, want)
+	}
+}
@@ -0,0 +1,141 @@
+package merger
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Типы событий хода слияния
+const (
+	EventStart        = "start"         // входные файлы найдены, начинается запись
+	EventFileStarted  = "file_started"  // начато чтение входного файла
+	EventFileFinished = "file_finished" // входной файл прочитан
+	EventPartStarted  = "part_started"  // начата новая часть результата
+	EventPartSaved    = "part_saved"    // часть результата сохранена
+	EventRows         = "rows"          // периодический отчет о записанных строках
+	EventFinished     = "finished"      // все строки записаны, файлы сохранены
+)
+
+// progressInterval - минимальный интервал между событиями EventRows
+const progressInterval = 250 * time.Millisecond
+
+// ProgressEvent описывает событие хода слияния.
+// Счетчики (файлы, строки, байты, часть) отражают состояние на момент события.
+type ProgressEvent struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	File        string    `json:"file,omitempty"`      // входной файл (для событий файла)
+	FileRows    int64     `json:"file_rows,omitempty"` // строки, прочитанные из файла (EventFileFinished)
+	PartFile    string    `json:"part_file,omitempty"` // файл части (для событий части)
+	Part        int       `json:"part"`                // номер текущей части
+	Files       int       `json:"files"`               // всего входных файлов
+	FilesDone   int       `json:"files_done"`          // прочитано входных файлов
+	RowsWritten int64     `json:"rows_written"`        // записано строк результата
+	BytesRead   int64     `json:"bytes_read"`          // прочитано байт входных файлов
+	BytesTotal  int64     `json:"bytes_total"`         // общий размер входных файлов
+	Rejected    int64     `json:"rejected,omitempty"`  // отклонено строк при проверке
+}
+
+// progressState собирает счетчики хода слияния из горутин чтения и записи.
+// XLSX и XLS книги считаются прочитанными при открытии (книга загружается целиком),
+// CSV - по мере чтения.
+type progressState struct {
+	mu         sync.Mutex // сериализует вызовы обработчика и защищает reading
+	files      int
+	bytesTotal int64
+	filesDone  atomic.Int64
+	rows       atomic.Int64
+	rejected   atomic.Int64
+	part       atomic.Int64
+	bytesDone  atomic.Int64          // байты полностью прочитанных файлов
+	reading    map[int]*atomic.Int64 // байты файлов, читаемых сейчас
+	lastRows   time.Time             // время последнего EventRows (только writerLoop)
+}
+
+// emit отправляет событие обработчику Progress, дополняя его текущими счетчиками
+func (sm *StreamMerger) emit(ev ProgressEvent) {
+	if sm.Progress == nil {
+		return
+	}
+	p := &sm.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ev.Time = time.Now()
+	ev.Part = int(p.part.Load())
+	ev.Files = p.files
+	ev.FilesDone = int(p.filesDone.Load())
+	ev.RowsWritten = p.rows.Load()
+	ev.Rejected = p.rejected.Load()
+	ev.BytesTotal = p.bytesTotal
+	ev.BytesRead = p.bytesDone.Load()
+	for _, n := range p.reading {
+		ev.BytesRead += n.Load()
+	}
+	sm.Progress(ev)
+}
+
+// startProgress запоминает входные файлы и отправляет EventStart
+func (sm *StreamMerger) startProgress(files []inputFile) {
+	p := &sm.progress
+	p.files = len(files)
+	p.reading = make(map[int]*atomic.Int64)
+	for _, f := range files {
+		p.bytesTotal += f.Size
+	}
+	p.part.Store(int64(sm.PartCounter))
+	sm.emit(ProgressEvent{Type: EventStart})
+}
+
+// fileStarted регистрирует счетчик прочитанных байт файла и отправляет EventFileStarted
+func (sm *StreamMerger) fileStarted(job FileJob) *atomic.Int64 {
+	read := new(atomic.Int64)
+	p := &sm.progress
+	p.mu.Lock()
+	if p.reading != nil {
+		p.reading[job.Index] = read
+	}
+	p.mu.Unlock()
+	sm.emit(ProgressEvent{Type: EventFileStarted, File: job.RelPath})
+	return read
+}
+
+// fileFinished учитывает файл как прочитанный целиком и отправляет EventFileFinished
+func (sm *StreamMerger) fileFinished(job FileJob, rows int64) {
+	p := &sm.progress
+	p.mu.Lock()
+	delete(p.reading, job.Index)
+	p.bytesDone.Add(job.Size)
+	p.filesDone.Add(1)
+	p.mu.Unlock()
+	sm.emit(ProgressEvent{Type: EventFileFinished, File: job.RelPath, FileRows: rows})
+}
+
+// rowWritten обновляет счетчики после записи или отклонения строки
+// и не чаще progressInterval отправляет EventRows
+func (sm *StreamMerger) rowWritten() {
+	p := &sm.progress
+	p.rows.Store(sm.RowCount)
+	p.rejected.Store(sm.RejectedRows)
+	if sm.Progress == nil || (sm.RowCount+sm.RejectedRows)%1024 != 0 {
+		return
+	}
+	if now := time.Now(); now.Sub(p.lastRows) >= progressInterval {
+		p.lastRows = now
+		sm.emit(ProgressEvent{Type: EventRows})
+	}
+}
+
+// countingReader считает байты, прочитанные из источника
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,82 @@
+package merger
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMergeFilesProgress(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.csv":     "Код\n1\n2\n3\n",
+		"sub/b.csv": "Код\n4\n5\n",
+		"c.csv":     "Код\n",
+	})
+	cfg := csvConfig(t, dir)
+	cfg.Recursive = true
+	cfg.MaxRowPerFile = 3
+	if err := cfg.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu     sync.Mutex
+		events []ProgressEvent
+	)
+	sm := NewStreamMerger().(*StreamMerger)
+	sm.Progress = func(ev ProgressEvent) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}
+	res, err := sm.MergeFiles(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("MergeFiles: %v", err)
+	}
+
+	if first, last := events[0], events[len(events)-1]; first.Type != EventStart || last.Type != EventFinished {
+		t.Fatalf("первое событие %s, последнее %s", first.Type, last.Type)
+	}
+	fileRows := make(map[string]int64)
+	started := make(map[string]bool)
+	var partsStarted, partsSaved []string
+	var prev ProgressEvent
+	for _, ev := range events {
+		if ev.Files != 3 || ev.BytesTotal != events[0].BytesTotal {
+			t.Errorf("%s: файлов %d, байт всего %d", ev.Type, ev.Files, ev.BytesTotal)
+		}
+		// счетчики не убывают
+		if ev.FilesDone < prev.FilesDone || ev.RowsWritten < prev.RowsWritten || ev.BytesRead < prev.BytesRead || ev.Part < prev.Part {
+			t.Errorf("%s: счетчики %+v после %+v", ev.Type, ev, prev)
+		}
+		prev = ev
+		switch ev.Type {
+		case EventFileStarted:
+			started[ev.File] = true
+		case EventFileFinished:
+			if !started[ev.File] {
+				t.Errorf("%s прочитан без события начала", ev.File)
+			}
+			fileRows[ev.File] = ev.FileRows
+		case EventPartStarted:
+			partsStarted = append(partsStarted, ev.PartFile)
+		case EventPartSaved:
+			partsSaved = append(partsSaved, ev.PartFile)
+		}
+	}
+	if want := map[string]int64{"a.csv": 3, "sub/b.csv": 2, "c.csv": 0}; !reflect.DeepEqual(fileRows, want) {
+		t.Errorf("строки по файлам %v, ожидается %v", fileRows, want)
+	}
+	if !reflect.DeepEqual(partsStarted, res.OutputFiles) || !reflect.DeepEqual(partsSaved, res.OutputFiles) {
+		t.Errorf("начаты части %v, сохранены %v, ожидается %v", partsStarted, partsSaved, res.OutputFiles)
+	}
+	if len(res.OutputFiles) < 2 || filepath.Dir(res.OutputFiles[0]) != filepath.Dir(cfg.OutputPath) {
+		t.Errorf("части %v", res.OutputFiles)
+	}
+	last := events[len(events)-1]
+	if last.FilesDone != 3 || last.RowsWritten != res.RowCount || last.BytesRead != last.BytesTotal || last.Part != len(res.OutputFiles) {
+		t.Errorf("итоговое событие %+v, записано строк %d, частей %d", last, res.RowCount, len(res.OutputFiles))
+	}
+}
@@ -0,0 +1,160 @@
+package merger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+)
+
+// sourceReader читает исходный файл независимо от его формата
+type sourceReader interface {
+	// Sheets возвращает имена листов источника
+	Sheets() []string
+	// Rows открывает построчное чтение листа
+	Rows(sheet string) (rowIterator, error)
+	// CellType возвращает тип ячейки источника (col и row начинаются с 1),
+	// используется для колонок, тип которых не задан шаблоном
+	CellType(sheet string, col, row int) excelize.CellType
+	// Date1904 сообщает, используется ли система дат 1904
+	Date1904() bool
+	// Close освобождает ресурсы источника
+	Close() error
+}
+
+// rowIterator последовательно возвращает строки листа
+type rowIterator interface {
+	// Next переходит к следующей строке
+	Next() bool
+	// Columns возвращает значения ячеек текущей строки без применения числовых форматов
+	Columns() ([]string, error)
+	// Height возвращает высоту текущей строки (0 - высота по умолчанию)
+	Height() float64
+	// Error возвращает ошибку, прервавшую чтение строк
+	Error() error
+	// Close завершает чтение листа
+	Close() error
+}
+
+// openSource открывает исходный файл подходящим читателем по расширению
+func openSource(path string, cfg *config.Config) (sourceReader, error) {
+	switch {
+	case isCSVFileName(path):
+		return openCSVSource(path, cfg)
+	case strings.EqualFold(filepath.Ext(path), ".xls"):
+		return openXLSSource(path)
+	}
+	return openXLSXSource(path)
+}
+
+// sourceSheets возвращает листы источника для чтения.
+// У CSV единственный неявный лист, правила выбора листов к нему не применяются.
+func (sm *StreamMerger) sourceSheets(src sourceReader) []string {
+	if _, ok := src.(*csvSource); ok {
+		return src.Sheets()
+	}
+	return sm.selectSheets(src.Sheets())
+}
+
+// xlsxSource читает файлы Office Open XML (.xlsx, .xlsm, .xltx, .xltm) через excelize.
+// Макросы книг .xlsm не выполняются и не переносятся.
+type xlsxSource struct {
+	f        *excelize.File
+	date1904 bool
+}
+
+// openXLSXSource открывает XLSX файл
+func openXLSXSource(path string) (*xlsxSource, error) {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		// зашифрованная книга хранится в контейнере OLE, а не в ZIP
+		if errors.Is(err, excelize.ErrWorkbookFileFormat) && isOLEFile(path) {
+			return nil, fmt.Errorf("ошибка открытия файла %s: книга защищена паролем", path)
+		}
+		return nil, fmt.Errorf("ошибка открытия файла %s: %v", path, err)
+	}
+	src := &xlsxSource{f: f}
+	// Система дат файла нужна для пересчета серийных номеров дат
+	if props, err := f.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		src.date1904 = *props.Date1904
+	}
+	return src, nil
+}
+
+// isOLEFile сообщает, что файл - составной документ OLE (книга .xls или зашифрованная книга)
+func isOLEFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var sig [8]byte
+	_, err = io.ReadFull(f, sig[:])
+	return err == nil && bytes.Equal(sig[:], []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"))
+}
+
+func (s *xlsxSource) Sheets() []string { return s.f.GetSheetList() }
+
+func (s *xlsxSource) Date1904() bool { return s.date1904 }
+
+func (s *xlsxSource) Close() error { return s.f.Close() }
+
+func (s *xlsxSource) CellType(sheet string, col, row int) excelize.CellType {
+	ref, err := excelize.CoordinatesToCellName(col, row)
+	if err != nil {
+		return excelize.CellTypeUnset
+	}
+	t, _ := s.f.GetCellType(sheet, ref)
+	return t
+}
+
+func (s *xlsxSource) Rows(sheet string) (rowIterator, error) {
+	rows, err := s.f.Rows(sheet)
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxRows{rows: rows}, nil
+}
+
+// xlsxRows адаптирует excelize.Rows к rowIterator
+type xlsxRows struct {
+	rows *excelize.Rows
+}
+
+func (r *xlsxRows) Next() bool { return r.rows.Next() }
+
+// Columns читает значения без применения формата: даты приходят серийными номерами,
+// числа - без разделителей разрядов
+func (r *xlsxRows) Columns() ([]string, error) {
+	return r.rows.Columns(excelize.Options{RawCellValue: true})
+}
+
+func (r *xlsxRows) Height() float64 { return r.rows.GetRowOpts().Height }
+
+func (r *xlsxRows) Error() error { return r.rows.Error() }
+
+func (r *xlsxRows) Close() error { return r.rows.Close() }
+
+// isOpenXMLFileName проверяет, является ли файл книгой Office Open XML
+func isOpenXMLFileName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".xlsx", ".xlsm", ".xltx", ".xltm":
+		return true
+	}
+	return false
+}
+
+// isCSVFileName проверяет, является ли файл текстовой таблицей (.csv, .tsv)
+func isCSVFileName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv", ".tsv":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package merger
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// rejectsHeader - служебные колонки файла отклоненных строк
+var rejectsHeader = []string{"Файл", "Лист", "Строка", "Причина"}
+
+// rejectsWriter пишет отклоненные строки в XLSX или CSV (по расширению файла).
+// Файл создается при первой отклоненной строке. В XLSX строки, не поместившиеся
+// на лист, продолжаются на следующих листах (Sheet1_2, Sheet1_3, ...) с тем же заголовком.
+type rejectsWriter struct {
+	sm      *StreamMerger
+	path    string
+	file    io.WriteCloser
+	xlsx    *excelize.File
+	sw      *excelize.StreamWriter
+	csv     *csv.Writer
+	header  []interface{}
+	sheets  int // число листов XLSX
+	rows    int // строк на текущем листе
+	maxRows int // предел строк листа с заголовком (0 - предел Excel)
+}
+
+// rejectsPath возвращает путь файла отклоненных строк: из -rejects
+// или <out>_rejects.xlsx рядом с результатом
+func rejectsPath(sm *StreamMerger) string {
+	if sm.Cfg.RejectsPath != "" {
+		return sm.Cfg.RejectsPath
+	}
+	base, _ := outputBase(sm.Cfg)
+	return base + "_rejects.xlsx"
+}
+
+func (r *rejectsWriter) isCSV() bool {
+	return strings.EqualFold(filepath.Ext(r.path), ".csv")
+}
+
+// open создает файл и пишет заголовок: служебные колонки и колонки схемы
+func (r *rejectsWriter) open() error {
+	header := append(append([]string{}, rejectsHeader...), r.sm.columnNames()...)
+	if r.isCSV() {
+		f, err := r.sm.createFile(r.path)
+		if err != nil {
+			return fmt.Errorf("ошибка создания файла отклоненных строк: %v", err)
+		}
+		r.file = f
+		r.csv = csv.NewWriter(f)
+		return r.csv.Write(header)
+	}
+
+	r.xlsx = excelize.NewFile()
+	r.header = make([]interface{}, len(header))
+	for i, h := range header {
+		r.header[i] = h
+	}
+	return r.newSheet()
+}
+
+// newSheet начинает следующий лист XLSX и пишет на него заголовок
+func (r *rejectsWriter) newSheet() error {
+	name := r.xlsx.GetSheetList()[0]
+	if r.sheets > 0 {
+		if err := r.sw.Flush(); err != nil {
+			return fmt.Errorf("ошибка записи файла отклоненных строк: %v", err)
+		}
+		name = fmt.Sprintf("%s_%d", name, r.sheets+1)
+		if _, err := r.xlsx.NewSheet(name); err != nil {
+			return fmt.Errorf("ошибка создания листа %s: %v", name, err)
+		}
+	}
+	sw, err := r.xlsx.NewStreamWriter(name)
+	if err != nil {
+		return fmt.Errorf("ошибка создания файла отклоненных строк: %v", err)
+	}
+	r.sw = sw
+	r.sheets++
+	r.rows = 0
+	return r.write(r.header)
+}
+
+func (r *rejectsWriter) write(row []interface{}) error {
+	maxRows := r.maxRows
+	if maxRows <= 0 {
+		maxRows = excelize.TotalRows
+	}
+	if r.rows >= maxRows {
+		if err := r.newSheet(); err != nil {
+			return err
+		}
+	}
+	r.rows++
+	cell, _ := excelize.CoordinatesToCellName(1, r.rows)
+	return r.sw.SetRow(cell, row)
+}
+
+// Write записывает отклоненную строку с указанием источника и причины
+func (r *rejectsWriter) Write(file, sheet string, sourceRow int, reason string, cells []interface{}) error {
+	if r.file == nil && r.xlsx == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	columns := len(r.sm.Headers)
+
+	if r.csv != nil {
+		record := []string{file, sheet, strconv.Itoa(sourceRow), reason}
+		for i := 0; i < columns; i++ {
+			var v interface{}
+			if i < len(cells) {
+				v = r.sm.plainValue(cells[i], i)
+			}
+			record = append(record, r.sm.formatPlainValue(v, i))
+		}
+		return r.csv.Write(record)
+	}
+
+	row := []interface{}{file, sheet, sourceRow, reason}
+	for i := 0; i < columns && i < len(cells); i++ {
+		row = append(row, r.sm.plainValue(cells[i], i))
+	}
+	return r.write(row)
+}
+
+// Abort закрывает файл без сохранения
+func (r *rejectsWriter) Abort() {
+	if r.xlsx != nil {
+		r.xlsx.Close()
+		r.xlsx, r.sw = nil, nil
+	}
+	if r.file != nil {
+		r.file.Close()
+		r.file, r.csv = nil, nil
+	}
+}
+
+// Close сохраняет файл, если в него была записана хотя бы одна строка
+func (r *rejectsWriter) Close() error {
+	switch {
+	case r.csv != nil:
+		r.csv.Flush()
+		err := r.csv.Error()
+		if cerr := r.file.Close(); err == nil {
+			err = cerr
+		}
+		r.csv, r.file = nil, nil
+		if err != nil {
+			return fmt.Errorf("ошибка сохранения файла отклоненных строк: %v", err)
+		}
+	case r.xlsx != nil:
+		defer r.xlsx.Close()
+		if err := r.sw.Flush(); err != nil {
+			return fmt.Errorf("ошибка сохранения файла отклоненных строк: %v", err)
+		}
+		f, err := r.sm.createFile(r.path)
+		if err != nil {
+			return fmt.Errorf("ошибка создания файла отклоненных строк: %v", err)
+		}
+		_, err = r.xlsx.WriteTo(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка сохранения файла отклоненных строк: %v", err)
+		}
+		r.xlsx, r.sw = nil, nil
+	}
+	return nil
+}
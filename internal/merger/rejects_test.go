@@ -0,0 +1,61 @@
+package merger
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestRejectsWriterSheets(t *testing.T) {
+	cfg := config.Default()
+	cfg.HasHeaders = true
+	sm := schemaMerger(cfg, "Код")
+	path := filepath.Join(t.TempDir(), "rejects.xlsx")
+	r := &rejectsWriter{sm: sm, path: path, maxRows: 3}
+	const n = 5
+	for i := 1; i <= n; i++ {
+		if err := r.Write("a.xlsx", "Лист1", i+1, "ошибка", []interface{}{fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sheets := f.GetSheetList()
+	if want := []string{"Sheet1", "Sheet1_2", "Sheet1_3"}; !reflect.DeepEqual(sheets, want) {
+		t.Fatalf("листы = %v, ожидается %v", sheets, want)
+	}
+	header := []string{"Файл", "Лист", "Строка", "Причина", "Код"}
+	var codes []string
+	for _, sheet := range sheets {
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) > 3 || !reflect.DeepEqual(rows[0], header) {
+			t.Errorf("лист %s: %v", sheet, rows)
+			continue
+		}
+		for _, row := range rows[1:] {
+			codes = append(codes, row[4])
+		}
+	}
+	var want []string
+	for i := 1; i <= n; i++ {
+		want = append(want, strconv.Itoa(i))
+	}
+	if !reflect.DeepEqual(codes, want) {
+		t.Errorf("строки = %v, ожидается %v", codes, want)
+	}
+}
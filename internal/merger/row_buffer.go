@@ -0,0 +1,424 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Оценка памяти строки в буфере: заголовок RowPayload, ячейка и байты строковых значений
+const (
+	payloadOverhead = 96
+	cellOverhead    = 48
+)
+
+// rowBuffer - общий буфер прочитанных строк всех входных файлов.
+// Читатели складывают строки в очередь своего файла, писатель забирает их
+// по порядку файлов. Объем буфера ограничен по числу строк и оценке памяти:
+// при превышении читатели ждут, пока писатель освободит место, а если включено
+// вытеснение на диск - дописывают строки во временный сегмент своего файла.
+// Файл, который сейчас записывается, имеет приоритет, а при пустой очереди
+// добавляет строку сверх ограничения: иначе читатели следующих файлов
+// могли бы занять весь бюджет и остановить слияние.
+// В поэтапном режиме (staged) строки файла передаются писателю только после того,
+// как файл прочитан целиком: строки файла с ошибкой чтения отбрасываются (Discard),
+// а строки, не поместившиеся в бюджет, всегда вытесняются на диск.
+type rowBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queues   []rowQueue
+	current  int   // индекс файла, который читает писатель
+	rows     int   // строк в памяти
+	bytes    int64 // оценка памяти строк в буфере
+	maxRows  int   // ограничение числа строк (0 - без ограничения)
+	maxBytes int64 // ограничение памяти в байтах (0 - без ограничения)
+	waiting  int   // число ожидающих горутин
+
+	currentWaiting bool // читатель текущего файла ждет места
+	nextAny        int  // файл, с которого NextAny начинает поиск строк
+
+	spill    bool   // вытеснять строки файлов, опередивших писателя, на диск
+	spillDir string // папка для временных сегментов ("" - системная временная папка)
+	spilled  int64  // вытеснено строк
+	staged   bool   // писатель получает строки файла только после закрытия очереди
+
+	tempDir    string // созданная папка сегментов этого слияния
+	tempDirErr error
+	tempOnce   sync.Once
+}
+
+// rowQueue - очередь строк одного входного файла: последовательность
+// фрагментов в памяти и на диске, которые воспроизводятся по порядку
+type rowQueue struct {
+	chunks []*rowChunk
+	memory int  // строк файла в памяти
+	closed bool // читатель закончил файл
+	done   bool // писатель забрал все строки файла
+}
+
+// rowChunk - фрагмент очереди: строки в памяти или сегмент на диске
+type rowChunk struct {
+	rows    []bufferedRow
+	head    int
+	segment *spillSegment
+	sealed  bool // писатель начал воспроизводить сегмент, запись в него закончена
+}
+
+// bufferedRow - строка в буфере с оценкой занимаемой памяти
+type bufferedRow struct {
+	payload RowPayload
+	size    int64
+}
+
+// newRowBuffer создает буфер для files входных файлов.
+// Ожидание прерывается отменой ctx.
+func newRowBuffer(ctx context.Context, files, maxRows int, maxBytes int64) *rowBuffer {
+	b := &rowBuffer{
+		queues:   make([]rowQueue, files),
+		maxRows:  maxRows,
+		maxBytes: maxBytes,
+	}
+	b.cond = sync.NewCond(&b.mu)
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	return b
+}
+
+// full сообщает, что строка размера size не помещается в бюджет
+func (b *rowBuffer) full(size int64) bool {
+	if b.rows == 0 {
+		// одна строка помещается всегда
+		return false
+	}
+	return (b.maxRows > 0 && b.rows+1 > b.maxRows) || (b.maxBytes > 0 && b.bytes+size > b.maxBytes)
+}
+
+func (b *rowBuffer) wait() {
+	b.waiting++
+	b.cond.Wait()
+	b.waiting--
+}
+
+func (b *rowBuffer) wake() {
+	if b.waiting > 0 {
+		b.cond.Broadcast()
+	}
+}
+
+// Put добавляет строку в очередь файла payload.FileIndex,
+// ожидая свободного места или вытесняя строку на диск, если файл опережает писателя
+func (b *rowBuffer) Put(ctx context.Context, payload RowPayload) error {
+	size := payloadSize(payload)
+	b.mu.Lock()
+	q := &b.queues[payload.FileIndex]
+	for {
+		if err := ctx.Err(); err != nil {
+			b.mu.Unlock()
+			return err
+		}
+		if payload.FileIndex == b.current && !b.staged {
+			if !b.full(size) || q.memory == 0 {
+				break
+			}
+			b.currentWaiting = true
+			b.wait()
+			b.currentWaiting = false
+			continue
+		}
+		if b.spill || b.staged {
+			// после начала вытеснения строки файла идут в сегмент до его запечатывания,
+			// чтобы не дробить очередь на множество мелких фрагментов
+			if last := q.last(); (last != nil && last.segment != nil && !last.sealed) || b.full(size) || b.currentWaiting {
+				seg := b.segment(q)
+				b.mu.Unlock()
+				ok, err := seg.Put(payload)
+				if err != nil {
+					return err
+				}
+				b.mu.Lock()
+				if ok {
+					b.spilled++
+					b.mu.Unlock()
+					return nil
+				}
+				// сегмент запечатан писателем - файл стал текущим
+				continue
+			}
+			break
+		}
+		if !b.full(size) && !b.currentWaiting {
+			break
+		}
+		b.wait()
+	}
+	last := q.last()
+	if last == nil || last.segment != nil {
+		last = &rowChunk{}
+		q.chunks = append(q.chunks, last)
+	}
+	last.rows = append(last.rows, bufferedRow{payload: payload, size: size})
+	q.memory++
+	b.rows++
+	b.bytes += size
+	b.wake()
+	b.mu.Unlock()
+	return nil
+}
+
+// last возвращает последний фрагмент очереди
+func (q *rowQueue) last() *rowChunk {
+	if len(q.chunks) == 0 {
+		return nil
+	}
+	return q.chunks[len(q.chunks)-1]
+}
+
+// segment возвращает открытый сегмент в конце очереди, добавляя новый при необходимости
+func (b *rowBuffer) segment(q *rowQueue) *spillSegment {
+	if last := q.last(); last != nil && last.segment != nil && !last.sealed {
+		return last.segment
+	}
+	seg := &spillSegment{dir: b.segmentDir}
+	q.chunks = append(q.chunks, &rowChunk{segment: seg})
+	return seg
+}
+
+// segmentDir создает при первом вызове папку временных сегментов слияния
+func (b *rowBuffer) segmentDir() (string, error) {
+	b.tempOnce.Do(func() {
+		b.tempDir, b.tempDirErr = os.MkdirTemp(b.spillDir, "xlsx-merger-spill-")
+	})
+	return b.tempDir, b.tempDirErr
+}
+
+// Close отмечает, что строк файла index больше не будет
+func (b *rowBuffer) Close(index int) {
+	b.mu.Lock()
+	b.queues[index].closed = true
+	b.wake()
+	b.mu.Unlock()
+}
+
+// Discard отбрасывает строки файла index, прочитанного с ошибкой, и закрывает его очередь.
+// Только для поэтапного режима: писатель еще не получал строк файла.
+func (b *rowBuffer) Discard(index int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := &b.queues[index]
+	for _, chunk := range q.chunks {
+		if chunk.segment != nil {
+			chunk.segment.remove()
+			continue
+		}
+		for _, row := range chunk.rows[chunk.head:] {
+			b.rows--
+			b.bytes -= row.size
+		}
+	}
+	q.chunks, q.memory = nil, 0
+	q.closed = true
+	b.wake()
+}
+
+// Next возвращает очередную строку файла index. ok = false, если файл прочитан полностью.
+func (b *rowBuffer) Next(ctx context.Context, index int) (payload RowPayload, ok bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current != index {
+		// читатель нового текущего файла мог ждать места в буфере
+		b.current = index
+		b.wake()
+	}
+	return b.next(ctx, index, true)
+}
+
+// Pick ожидает и возвращает файл, который можно начать записывать:
+// еще не записанный файл с готовыми строками или уже прочитанный целиком
+func (b *rowBuffer) Pick(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		for i := range b.queues {
+			if !b.queues[i].done && b.ready(&b.queues[i]) {
+				return i, nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		b.wait()
+	}
+}
+
+// NextAny возвращает очередную строку любого файла, перебирая файлы с готовыми строками
+// по кругу. ok = false, если все файлы прочитаны полностью.
+func (b *rowBuffer) NextAny(ctx context.Context) (payload RowPayload, ok bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// текущего файла нет: приоритет по месту в буфере не нужен, писатель забирает любые строки
+	b.current = -1
+	for {
+		pending := false
+		for n := range b.queues {
+			i := (b.nextAny + n) % len(b.queues)
+			q := &b.queues[i]
+			if q.done {
+				continue
+			}
+			if b.ready(q) {
+				if payload, ok, err = b.next(ctx, i, false); err != nil || ok {
+					b.nextAny = (i + 1) % len(b.queues)
+					return payload, ok, err
+				}
+			}
+			if !q.done {
+				pending = true
+			}
+		}
+		if !pending {
+			return RowPayload{}, false, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return RowPayload{}, false, err
+		}
+		b.wait()
+	}
+}
+
+// ready сообщает, что у файла есть строки для записи или он прочитан целиком;
+// в поэтапном режиме - только что он прочитан целиком
+func (b *rowBuffer) ready(q *rowQueue) bool {
+	if q.closed {
+		return true
+	}
+	if b.staged {
+		return false
+	}
+	for _, chunk := range q.chunks {
+		if chunk.segment != nil || chunk.head < len(chunk.rows) {
+			return true
+		}
+	}
+	return false
+}
+
+// next возвращает очередную строку файла index, при block ожидая ее появления
+// (без block при отсутствии строк возвращается ok = false, файл не отмечается записанным).
+// Вызывается под b.mu; на время чтения сегмента с диска блокировка снимается.
+func (b *rowBuffer) next(ctx context.Context, index int, block bool) (payload RowPayload, ok bool, err error) {
+	q := &b.queues[index]
+	for {
+		if b.staged && !q.closed {
+			// строки файла передаются только после его успешного чтения
+			if !block {
+				return RowPayload{}, false, nil
+			}
+			if err := ctx.Err(); err != nil {
+				return RowPayload{}, false, err
+			}
+			b.wait()
+			continue
+		}
+		if len(q.chunks) > 0 {
+			chunk := q.chunks[0]
+			if seg := chunk.segment; seg != nil {
+				// запечатанный сегмент принадлежит писателю, читать его можно без блокировки
+				chunk.sealed = true
+				b.mu.Unlock()
+				err := seg.seal()
+				if err == nil {
+					payload, ok, err = seg.next()
+				}
+				b.mu.Lock()
+				if err != nil {
+					return RowPayload{}, false, err
+				}
+				if ok {
+					return payload, true, nil
+				}
+				seg.remove()
+				q.chunks = q.chunks[1:]
+				continue
+			}
+			if chunk.head < len(chunk.rows) {
+				row := chunk.rows[chunk.head]
+				chunk.rows[chunk.head] = bufferedRow{}
+				chunk.head++
+				if chunk.head == len(chunk.rows) {
+					// фрагмент опустел: память под него можно переиспользовать
+					chunk.rows, chunk.head = chunk.rows[:0], 0
+				}
+				q.memory--
+				b.rows--
+				b.bytes -= row.size
+				b.wake()
+				return row.payload, true, nil
+			}
+			if len(q.chunks) > 1 {
+				q.chunks = q.chunks[1:]
+				continue
+			}
+		}
+		if q.closed {
+			q.chunks = nil
+			q.done = true
+			b.wake()
+			return RowPayload{}, false, nil
+		}
+		if !block {
+			return RowPayload{}, false, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return RowPayload{}, false, err
+		}
+		b.wait()
+	}
+}
+
+// Spilled возвращает число строк, вытесненных на диск
+func (b *rowBuffer) Spilled() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.spilled
+}
+
+// Cleanup удаляет временные сегменты. Вызывается после завершения читателей и писателя.
+func (b *rowBuffer) Cleanup() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.queues {
+		for _, chunk := range b.queues[i].chunks {
+			if chunk.segment != nil {
+				chunk.segment.remove()
+			}
+		}
+		b.queues[i].chunks = nil
+	}
+	if b.tempDir == "" {
+		return nil
+	}
+	if err := os.RemoveAll(b.tempDir); err != nil {
+		return fmt.Errorf("ошибка удаления временных сегментов: %v", err)
+	}
+	return nil
+}
+
+// payloadSize приблизительно оценивает память, занимаемую строкой
+func payloadSize(p RowPayload) int64 {
+	size := int64(payloadOverhead + cellOverhead*len(p.Cells) + len(p.Sheet) + len(p.Reject))
+	for _, cell := range p.Cells {
+		if c, ok := cell.(excelize.Cell); ok {
+			cell = c.Value
+		}
+		if s, ok := cell.(string); ok {
+			size += int64(len(s))
+		}
+	}
+	return size
+}
@@ -0,0 +1,198 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+// drainBuffer забирает строки из буфера так, как это делает писатель в режиме mode,
+// и возвращает пары (файл, строка) в порядке получения
+func drainBuffer(ctx context.Context, b *rowBuffer, mode string, files int) ([][2]int, error) {
+	var out [][2]int
+	add := func(p RowPayload) { out = append(out, [2]int{p.FileIndex, p.SourceRow}) }
+	if mode == config.MergeInterleaved {
+		for {
+			p, ok, err := b.NextAny(ctx)
+			if err != nil || !ok {
+				return out, err
+			}
+			add(p)
+		}
+	}
+	for n := 0; n < files; n++ {
+		index := n
+		if mode == config.MergeContiguous {
+			var err error
+			if index, err = b.Pick(ctx); err != nil {
+				return out, err
+			}
+		}
+		for {
+			p, ok, err := b.Next(ctx, index)
+			if err != nil {
+				return out, err
+			}
+			if !ok {
+				break
+			}
+			add(p)
+		}
+	}
+	return out, nil
+}
+
+func TestRowBufferModes(t *testing.T) {
+	const files, rows = 4, 50
+	for _, mode := range []string{config.MergeOrdered, config.MergeContiguous, config.MergeInterleaved} {
+		for _, spill := range []bool{false, true} {
+			for _, staged := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s/spill=%v/staged=%v", mode, spill, staged), func(t *testing.T) {
+					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+					defer cancel()
+					// бюджет в 3 строки заставляет читателей ждать писателя
+					b := newRowBuffer(ctx, files, 3, 0)
+					b.spill = spill && mode != config.MergeInterleaved
+					b.spillDir = t.TempDir()
+					b.staged = staged
+					defer b.Cleanup()
+
+					var wg sync.WaitGroup
+					errs := make(chan error, files)
+					for f := 0; f < files; f++ {
+						wg.Add(1)
+						go func(f int) {
+							defer wg.Done()
+							for r := 1; r <= rows; r++ {
+								p := RowPayload{Cells: []interface{}{fmt.Sprint(f, r)}, FileIndex: f, SourceRow: r}
+								if err := b.Put(ctx, p); err != nil {
+									errs <- err
+									return
+								}
+							}
+							b.Close(f)
+						}(f)
+					}
+					out, err := drainBuffer(ctx, b, mode, files)
+					wg.Wait()
+					close(errs)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for err := range errs {
+						t.Fatal(err)
+					}
+
+					// строки каждого файла - все, без повторов и по порядку
+					next := make([]int, files)
+					var blocks []int // файлы в порядке появления непрерывных блоков строк
+					for i, p := range out {
+						f, r := p[0], p[1]
+						if r != next[f]+1 {
+							t.Fatalf("файл %d: строка %d после %d", f, r, next[f])
+						}
+						next[f] = r
+						if i == 0 || out[i-1][0] != f {
+							blocks = append(blocks, f)
+						}
+					}
+					for f, n := range next {
+						if n != rows {
+							t.Errorf("файл %d: получено %d строк из %d", f, n, rows)
+						}
+					}
+					switch mode {
+					case config.MergeOrdered:
+						if fmt.Sprint(blocks) != "[0 1 2 3]" {
+							t.Errorf("файлы записаны в порядке %v", blocks)
+						}
+					case config.MergeContiguous:
+						if len(blocks) != files {
+							t.Errorf("строки файлов перемешаны: блоки %v", blocks)
+						}
+					}
+					if b.rows != 0 || b.bytes != 0 {
+						t.Errorf("в буфере осталось %d строк, %d байт", b.rows, b.bytes)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestRowBufferBackpressure(t *testing.T) {
+	ctx := context.Background()
+	b := newRowBuffer(ctx, 2, 2, 0)
+	put := func(f, r int) error {
+		return b.Put(ctx, RowPayload{Cells: []interface{}{"x"}, FileIndex: f, SourceRow: r})
+	}
+	// следующий файл занимает бюджет, третья строка ждет писателя
+	for r := 1; r <= 2; r++ {
+		if err := put(1, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocked := make(chan error, 1)
+	go func() { blocked <- put(1, 3) }()
+	select {
+	case err := <-blocked:
+		t.Fatalf("строка сверх бюджета добавлена без ожидания: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// текущий файл добавляет строку сверх бюджета, если его очередь пуста
+	if err := put(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	b.Close(0)
+	out, err := drainBuffer(ctx, b, config.MergeOrdered, 1)
+	if err != nil || fmt.Sprint(out) != "[[0 1]]" {
+		t.Fatalf("файл 0: %v, ошибка %v", out, err)
+	}
+	// писатель перешел к файлу 1 и освободил место
+	p, ok, err := b.Next(ctx, 1)
+	if err != nil || !ok || p.SourceRow != 1 {
+		t.Fatalf("файл 1: строка %d, ok %v, ошибка %v", p.SourceRow, ok, err)
+	}
+	select {
+	case err := <-blocked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("читатель не дождался места в буфере")
+	}
+}
+
+func TestRowBufferDiscard(t *testing.T) {
+	ctx := context.Background()
+	b := newRowBuffer(ctx, 2, 2, 0)
+	b.staged = true
+	b.spillDir = t.TempDir()
+	defer b.Cleanup()
+	// строки файла 0 не помещаются в бюджет и частично вытесняются на диск
+	for r := 1; r <= 5; r++ {
+		if err := b.Put(ctx, RowPayload{Cells: []interface{}{"x"}, FileIndex: 0, SourceRow: r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Put(ctx, RowPayload{Cells: []interface{}{"y"}, FileIndex: 1, SourceRow: 1}); err != nil {
+		t.Fatal(err)
+	}
+	b.Close(1)
+	b.Discard(0)
+	if b.Spilled() == 0 {
+		t.Error("ожидается вытеснение строк на диск")
+	}
+	out, err := drainBuffer(ctx, b, config.MergeOrdered, 2)
+	if err != nil || fmt.Sprint(out) != "[[1 1]]" {
+		t.Errorf("строки %v, ошибка %v; ожидается только строка файла 1", out, err)
+	}
+	if b.rows != 0 || b.bytes != 0 {
+		t.Errorf("в буфере осталось %d строк, %d байт", b.rows, b.bytes)
+	}
+}
@@ -0,0 +1,82 @@
+package merger
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSheetNameLength - ограничение Excel на длину имени листа
+const maxSheetNameLength = 31
+
+// selectSheets отбирает листы исходного файла согласно конфигурации:
+// все листы, по имени, по регулярному выражению или по номеру (с 1).
+// По умолчанию используется первый лист.
+func (sm *StreamMerger) selectSheets(sheets []string) []string {
+	if len(sheets) == 0 {
+		return nil
+	}
+
+	switch {
+	case sm.Cfg.AllSheets:
+		return sheets
+	case sm.Cfg.SheetName != "":
+		for _, name := range sheets {
+			if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(sm.Cfg.SheetName)) {
+				return []string{name}
+			}
+		}
+		return nil
+	case sm.sheetPattern != nil:
+		var selected []string
+		for _, name := range sheets {
+			if sm.sheetPattern.MatchString(name) {
+				selected = append(selected, name)
+			}
+		}
+		return selected
+	case sm.Cfg.SheetIndex > 0:
+		if sm.Cfg.SheetIndex > len(sheets) {
+			return nil
+		}
+		return []string{sheets[sm.Cfg.SheetIndex-1]}
+	}
+
+	return sheets[:1]
+}
+
+// sanitizeSheetName приводит имя к допустимому в Excel:
+// без символов []:*?/\, без апострофов по краям и не длиннее 31 символа
+func sanitizeSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, "'")
+	for utf8.RuneCountInString(name) > maxSheetNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if strings.TrimSpace(name) == "" {
+		return "Sheet"
+	}
+	return name
+}
+
+// appendUnique добавляет в список значения, которых в нем еще нет
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,57 @@
+package merger
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+func TestSelectSheets(t *testing.T) {
+	sheets := []string{"Январь", "Февраль", " Итоги ", "Март"}
+	tests := []struct {
+		name  string
+		setup func(cfg *config.Config)
+		regex string
+		want  []string
+	}{
+		{"по умолчанию первый лист", func(cfg *config.Config) {}, "", []string{"Январь"}},
+		{"все листы", func(cfg *config.Config) { cfg.AllSheets = true }, "", sheets},
+		{"по имени без учета регистра и пробелов", func(cfg *config.Config) { cfg.SheetName = "итоги" }, "", []string{" Итоги "}},
+		{"имя не найдено", func(cfg *config.Config) { cfg.SheetName = "Апрель" }, "", nil},
+		{"по регулярному выражению", func(cfg *config.Config) {}, "^(Январь|Март)$", []string{"Январь", "Март"}},
+		{"по номеру", func(cfg *config.Config) { cfg.SheetIndex = 2 }, "", []string{"Февраль"}},
+		{"номер больше числа листов", func(cfg *config.Config) { cfg.SheetIndex = 5 }, "", nil},
+	}
+	for _, tt := range tests {
+		cfg := config.Default()
+		tt.setup(cfg)
+		sm := schemaMerger(cfg)
+		if tt.regex != "" {
+			sm.sheetPattern = regexp.MustCompile(tt.regex)
+		}
+		if got := sm.selectSheets(sheets); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, ожидается %q", tt.name, got, tt.want)
+		}
+		if got := sm.selectSheets(nil); got != nil {
+			t.Errorf("%s: листы пустой книги %q", tt.name, got)
+		}
+	}
+}
+
+func TestSanitizeSheetName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"Отчет", "Отчет"},
+		{"a/b\\c[1]:*?", "a_b_c_1____"},
+		{"'Итоги'", "Итоги"},
+		{"Очень длинное имя листа больше предела", "Очень длинное имя листа больше "},
+		{"  ", "Sheet"},
+		{"''", "Sheet"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeSheetName(tt.name); got != tt.want {
+			t.Errorf("sanitizeSheetName(%q) = %q, ожидается %q", tt.name, got, tt.want)
+		}
+	}
+}
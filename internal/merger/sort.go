@@ -0,0 +1,358 @@
+package merger
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// sortMaxFanIn - число сегментов, сливаемых за один проход
+const sortMaxFanIn = 64
+
+// Порядок значений разных типов в колонке сортировки; пустые значения - всегда в конце
+const (
+	sortNumber = iota
+	sortTime
+	sortBool
+	sortText
+	sortNull
+)
+
+// sortColumn - колонка сортировки
+type sortColumn struct {
+	col  int
+	desc bool
+}
+
+// sortValue - значение колонки сортировки, приведенное к сравнимому виду.
+// Текст хранится ключом сортировки с учетом алфавита и без учета регистра.
+type sortValue struct {
+	kind int
+	num  float64
+	str  string
+}
+
+// sortedRow - строка в памяти сортировки с ключом
+type sortedRow struct {
+	payload RowPayload
+	key     []sortValue
+}
+
+// sorter сортирует строки результата по колонкам -sort внешней сортировкой слиянием:
+// строки копятся в памяти до -sort-mb, упорядочиваются и сбрасываются во временный сегмент;
+// после чтения всех файлов сегменты сливаются и строки передаются записи результата.
+// Сортировка устойчивая: строки с равными ключами остаются в порядке поступления.
+type sorter struct {
+	sm       *StreamMerger
+	columns  []sortColumn
+	collator *collate.Collator
+	keyBuf   collate.Buffer
+	rows     []sortedRow
+	bytes    int64
+	maxBytes int64
+	runs     []*spillSegment // отсортированные сегменты в порядке поступления строк
+	segments []*spillSegment // все созданные сегменты (для удаления)
+	tempDir  string
+}
+
+// newSorter создает этап сортировки по настройкам -sort
+func (sm *StreamMerger) newSorter() (*sorter, error) {
+	s := &sorter{
+		sm:       sm,
+		collator: collate.New(language.Russian, collate.IgnoreCase),
+		maxBytes: int64(sm.Cfg.SortMB) << 20,
+	}
+	for _, key := range sm.Cfg.Sort {
+		name, desc := config.SortKey(key)
+		col := sm.columnIndex(name)
+		if col < 0 {
+			return nil, fmt.Errorf("сортировка: колонка %q не найдена в шаблоне", name)
+		}
+		s.columns = append(s.columns, sortColumn{col: col, desc: desc})
+	}
+	return s, nil
+}
+
+// dir создает при первом вызове папку временных сегментов
+func (s *sorter) dir() (string, error) {
+	if s.tempDir == "" {
+		dir, err := os.MkdirTemp(s.sm.Cfg.SpillDir, "xlsx-merger-sort-")
+		if err != nil {
+			return "", err
+		}
+		s.tempDir = dir
+	}
+	return s.tempDir, nil
+}
+
+// key вычисляет значения колонок сортировки строки по типам колонок схемы:
+// числа и даты сравниваются как значения, текст - по алфавиту
+func (s *sorter) key(p RowPayload) []sortValue {
+	key := make([]sortValue, len(s.columns))
+	for i, c := range s.columns {
+		switch v := s.sm.plainValue(cellValue(p.Cells, c.col), c.col).(type) {
+		case nil:
+			key[i].kind = sortNull
+		case float64:
+			key[i] = sortValue{kind: sortNumber, num: v}
+		case int:
+			key[i] = sortValue{kind: sortNumber, num: float64(v)}
+		case int64:
+			key[i] = sortValue{kind: sortNumber, num: float64(v)}
+		case time.Time:
+			key[i] = sortValue{kind: sortTime, num: float64(v.UnixMicro())}
+		case bool:
+			key[i] = sortValue{kind: sortBool}
+			if v {
+				key[i].num = 1
+			}
+		case string:
+			if v = strings.TrimSpace(v); v == "" {
+				key[i].kind = sortNull
+				continue
+			}
+			// значение, не приведенное к числу в числовой колонке, - после чисел
+			key[i] = sortValue{kind: sortText, str: string(s.collator.KeyFromString(&s.keyBuf, v))}
+			s.keyBuf.Reset()
+		default:
+			key[i] = sortValue{kind: sortText, str: fmt.Sprint(v)}
+		}
+	}
+	return key
+}
+
+// compare сравнивает ключи строк: -1, 0, 1
+func (s *sorter) compare(a, b []sortValue) int {
+	for i, c := range s.columns {
+		x, y := a[i], b[i]
+		if x.kind == sortNull || y.kind == sortNull {
+			// пустые значения в конце при любом направлении
+			switch {
+			case x.kind != sortNull:
+				return -1
+			case y.kind != sortNull:
+				return 1
+			}
+			continue
+		}
+		r := cmp.Compare(x.kind, y.kind)
+		if r == 0 {
+			r = cmp.Compare(x.num, y.num)
+		}
+		if r == 0 {
+			r = strings.Compare(x.str, y.str)
+		}
+		if c.desc {
+			r = -r
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	return 0
+}
+
+// Add добавляет строку в сортировку, сбрасывая накопленные строки на диск при превышении -sort-mb
+func (s *sorter) Add(p RowPayload) error {
+	key := s.key(p)
+	size := payloadSize(p) + int64(len(key))*32
+	for _, v := range key {
+		size += int64(len(v.str))
+	}
+	if s.bytes+size > s.maxBytes && len(s.rows) > 0 {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	s.rows = append(s.rows, sortedRow{payload: p, key: key})
+	s.bytes += size
+	return nil
+}
+
+// sortRows упорядочивает строки в памяти
+func (s *sorter) sortRows() {
+	slices.SortStableFunc(s.rows, func(a, b sortedRow) int { return s.compare(a.key, b.key) })
+}
+
+// flush сортирует строки в памяти и сохраняет их во временный сегмент
+func (s *sorter) flush() error {
+	s.sortRows()
+	seg := s.segment()
+	s.runs = append(s.runs, seg)
+	for _, row := range s.rows {
+		if _, err := seg.Put(row.payload); err != nil {
+			return err
+		}
+	}
+	if err := seg.seal(); err != nil {
+		return err
+	}
+	clear(s.rows)
+	s.rows, s.bytes = s.rows[:0], 0
+	return nil
+}
+
+// Finish передает отсортированные строки функции write
+func (s *sorter) Finish(ctx context.Context, write func(RowPayload) error) error {
+	if len(s.runs) == 0 {
+		s.sortRows()
+		for i, row := range s.rows {
+			if i%1024 == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			if err := write(row.payload); err != nil {
+				return err
+			}
+			s.rows[i] = sortedRow{}
+		}
+		s.rows = nil
+		return nil
+	}
+	if len(s.rows) > 0 {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	s.rows = nil
+
+	// при большом числе сегментов соседние сегменты предварительно сливаются группами,
+	// чтобы не держать открытыми сотни файлов; порядок групп сохраняет устойчивость
+	for len(s.runs) > sortMaxFanIn {
+		var merged []*spillSegment
+		for start := 0; start < len(s.runs); start += sortMaxFanIn {
+			group := s.runs[start:min(start+sortMaxFanIn, len(s.runs))]
+			if len(group) == 1 {
+				merged = append(merged, group[0])
+				continue
+			}
+			seg := s.segment()
+			err := s.merge(ctx, group, func(p RowPayload) error {
+				_, err := seg.Put(p)
+				return err
+			})
+			if err == nil {
+				err = seg.seal()
+			}
+			if err != nil {
+				return err
+			}
+			merged = append(merged, seg)
+		}
+		s.runs = merged
+	}
+	return s.merge(ctx, s.runs, write)
+}
+
+// segment создает временный сегмент
+func (s *sorter) segment() *spillSegment {
+	seg := &spillSegment{dir: s.dir}
+	s.segments = append(s.segments, seg)
+	return seg
+}
+
+// merge сливает отсортированные сегменты в порядке ключей; при равных ключах
+// первым идет сегмент с более ранними строками. Прочитанные сегменты удаляются.
+func (s *sorter) merge(ctx context.Context, runs []*spillSegment, write func(RowPayload) error) error {
+	h := &sortHeap{s: s}
+	for i, seg := range runs {
+		if err := h.advance(&sortCursor{seg: seg, run: i}); err != nil {
+			return err
+		}
+	}
+	for n := 0; h.Len() > 0; n++ {
+		if n%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		c := heap.Pop(h).(*sortCursor)
+		if err := write(c.payload); err != nil {
+			return err
+		}
+		if err := h.advance(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close удаляет временные сегменты
+func (s *sorter) Close() {
+	for _, seg := range s.segments {
+		seg.remove()
+	}
+	s.runs, s.segments = nil, nil
+	if s.tempDir != "" {
+		os.RemoveAll(s.tempDir)
+	}
+}
+
+// sortCursor - очередная строка сегмента при слиянии
+type sortCursor struct {
+	seg     *spillSegment
+	run     int
+	payload RowPayload
+	key     []sortValue
+}
+
+// sortHeap - куча сегментов по ключу очередной строки
+type sortHeap struct {
+	s       *sorter
+	cursors []*sortCursor
+}
+
+// advance читает следующую строку сегмента и возвращает курсор в кучу;
+// прочитанный до конца сегмент удаляется
+func (h *sortHeap) advance(c *sortCursor) error {
+	p, ok, err := c.seg.next()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		c.seg.remove()
+		return nil
+	}
+	c.payload, c.key = p, h.s.key(p)
+	heap.Push(h, c)
+	return nil
+}
+
+func (h *sortHeap) Len() int { return len(h.cursors) }
+
+func (h *sortHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	if r := h.s.compare(a.key, b.key); r != 0 {
+		return r < 0
+	}
+	return a.run < b.run
+}
+
+func (h *sortHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *sortHeap) Push(x any) { h.cursors = append(h.cursors, x.(*sortCursor)) }
+
+func (h *sortHeap) Pop() any {
+	c := h.cursors[len(h.cursors)-1]
+	h.cursors[len(h.cursors)-1] = nil
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return c
+}
+
+// sortRow передает строку сортировке, если она включена, иначе сразу пишет ее в результат
+func (sm *StreamMerger) sortRow(p RowPayload) error {
+	if sm.sorter != nil {
+		return sm.sorter.Add(p)
+	}
+	return sm.writeRow(p)
+}
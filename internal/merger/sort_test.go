@@ -0,0 +1,161 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// testSorter создает сортировку по колонкам columns с памятью maxBytes
+func testSorter(t *testing.T, maxBytes int64, columns ...sortColumn) *sorter {
+	t.Helper()
+	cfg := config.Default()
+	cfg.SpillDir = t.TempDir()
+	s := &sorter{
+		sm:       schemaMerger(cfg),
+		columns:  columns,
+		collator: collate.New(language.Russian, collate.IgnoreCase),
+		maxBytes: maxBytes,
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// sortRows сортирует строки и возвращает значения колонок в порядке записи
+func sortRows(t *testing.T, s *sorter, rows [][]interface{}) [][]interface{} {
+	t.Helper()
+	for i, cells := range rows {
+		if err := s.Add(RowPayload{Cells: cells, SourceRow: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out [][]interface{}
+	err := s.Finish(context.Background(), func(p RowPayload) error {
+		out = append(out, p.Cells)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSorterOrder(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name    string
+		columns []sortColumn
+		rows    [][]interface{}
+		want    [][]interface{}
+	}{
+		{
+			name:    "числа, текст после чисел, пустые в конце",
+			columns: []sortColumn{{col: 0}},
+			rows:    [][]interface{}{{10.0}, {"б"}, {nil}, {2}, {" "}, {"А"}, {-1.5}},
+			want:    [][]interface{}{{-1.5}, {2}, {10.0}, {"А"}, {"б"}, {nil}, {" "}},
+		},
+		{
+			name:    "int64 сравниваются как числа",
+			columns: []sortColumn{{col: 0}},
+			rows:    [][]interface{}{{int64(10)}, {int64(9)}, {2.5}, {int64(-3)}, {"1"}},
+			want:    [][]interface{}{{int64(-3)}, {2.5}, {int64(9)}, {int64(10)}, {"1"}},
+		},
+		{
+			name:    "по убыванию, пустые все равно в конце",
+			columns: []sortColumn{{col: 0, desc: true}},
+			rows:    [][]interface{}{{1.0}, {nil}, {3.0}, {2.0}},
+			want:    [][]interface{}{{3.0}, {2.0}, {1.0}, {nil}},
+		},
+		{
+			name:    "алфавит без учета регистра, ё после е",
+			columns: []sortColumn{{col: 0}},
+			rows:    [][]interface{}{{"ж"}, {"Ё"}, {"е"}, {"Я"}, {"а"}},
+			want:    [][]interface{}{{"а"}, {"е"}, {"Ё"}, {"ж"}, {"Я"}},
+		},
+		{
+			name:    "даты",
+			columns: []sortColumn{{col: 0}},
+			rows:    [][]interface{}{{day(3)}, {day(1)}, {day(2)}},
+			want:    [][]interface{}{{day(1)}, {day(2)}, {day(3)}},
+		},
+		{
+			name:    "две колонки и устойчивость",
+			columns: []sortColumn{{col: 0}, {col: 1, desc: true}},
+			rows: [][]interface{}{
+				{"b", 1.0, "1"}, {"a", 1.0, "2"}, {"b", 2.0, "3"}, {"a", 1.0, "4"}, {"a", 2.0, "5"},
+			},
+			want: [][]interface{}{
+				{"a", 2.0, "5"}, {"a", 1.0, "2"}, {"a", 1.0, "4"}, {"b", 2.0, "3"}, {"b", 1.0, "1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		for _, mem := range []int64{1 << 20, 1} {
+			t.Run(fmt.Sprintf("%s/%d", tt.name, mem), func(t *testing.T) {
+				got := sortRows(t, testSorter(t, mem, tt.columns...), tt.rows)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("порядок = %v, ожидается %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// openFiles возвращает число открытых дескрипторов процесса
+func openFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("нет /proc/self/fd")
+	}
+	return len(entries)
+}
+
+func TestSorterManyRuns(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("подсчет дескрипторов только для Linux")
+	}
+	// каждая строка - отдельный сегмент: несколько проходов слияния
+	const n = sortMaxFanIn*3 + 5
+	s := testSorter(t, 1, sortColumn{col: 0})
+	before := openFiles(t)
+	var rows [][]interface{}
+	for i := 0; i < n; i++ {
+		rows = append(rows, []interface{}{float64((i * 37) % n), float64(i)})
+		if err := s.Add(RowPayload{Cells: rows[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.runs) < n-1 {
+		t.Fatalf("сегментов %d, ожидается не меньше %d", len(s.runs), n-1)
+	}
+	if open := openFiles(t) - before; open > 1 {
+		t.Errorf("запечатанные сегменты держат открытыми %d файлов", open)
+	}
+	peak, prev := 0, -1.0
+	err := s.Finish(context.Background(), func(p RowPayload) error {
+		peak = max(peak, openFiles(t)-before)
+		v := p.Cells[0].(float64)
+		if v < prev {
+			t.Fatalf("нарушен порядок: %v после %v", v, prev)
+		}
+		prev = v
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev != n-1 {
+		t.Errorf("последнее значение %v, ожидается %d", prev, n-1)
+	}
+	if peak > sortMaxFanIn+1 {
+		t.Errorf("при слиянии открыто %d файлов, ожидается не больше %d", peak, sortMaxFanIn+1)
+	}
+}
@@ -0,0 +1,298 @@
+package merger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// spillBufferSize - размер буфера записи и чтения сегмента
+const spillBufferSize = 64 << 10
+
+// spillSegment - временный файл со строками одного входного файла, вытесненными из памяти.
+// Читатель дописывает строки, пока сегмент не запечатан; после запечатывания
+// сегмент принадлежит писателю, который воспроизводит строки по порядку.
+// Запечатанный сегмент не держит файл открытым до начала чтения: сортировка
+// может накопить сотни сегментов, а одновременно читаются не больше sortMaxFanIn.
+type spillSegment struct {
+	mu     sync.Mutex // защищает запись и запечатывание
+	dir    func() (string, error)
+	path   string
+	file   *os.File
+	w      *bufio.Writer
+	r      *bufio.Reader
+	buf    []byte // буфер кодирования строки
+	rows   int64  // записано строк
+	sealed bool
+	err    error // ошибка записи, возвращается при воспроизведении
+}
+
+// Put дописывает строку в сегмент. ok = false, если сегмент уже запечатан.
+func (s *spillSegment) Put(p RowPayload) (ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return false, nil
+	}
+	if s.err != nil {
+		return true, s.err
+	}
+	if s.file == nil {
+		dir, err := s.dir()
+		if err == nil {
+			s.file, err = os.CreateTemp(dir, "segment-*.bin")
+		}
+		if err != nil {
+			s.err = fmt.Errorf("ошибка создания временного сегмента: %v", err)
+			return true, s.err
+		}
+		s.path = s.file.Name()
+		s.w = bufio.NewWriterSize(s.file, spillBufferSize)
+	}
+	s.buf, err = appendPayload(s.buf[:0], p)
+	if err == nil {
+		_, err = s.w.Write(s.buf)
+	}
+	if err != nil {
+		s.err = fmt.Errorf("ошибка записи временного сегмента: %v", err)
+		return true, s.err
+	}
+	s.rows++
+	return true, nil
+}
+
+// seal запрещает дальнейшую запись и закрывает файл сегмента до начала чтения
+func (s *spillSegment) seal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return s.err
+	}
+	s.sealed = true
+	if s.err != nil || s.file == nil {
+		return s.err
+	}
+	err := s.w.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file, s.w = nil, nil
+	if err != nil {
+		s.err = fmt.Errorf("ошибка записи временного сегмента: %v", err)
+	}
+	return s.err
+}
+
+// next читает очередную строку запечатанного сегмента, открывая файл при первом вызове.
+// ok = false, если строки закончились.
+func (s *spillSegment) next() (p RowPayload, ok bool, err error) {
+	if s.rows == 0 {
+		return RowPayload{}, false, nil
+	}
+	if s.r == nil {
+		s.mu.Lock()
+		s.file, err = os.Open(s.path)
+		s.mu.Unlock()
+		if err != nil {
+			return RowPayload{}, false, fmt.Errorf("ошибка чтения временного сегмента: %v", err)
+		}
+		s.r = bufio.NewReaderSize(s.file, spillBufferSize)
+	}
+	if p, err = readPayload(s.r); err != nil {
+		return RowPayload{}, false, fmt.Errorf("ошибка чтения временного сегмента: %v", err)
+	}
+	s.rows--
+	return p, true, nil
+}
+
+// remove закрывает и удаляет файл сегмента
+func (s *spillSegment) remove() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sealed = true
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	if s.path != "" {
+		os.Remove(s.path)
+		s.path = ""
+	}
+}
+
+// Кодирование строки в сегменте: индекс файла, лист, высота, номер исходной строки, причина отклонения,
+// число ячеек и ячейки. Строки - длина (uvarint) и байты, числа - varint или 8 байт float64.
+// Ячейка начинается с байта типа значения.
+const (
+	spillNil byte = iota
+	spillString
+	spillFloat
+	spillInt
+	spillInt64
+	spillFalse
+	spillTrue
+	spillTime
+	spillCell // excelize.Cell: стиль, формула и вложенное значение
+)
+
+func appendPayload(dst []byte, p RowPayload) ([]byte, error) {
+	dst = binary.AppendVarint(dst, int64(p.FileIndex))
+	dst = appendString(dst, p.Sheet)
+	dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(p.Height))
+	dst = binary.AppendVarint(dst, int64(p.SourceRow))
+	dst = appendString(dst, p.Reject)
+	dst = binary.AppendUvarint(dst, uint64(len(p.Cells)))
+	var err error
+	for _, cell := range p.Cells {
+		if dst, err = appendValue(dst, cell); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func appendValue(dst []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(dst, spillNil), nil
+	case string:
+		return appendString(append(dst, spillString), v), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(dst, spillFloat), math.Float64bits(v)), nil
+	case int:
+		return binary.AppendVarint(append(dst, spillInt), int64(v)), nil
+	case int64:
+		return binary.AppendVarint(append(dst, spillInt64), v), nil
+	case bool:
+		if v {
+			return append(dst, spillTrue), nil
+		}
+		return append(dst, spillFalse), nil
+	case time.Time:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return appendString(append(dst, spillTime), string(b)), nil
+	case excelize.Cell:
+		dst = binary.AppendVarint(append(dst, spillCell), int64(v.StyleID))
+		dst = appendString(dst, v.Formula)
+		return appendValue(dst, v.Value)
+	}
+	return nil, fmt.Errorf("неподдерживаемый тип значения во временном сегменте: %T", v)
+}
+
+func readPayload(r *bufio.Reader) (RowPayload, error) {
+	var p RowPayload
+	index, err := binary.ReadVarint(r)
+	if err != nil {
+		return p, err
+	}
+	p.FileIndex = int(index)
+	if p.Sheet, err = readString(r); err != nil {
+		return p, err
+	}
+	var height [8]byte
+	if _, err = io.ReadFull(r, height[:]); err != nil {
+		return p, err
+	}
+	p.Height = math.Float64frombits(binary.LittleEndian.Uint64(height[:]))
+	row, err := binary.ReadVarint(r)
+	if err != nil {
+		return p, err
+	}
+	p.SourceRow = int(row)
+	if p.Reject, err = readString(r); err != nil {
+		return p, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return p, err
+	}
+	p.Cells = make([]interface{}, n)
+	for i := range p.Cells {
+		if p.Cells[i], err = readValue(r); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readValue(r *bufio.Reader) (interface{}, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case spillNil:
+		return nil, nil
+	case spillString:
+		return readString(r)
+	case spillFloat:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+	case spillInt:
+		n, err := binary.ReadVarint(r)
+		return int(n), err
+	case spillInt64:
+		return binary.ReadVarint(r)
+	case spillFalse:
+		return false, nil
+	case spillTrue:
+		return true, nil
+	case spillTime:
+		s, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		var t time.Time
+		err = t.UnmarshalBinary([]byte(s))
+		return t, err
+	case spillCell:
+		style, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		formula, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readValue(r)
+		if err != nil {
+			return nil, err
+		}
+		return excelize.Cell{StyleID: int(style), Formula: formula, Value: value}, nil
+	}
+	return nil, errors.New("поврежденный временный сегмент")
+}
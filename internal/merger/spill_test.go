@@ -0,0 +1,66 @@
+package merger
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestSpillSegmentRoundTrip(t *testing.T) {
+	moment := time.Date(2024, 5, 1, 13, 45, 30, 123, time.FixedZone("MSK", 3*3600))
+	rows := []RowPayload{
+		{
+			FileIndex: 3, Sheet: "Лист1", Height: 21.5, SourceRow: 42, Reject: "Сумма: не число",
+			Cells: []interface{}{nil, "", "текст", 1.25, math.Inf(-1), 7, int64(-1 << 40), true, false, moment},
+		},
+		{
+			Cells: []interface{}{
+				excelize.Cell{StyleID: 5, Formula: "SUM(A1:A2)", Value: 3.0},
+				excelize.Cell{StyleID: 2, Value: int64(9)},
+				excelize.Cell{Value: nil},
+			},
+		},
+		{FileIndex: 1, Cells: []interface{}{}},
+	}
+	dir := t.TempDir()
+	seg := &spillSegment{dir: func() (string, error) { return dir, nil }}
+	defer seg.remove()
+	for _, p := range rows {
+		if ok, err := seg.Put(p); !ok || err != nil {
+			t.Fatalf("Put = %v, %v", ok, err)
+		}
+	}
+	if err := seg.seal(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := seg.Put(rows[0]); ok {
+		t.Error("запечатанный сегмент принял строку")
+	}
+	for i, want := range rows {
+		got, ok, err := seg.next()
+		if !ok || err != nil {
+			t.Fatalf("строка %d: next = %v, %v", i, ok, err)
+		}
+		// время сравнивается через Equal: зона восстанавливается как фиксированное смещение
+		for j, v := range got.Cells {
+			if tm, ok := v.(time.Time); ok && tm.Equal(moment) {
+				got.Cells[j] = moment
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("строка %d = %#v\nожидается %#v", i, got, want)
+		}
+	}
+	if _, ok, err := seg.next(); ok || err != nil {
+		t.Errorf("после последней строки next = %v, %v", ok, err)
+	}
+}
+
+func TestSpillUnsupportedValue(t *testing.T) {
+	if _, err := appendValue(nil, struct{}{}); err == nil {
+		t.Error("ожидается ошибка для неподдерживаемого типа")
+	}
+}
@@ -4,13 +4,17 @@ package merger
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ryabkov82/xlsx-merger/internal/config"
 	"github.com/xuri/excelize/v2"
@@ -18,21 +22,33 @@ import (
 
 // RowPayload содержит данные строки для обработки
 // FileIndex - индекс исходного файла
+// Sheet - имя исходного листа
 // Cells - значения ячеек строки
 // Height - высота строки
+// SourceRow - номер строки в исходном листе
+// Reject - причина отклонения строки при проверке (пусто для корректных строк)
 type RowPayload struct {
 	FileIndex int
+	Sheet     string
 	Cells     []interface{}
 	Height    float64
+	SourceRow int
+	Reject    string
 	//Done      bool
 }
 
 // FileJob описывает задачу обработки файла
 // Index - порядковый индекс файла
 // Path - путь к файлу
+// RelPath - путь относительно входной папки
+// Size - размер файла в байтах
+// ModTime - время изменения файла
 type FileJob struct {
-	Index int
-	Path  string
+	Index   int
+	Path    string
+	RelPath string
+	Size    int64
+	ModTime time.Time
 }
 
 // StreamMerger реализует потоковое слияние XLSX файлов
@@ -44,22 +60,73 @@ type StreamMerger struct {
 	BaseMerger // Встраиваем базовый функционал
 
 	// Стили и форматирование
-	RowStyles    []int               // Стили для строк данных
-	HeaderStyles []int               // Стили для заголовков
-	ValueTypes   []excelize.CellType // Типы данных для каждой колонки
-	StyleCache   map[string]int      // Кеш стилей для числовых форматов
+	RowStyles     []int               // Стили для строк данных
+	HeaderStyles  []int               // Стили для заголовков
+	ValueTypes    []excelize.CellType // Типы данных для каждой колонки
+	FormatClasses []NumFmtClass       // Классы числовых форматов колонок шаблона
+	StyleCache    map[string]int      // Кеш стилей для числовых форматов
 
 	// Конфигурация и состояние
-	UseTemplate  bool                   // Флаг использования шаблона
-	Cfg          *config.Config         // Конфигурация слияния
-	StreamWriter *excelize.StreamWriter // Потоковый писатель Excel
-	RowCounter   int64                  // Счетчик строк в текущем файле
-	HeightHeader float64                // Высота строки заголовка
-	Sheet        string                 // Имя листа для результатов
-	OutFile      *excelize.File         // Текущий выходной файл
-	PartCounter  int                    // Счетчик частей результата
-	OutputFiles  []string               // Пути к созданным файлам
-	RowCount     int64                  // Общее количество обработанных строк
+	UseTemplate       bool           // Флаг использования шаблона
+	Cfg               *config.Config // Конфигурация слияния
+	HeightHeader      float64        // Высота строки заголовка
+	TemplateSheet     string         // Лист шаблона, из которого взяты заголовки и стили
+	PartCounter       int            // Счетчик частей результата
+	OutputFiles       []string       // Пути к созданным файлам
+	InputFiles        []string       // Входные файлы в порядке обработки
+	RowCount          int64          // Общее количество обработанных строк
+	RejectedRows      int64          // Количество строк, отклоненных при проверке
+	SpilledRows       int64          // Количество строк, вытесненных во временные сегменты на диске
+	DuplicatesRemoved int64          // Количество удаленных повторяющихся строк
+	SummaryFile       string         // Отдельная книга итогов (если создана)
+	DataColumns       int            // Количество колонок данных (без вычисляемых колонок)
+	Date1904          bool           // Шаблон использует систему дат 1904
+
+	// CreateFile создает файл результата (части и файл отклоненных строк) по имени.
+	// По умолчанию - файл на диске; если задан, старые части на диске не удаляются.
+	CreateFile func(name string) (io.WriteCloser, error)
+	// Progress получает события хода слияния. Вызовы последовательны,
+	// но выполняются в горутинах чтения и записи, поэтому обработчик должен быть быстрым.
+	Progress func(ProgressEvent)
+
+	mu             sync.Mutex             // Защищает данные, собираемые воркерами чтения
+	unknownColumns map[int]UnknownColumns // Несопоставленные колонки по индексу файла
+	sheetPattern   *regexp.Regexp         // Регулярное выражение выбора листов
+	output         outputWriter           // Писатель результата в выбранном формате
+	partFile       io.WriteCloser         // Файл текущей части результата
+	partName       string                 // Имя файла текущей части
+	held           []*heldPart            // Части, сохранение которых отложено до записи листов Manifest и итогов
+	rules          []columnRule           // Правила проверки колонок
+	rejects        *rejectsWriter         // Писатель отклоненных строк
+	dedup          *deduper               // Удаление повторяющихся строк (nil - выключено)
+	sorter         *sorter                // Сортировка результата (nil - выключена)
+	summary        *summary               // Итоги по группам (nil - выключены)
+	filter         filterExpr             // Фильтр строк (nil - без фильтра)
+	filteredRows   map[int]int64          // Строки, отброшенные фильтром, по индексу файла
+	inputStats     []InputFileStats       // Итоги по входным файлам в порядке обработки
+	derived        []derivedColumn        // Вычисляемые колонки
+	dataPos        []int                  // Позиции колонок данных в результате (nil - подряд с начала)
+	cancelled      bool                   // Слияние прервано отменой контекста
+	progress       progressState          // Счетчики хода слияния
+}
+
+// MergeResult содержит итоги слияния
+type MergeResult struct {
+	OutputFiles       []string         // Пути к созданным файлам
+	InputFiles        []string         // Обработанные входные файлы (относительно входной папки)
+	RowCount          int64            // Общее количество записанных строк
+	RejectedRows      int64            // Количество отклоненных строк
+	SpilledRows       int64            // Количество строк, вытесненных во временные сегменты на диске
+	DuplicatesRemoved int64            // Количество удаленных повторяющихся строк
+	FilteredRows      int64            // Количество строк, отброшенных фильтром
+	Filtered          []FilteredRows   // Строки, отброшенные фильтром, по файлам
+	RejectsFile       string           // Файл с отклоненными строками (если были)
+	SummaryFile       string           // Отдельная книга итогов (если создана)
+	UnknownColumns    []UnknownColumns // Колонки исходных файлов, отсутствующие в шаблоне
+	Files             []InputFileStats // Итоги по входным файлам в порядке обработки
+	FailedFiles       []FailedFile     // Файлы, пропущенные из-за ошибок чтения (-on-error=skip)
+	Partial           bool             // Результат сохранен, файлы FailedFiles пропущены
+	Cancelled         bool             // Слияние прервано отменой контекста, записанные файлы удалены
 }
 
 // NewStreamMerger создает новый экземпляр StreamMerger
@@ -70,139 +137,199 @@ func NewStreamMerger() FileMerger {
 	return sm
 }
 
-// newOutput создает новый выходной файл на основе шаблона
-// Закрывает предыдущий файл если он был открыт
-// Возвращает ошибку если:
-// - не удалось создать файл
-// - шаблон не содержит листов
-// - не удалось создать StreamWriter
+// newOutput начинает новую часть результата
+// Завершает предыдущую часть, если она была начата
+// Возвращает ошибку если не удалось создать файл части
+// или писатель не смог начать запись (например, шаблон не открывается)
 func (sm *StreamMerger) newOutput() error {
-	// Завершение текущего файла
-	if sm.OutFile != nil {
-		if err := sm.StreamWriter.Flush(); err != nil {
-			return fmt.Errorf("ошибка финального flush: %v", err)
+	// Завершение текущего файла; части с листами Manifest и итогов сохраняются в конце слияния
+	if sm.partFile != nil {
+		save := sm.saveOutput
+		if sm.holdPart() {
+			save = sm.holdOutput
 		}
-		fileName := fmt.Sprintf("%s_part%d.xlsx", strings.TrimSuffix(sm.Cfg.OutputPath, ".xlsx"), sm.PartCounter)
-		if err := sm.OutFile.SaveAs(fileName); err != nil {
-			return fmt.Errorf("ошибка сохранения файла: %v", err)
+		if err := save(); err != nil {
+			return err
 		}
-		_ = sm.OutFile.Close()
-		sm.OutputFiles = append(sm.OutputFiles, fileName)
 		sm.PartCounter++
 	}
 
-	// Создание нового файла на основе шаблона
-	var err error
-	sm.OutFile, err = excelize.OpenFile(sm.Cfg.TemplatePath)
+	fileName := sm.partFileName()
+	f, err := sm.createFile(fileName)
 	if err != nil {
-		return fmt.Errorf("ошибка открытия шаблона: %v", err)
+		return fmt.Errorf("ошибка создания файла %s: %v", fileName, err)
 	}
-	// Проверка наличия листов в шаблоне
-	sheetList := sm.OutFile.GetSheetList()
-	if len(sheetList) == 0 {
-		return fmt.Errorf("шаблон пустой, нет листов")
+	sm.partFile, sm.partName = f, fileName
+	sm.progress.part.Store(int64(sm.PartCounter))
+
+	if err := sm.output.NewPart(f); err != nil {
+		return err
 	}
-	// Настройка нового листа для результатов
-	sm.Sheet = "merged"
-	sm.OutFile.NewSheet(sm.Sheet)
+	sm.emit(ProgressEvent{Type: EventPartStarted, PartFile: fileName})
+	return nil
+}
 
-	// Копируем ширину колонок из первого листа шаблона
-	for colIdx := 1; colIdx <= len(sm.Headers); colIdx++ {
-		colName, _ := excelize.ColumnNumberToName(colIdx)
-		width, err := sm.OutFile.GetColWidth(sheetList[0], colName)
-		if err == nil {
-			sm.OutFile.SetColWidth(sm.Sheet, colName, colName, width)
-		}
+// saveOutput завершает запись текущей части и закрывает ее файл
+func (sm *StreamMerger) saveOutput() error {
+	f := sm.partFile
+	sm.partFile = nil
+	if err := sm.output.ClosePart(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("ошибка сохранения файла: %w", err)
 	}
+	sm.OutputFiles = append(sm.OutputFiles, sm.partName)
+	sm.emit(ProgressEvent{Type: EventPartSaved, PartFile: sm.partName})
+	return nil
+}
 
-	// Инициализация потокового писателя
-	sm.StreamWriter, err = sm.OutFile.NewStreamWriter(sm.Sheet)
-	if err != nil {
-		return fmt.Errorf("ошибка создания StreamWriter: %v", err)
+// createFile создает файл результата через CreateFile или на диске
+func (sm *StreamMerger) createFile(name string) (io.WriteCloser, error) {
+	if sm.CreateFile != nil {
+		return sm.CreateFile(name)
 	}
+	return os.Create(name)
+}
 
-	sm.OutFile.DeleteSheet(sheetList[0])
-	sm.RowCounter = 0
+// processInputFile читает файл, готовит rowData и складывает строки в буфер
+func (sm *StreamMerger) processInputFile(ctx context.Context, job FileJob, buffer *rowBuffer) (err error) {
 
-	// Запись заголовков если требуется
-	if sm.Cfg.HasHeaders && len(sm.Headers) > 0 {
-		headerRow := make([]interface{}, len(sm.Headers))
-		for i, h := range sm.Headers {
-			headerRow[i] = excelize.Cell{
-				Value:   h,
-				StyleID: sm.HeaderStyles[i],
+	defer buffer.Close(job.Index)
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			sm.fileFailed(ctx, job, err, start)
+			if buffer.staged {
+				// пропущенный файл не попадает в результат и частично
+				buffer.Discard(job.Index)
 			}
 		}
+	}()
 
-		cell := fmt.Sprintf("A%d", sm.RowCounter+1)
-		if err := sm.StreamWriter.SetRow(cell, headerRow, excelize.RowOpts{Height: sm.HeightHeader}); err != nil {
-			return fmt.Errorf("ошибка записи заголовка: %v", err)
-		}
-		sm.RowCounter++
+	fileIndex, path := job.Index, job.Path
+	read := sm.fileStarted(job)
+
+	src, err := openSource(path, sm.Cfg)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if csv, ok := src.(*csvSource); ok {
+		csv.read = read
+	} else {
+		read.Store(job.Size)
+	}
 
+	var unknown []string
+	var stats fileStats
+	derived := sm.fileDerived(job)
+	sheets := sm.sourceSheets(src)
+	for _, sheetSrc := range sheets {
+		sheetUnknown, err := sm.processSheet(ctx, src, job, sheetSrc, derived, buffer, &stats)
+		if err != nil {
+			return err
+		}
+		unknown = appendUnique(unknown, sheetUnknown...)
 	}
+	sm.reportUnknownColumns(fileIndex, job.RelPath, unknown)
+	sm.reportFiltered(fileIndex, job.RelPath, stats.Filtered)
+	sm.fileRead(job, sheets, stats, start)
+	sm.fileFinished(job, stats.Rows)
 
 	return nil
 }
 
-// processInputFile читает файл, готовит rowData и отправляет в канал
-func (sm *StreamMerger) processInputFile(ctx context.Context, fileIndex int, path string, rowChan chan<- RowPayload) error {
+// fileStats - счетчики строк входного файла
+type fileStats struct {
+	Rows     int64 // прочитано строк данных
+	Filtered int64 // отброшено фильтром
+}
 
-	defer close(rowChan)
+// processSheet читает строки одного листа исходного файла и складывает их в буфер,
+// добавляя счетчики строк в stats. derived - значения вычисляемых колонок, общие для файла.
+// Возвращает заголовки листа, не сопоставленные со схемой.
+func (sm *StreamMerger) processSheet(ctx context.Context, src sourceReader, job FileJob, sheetSrc string, derived []interface{}, buffer *rowBuffer, stats *fileStats) ([]string, error) {
 
-	f, err := excelize.OpenFile(path)
-	if err != nil {
-		return fmt.Errorf("ошибка открытия файла %s: %v", path, err)
-	}
-	defer f.Close()
-
-	sheetListSrc := f.GetSheetList()
-	if len(sheetListSrc) == 0 {
-		return nil
-	}
-	sheetSrc := sheetListSrc[0]
+	fileIndex, path := job.Index, job.Path
+	date1904 := src.Date1904()
 
-	rows, err := f.Rows(sheetSrc)
+	rows, err := src.Rows(sheetSrc)
 	if err != nil {
-		return fmt.Errorf("ошибка чтения строк из %s: %v", path, err)
+		return nil, fmt.Errorf("ошибка чтения строк из %s: %v", path, err)
 	}
+	defer rows.Close()
 
 	rowInFile := 1
+	var mapping []int
+	var unknown []string
 	if sm.Cfg.HasHeaders {
 		rows.Next()
 		rowInFile++
+		if sm.Cfg.AlignHeaders {
+			headers, err := rows.Columns()
+			if err != nil {
+				return nil, fmt.Errorf("ошибка чтения заголовка из %s: %v", path, err)
+			}
+			mapping, unknown = sm.mapHeaders(headers)
+		}
 	}
 
 	for rows.Next() {
 
 		stringRow, err := rows.Columns()
 		if err != nil {
-			return fmt.Errorf("ошибка чтения строки: %v", err)
+			return nil, fmt.Errorf("ошибка чтения строки: %v", err)
 		}
 
-		rowData := make([]interface{}, len(stringRow))
-		for i, cellVal := range stringRow {
+		var rowData []interface{}
+		switch {
+		case mapping != nil:
+			rowData = make([]interface{}, len(sm.Headers))
+		case sm.dataPos != nil:
+			// место под вычисляемые колонки нужно и в коротких строках
+			rowData = make([]interface{}, len(sm.Headers)+max(0, len(stringRow)-sm.DataColumns))
+		default:
+			rowData = make([]interface{}, len(stringRow))
+		}
+		// исходные значения по позициям схемы для проверки и фильтра строки
+		var raw []string
+		if sm.validating() || sm.filter != nil {
+			raw = make([]string, len(rowData))
+		}
+		for col, cellVal := range stringRow {
+			// i - позиция колонки в выходном файле
+			i := sm.dataPosition(col)
+			if mapping != nil {
+				if col >= len(mapping) || mapping[col] < 0 {
+					continue
+				}
+				i = mapping[col]
+			}
+
 			styleID := 0
 			if i < len(sm.RowStyles) {
 				styleID = sm.RowStyles[i]
 			}
 
-			colName, _ := excelize.ColumnNumberToName(i + 1)
-			cellRef := fmt.Sprintf("%s%d", colName, rowInFile)
-
 			var valType excelize.CellType
 			if sm.UseTemplate && i < len(sm.ValueTypes) {
 				valType = sm.ValueTypes[i]
 			} else {
-				valType, _ = f.GetCellType(sheetSrc, cellRef)
+				valType = src.CellType(sheetSrc, col+1, rowInFile)
 			}
 
 			var value interface{}
 			switch valType {
 			case excelize.CellTypeBool:
-				value = (cellVal == "1" || strings.ToLower(cellVal) == "true")
+				if b, ok := parseBool(cellVal); ok {
+					value = b
+				} else {
+					value = cellVal
+				}
 			case excelize.CellTypeNumber:
-				if n, err := strconv.ParseFloat(cellVal, 64); err == nil {
+				if n, ok := parseNumber(cellVal); ok {
 					value = n
 					if !sm.UseTemplate {
 						decimals := 0
@@ -220,7 +347,7 @@ func (sm *StreamMerger) processInputFile(ctx context.Context, fileIndex int, pat
 					value = cellVal
 				}
 			case excelize.CellTypeDate:
-				value = cellVal
+				value = sm.convertDate(cellVal, date1904, styleID, sm.formatClass(i))
 			default:
 				value = cellVal
 			}
@@ -229,28 +356,46 @@ func (sm *StreamMerger) processInputFile(ctx context.Context, fileIndex int, pat
 				Value:   value,
 				StyleID: styleID,
 			}
+			if raw != nil {
+				raw[i] = cellVal
+			}
 		}
 
-		if sm.Cfg.AddSourceFile {
-			rowData = append(rowData, filepath.Base(path))
-		}
+		sm.fillDerived(rowData, raw, derived, sheetSrc, rowInFile)
 
-		//height, _ := f.GetRowHeight(sheetSrc, rowInFile)
-		height := rows.GetRowOpts().Height
+		stats.Rows++
+		if sm.filtered(rowData, raw) {
+			stats.Filtered++
+			rowInFile++
+			continue
+		}
 
-		if ctx.Err() != nil {
-			return ctx.Err()
+		var reject string
+		if sm.validating() {
+			reject = sm.validateRow(raw, rowData)
 		}
-		rowChan <- RowPayload{
+
+		height := rows.Height()
+
+		err = buffer.Put(ctx, RowPayload{
 			FileIndex: fileIndex,
+			Sheet:     sheetSrc,
 			Cells:     rowData,
 			Height:    height,
+			SourceRow: rowInFile,
+			Reject:    reject,
+		})
+		if err != nil {
+			return nil, err
 		}
 
 		rowInFile++
 	}
+	if err := rows.Error(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения строк из %s: %v", path, err)
+	}
 
-	return nil
+	return unknown, nil
 }
 
 // prepareTemplate загружает и анализирует шаблон для:
@@ -272,7 +417,16 @@ func (sm *StreamMerger) prepareTemplate() error {
 	if len(sheetList) == 0 {
 		return fmt.Errorf("шаблон пустой, нет листов")
 	}
+	// Выходные файлы создаются из шаблона и наследуют его систему дат
+	if props, err := fTemplate.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		sm.Date1904 = *props.Date1904
+	}
+	// Заголовки и стили берутся с первого подходящего листа шаблона
 	sheet := sheetList[0]
+	if selected := sm.selectSheets(sheetList); len(selected) > 0 {
+		sheet = selected[0]
+	}
+	sm.TemplateSheet = sheet
 
 	rows, err := fTemplate.Rows(sheet)
 	if err != nil {
@@ -282,15 +436,8 @@ func (sm *StreamMerger) prepareTemplate() error {
 	// Получение заголовков
 	if len(sm.Headers) == 0 && rows.Next() {
 		headers, _ := rows.Columns()
-		if sm.Cfg.AddSourceFile {
-			if sm.Cfg.HasHeaders {
-				sm.Headers = append(headers, "SourceFile")
-			} else {
-				sm.Headers = append(headers, "")
-			}
-		} else {
-			sm.Headers = headers
-		}
+		sm.DataColumns = len(headers)
+		sm.Headers = headers
 		sm.HeightHeader = rows.GetRowOpts().Height
 	}
 
@@ -299,6 +446,7 @@ func (sm *StreamMerger) prepareTemplate() error {
 	sm.RowStyles = make([]int, len(sm.Headers))
 	// Определение типов данных
 	sm.ValueTypes = make([]excelize.CellType, len(sm.Headers))
+	sm.FormatClasses = make([]NumFmtClass, len(sm.Headers))
 
 	for col := 1; col <= len(sm.Headers); col++ {
 		cell1, _ := excelize.CoordinatesToCellName(col, 1)
@@ -309,22 +457,16 @@ func (sm *StreamMerger) prepareTemplate() error {
 		styleID2, _ := fTemplate.GetCellStyle(sheet, cell2)
 		sm.RowStyles[col-1] = styleID2
 
+		// Тип значений колонки определяется по формату ячейки шаблона,
+		// для общего формата - по типу самой ячейки
+		style, _ := fTemplate.GetStyle(styleID2)
+		class := classifyStyle(style)
 		t, err := fTemplate.GetCellType(sheet, cell2)
 		if err != nil {
 			t = excelize.CellTypeInlineString
-		} else if t == excelize.CellTypeUnset {
-			styleID, _ := fTemplate.GetCellStyle(sheet, cell2)
-			style, _ := fTemplate.GetStyle(styleID)
-			switch {
-			case isDateFormat(style.NumFmt):
-				t = excelize.CellTypeDate
-			case isNumericFormat(style.NumFmt):
-				t = excelize.CellTypeNumber
-			default:
-				t = excelize.CellTypeInlineString
-			}
 		}
-		sm.ValueTypes[col-1] = t
+		sm.FormatClasses[col-1] = class
+		sm.ValueTypes[col-1] = valueTypeForClass(class, t)
 	}
 
 	// Кеш стилей, если не передан TemplatePath
@@ -368,114 +510,298 @@ func (sm *StreamMerger) prepareTemplate() error {
 	return nil
 }
 
-// writerLoop читает из канала и пишет данные в streamWriter, переключая файлы по maxRow
-func (sm *StreamMerger) writerLoop(ctx context.Context, cancel context.CancelFunc, rowChans []<-chan RowPayload, doneChan chan<- error) {
+// writerLoop забирает строки из буфера и пишет их писателем результата,
+// переключая файлы по maxRow. Порядок строк задает режим слияния (-merge-mode):
+// файлы по порядку, файлы целиком по мере готовности или строки любых файлов вперемешку.
+func (sm *StreamMerger) writerLoop(ctx context.Context, cancel context.CancelFunc, buffer *rowBuffer, files int, doneChan chan<- error) {
 
-	for expected := 0; expected < len(rowChans); expected++ {
-		ch := rowChans[expected]
-		for {
-			select {
-			case <-ctx.Done():
-				doneChan <- ctx.Err()
-				return
-			case payload, ok := <-ch:
-				if !ok {
-					// канал закрыт, переходим к следующему
-					ch = nil
-				} else {
-					if sm.Cfg.MaxRowPerFile > 0 && sm.RowCounter >= sm.Cfg.MaxRowPerFile {
-						if err := sm.newOutput(); err != nil {
-							cancel() // посылаем сигнал записывающим горутинам
-							doneChan <- fmt.Errorf("ошибка создания нового файла: %w", err)
-							return
-						}
-					}
-					cell := fmt.Sprintf("A%d", sm.RowCounter+1)
-					if err := sm.StreamWriter.SetRow(cell, payload.Cells, excelize.RowOpts{Height: payload.Height}); err != nil {
-						cancel()
-						doneChan <- fmt.Errorf("ошибка записи строки: %w", err)
-						return
-					}
-					sm.RowCounter++
-					sm.RowCount++
-				}
-			}
-			if ch == nil {
-				break
-			}
-		}
+	var err error
+	if sm.Cfg.MergeMode == config.MergeInterleaved {
+		err = sm.writeInterleaved(ctx, buffer)
+	} else {
+		err = sm.writeFiles(ctx, buffer, files)
+	}
+	if err == nil && sm.dedup != nil {
+		err = sm.dedup.Finish(ctx, sm.sortRow)
+	}
+	if err == nil && sm.sorter != nil {
+		// отсортированные строки проходят обычное деление на части
+		err = sm.sorter.Finish(ctx, sm.writeRow)
+	}
+	if err == nil {
+		// результат без единого прочитанного файла не сохраняется
+		err = sm.checkFilesRead()
+	}
+	if err != nil {
+		cancel() // посылаем сигнал читающим горутинам
+		doneChan <- err
+		return
 	}
 
-	if err := sm.StreamWriter.Flush(); err != nil {
+	if err := sm.saveOutput(); err != nil {
+		cancel()
+		doneChan <- err
+		return
+	}
+	if err := sm.saveHeld(); err != nil {
 		cancel()
-		doneChan <- fmt.Errorf("ошибка финального flush: %w", err)
+		doneChan <- err
 		return
 	}
-	fileName := fmt.Sprintf("%s_part%d.xlsx", strings.TrimSuffix(sm.Cfg.OutputPath, ".xlsx"), sm.PartCounter)
-	if err := sm.OutFile.SaveAs(fileName); err != nil {
+	if err := sm.rejects.Close(); err != nil {
 		cancel()
-		doneChan <- fmt.Errorf("ошибка сохранения файла: %w", err)
+		doneChan <- err
 		return
 	}
-	_ = sm.OutFile.Close()
-	sm.OutputFiles = append(sm.OutputFiles, fileName)
+	if sm.summary != nil && sm.summary.path != "" {
+		if err := sm.summary.save(); err != nil {
+			cancel()
+			doneChan <- err
+			return
+		}
+		sm.SummaryFile = sm.summary.path
+	}
+	sm.emit(ProgressEvent{Type: EventFinished})
 	doneChan <- nil
 }
 
+// writeFiles пишет строки файлов целиком, файл за файлом: по порядку
+// или, в режиме MergeContiguous, в порядке готовности
+func (sm *StreamMerger) writeFiles(ctx context.Context, buffer *rowBuffer, files int) error {
+	for n := 0; n < files; n++ {
+		index := n
+		if sm.Cfg.MergeMode == config.MergeContiguous {
+			var err error
+			if index, err = buffer.Pick(ctx); err != nil {
+				return err
+			}
+		}
+		for {
+			payload, ok, err := buffer.Next(ctx, index)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// файл прочитан, переходим к следующему
+				break
+			}
+			if err := sm.writePayload(payload); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeInterleaved пишет строки всех файлов по мере поступления
+func (sm *StreamMerger) writeInterleaved(ctx context.Context, buffer *rowBuffer) error {
+	for {
+		payload, ok, err := buffer.NextAny(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := sm.writePayload(payload); err != nil {
+			return err
+		}
+	}
+}
+
+// writePayload пишет строку в результат (через удаление повторов и сортировку,
+// если они включены) или в файл отклоненных строк
+func (sm *StreamMerger) writePayload(payload RowPayload) error {
+	if payload.Reject != "" {
+		// отклоненные строки не попадают в результат и не учитываются при делении на части
+		if err := sm.rejects.Write(sm.InputFiles[payload.FileIndex], payload.Sheet, payload.SourceRow, payload.Reject, payload.Cells); err != nil {
+			return fmt.Errorf("ошибка записи отклоненной строки: %w", err)
+		}
+		sm.RejectedRows++
+		sm.inputStats[payload.FileIndex].Rejected++
+		sm.rowWritten()
+		return nil
+	}
+	if sm.dedup != nil {
+		return sm.dedup.Write(payload, sm.sortRow)
+	}
+	return sm.sortRow(payload)
+}
+
+// writeRow пишет строку в результат, начиная новую часть при достижении MaxRowPerFile
+func (sm *StreamMerger) writeRow(payload RowPayload) error {
+	rows, err := sm.output.RowCount(payload.Sheet)
+	if err == nil && sm.Cfg.MaxRowPerFile > 0 && rows >= sm.Cfg.MaxRowPerFile {
+		err = sm.newOutput()
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка создания нового файла: %w", err)
+	}
+	if err := sm.output.WriteRow(payload.Sheet, payload.Cells, payload.Height); err != nil {
+		return fmt.Errorf("ошибка записи строки: %w", err)
+	}
+	if sm.summary != nil {
+		sm.summary.Add(payload)
+	}
+	sm.inputStats[payload.FileIndex].RowsWritten++
+	sm.RowCount++
+	sm.rowWritten()
+	return nil
+}
+
 // MergeFiles выполняет слияние файлов согласно конфигурации
 // Потоково обрабатывает входные файлы с использованием worker-горутин
 // Разделяет результат на части при превышении MaxRowPerFile
 // Возвращает:
-// - список созданных файлов
-// - общее количество обработанных строк
+// - итоги слияния (созданные файлы, количество строк, несопоставленные колонки)
 // - ошибку если таковая возникла
-func (sm *StreamMerger) MergeFiles(cfg *config.Config) ([]string, int64, error) {
+// Отмена ctx прерывает слияние: записанные части и файл отклоненных строк удаляются,
+// в итогах устанавливается Cancelled, возвращается ошибка контекста.
+func (sm *StreamMerger) MergeFiles(ctx context.Context, cfg *config.Config) (*MergeResult, error) {
 
 	sm.Cfg = cfg
 	sm.PartCounter = 1
+	sm.unknownColumns = make(map[int]UnknownColumns)
+	sm.filteredRows = make(map[int]int64)
+
+	if cfg.SheetRegex != "" {
+		var err error
+		if sm.sheetPattern, err = regexp.Compile(cfg.SheetRegex); err != nil {
+			return sm.result(), config.Invalid(fmt.Errorf("некорректное регулярное выражение листа: %v", err))
+		}
+	}
 
 	// Удаляем старые файлы перед началом
-	if err := removeExistingPartFiles(cfg); err != nil {
-		return nil, sm.RowCount, err
+	if sm.CreateFile == nil {
+		if err := removeExistingPartFiles(cfg); err != nil {
+			return sm.result(), withKind(ErrWrite, err)
+		}
 	}
 
 	// получаем список входящих файлов и путь к файлу шаблона
 	inputFiles, templatePath, err := getInputFilesAndTemplatePath(cfg)
 	if err != nil {
-		return nil, sm.RowCount, err
+		return sm.result(), err
+	}
+
+	// Для шаблона не в формате .xlsx создается временный XLSX
+	templatePath, cleanup, err := sm.templateFile(templatePath)
+	if err != nil {
+		return sm.result(), withKind(ErrTemplate, err)
 	}
+	defer cleanup()
 
 	sm.Cfg.TemplatePath = templatePath
+	sm.inputStats = nil
+	for _, file := range inputFiles {
+		sm.InputFiles = append(sm.InputFiles, file.RelPath)
+		sm.inputStats = append(sm.inputStats, InputFileStats{File: file.RelPath, Size: file.Size})
+	}
 
 	sm.UseTemplate = cfg.TemplatePath != ""
 
+	if err := ctx.Err(); err != nil {
+		return sm.cancel(err)
+	}
+
 	// подготовки заголовков, стилей и типов данных из шаблона
 	if err := sm.prepareTemplate(); err != nil {
-		return nil, sm.RowCount, err
+		return sm.result(), withKind(ErrTemplate, err)
+	}
+
+	// расширение схемы колонками, которых нет в шаблоне
+	if cfg.AlignHeaders && cfg.UnknownColumns == config.UnknownColumnsAppend {
+		if err := sm.extendSchema(inputFiles); err != nil {
+			return sm.result(), withKind(ErrRead, err)
+		}
+	}
+
+	// вычисляемые колонки
+	if err := sm.addDerivedColumns(); err != nil {
+		return sm.result(), config.Invalid(err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return sm.cancel(err)
 	}
 
-	// инициализация StreamWriter
+	// правила проверки строк
+	if err := sm.compileRules(); err != nil {
+		return sm.result(), config.Invalid(err)
+	}
+	if err := sm.compileFilter(); err != nil {
+		return sm.result(), config.Invalid(err)
+	}
+	sm.dedup = nil
+	if cfg.Dedup {
+		if sm.dedup, err = sm.newDeduper(); err != nil {
+			return sm.result(), config.Invalid(err)
+		}
+		defer sm.dedup.Close()
+	}
+	sm.sorter = nil
+	if len(cfg.Sort) > 0 {
+		if sm.sorter, err = sm.newSorter(); err != nil {
+			return sm.result(), config.Invalid(err)
+		}
+		defer sm.sorter.Close()
+	}
+	sm.summary = nil
+	if cfg.Summary() {
+		if sm.summary, err = sm.newSummary(); err != nil {
+			return sm.result(), config.Invalid(err)
+		}
+		if sm.summary.path != "" && sm.CreateFile == nil {
+			if err := os.Remove(sm.summary.path); err != nil && !os.IsNotExist(err) {
+				return sm.result(), withKind(ErrWrite, fmt.Errorf("ошибка удаления файла %s: %v", sm.summary.path, err))
+			}
+		}
+	}
+	sm.rejects = &rejectsWriter{sm: sm, path: rejectsPath(sm)}
+	if sm.CreateFile == nil {
+		if err := os.Remove(sm.rejects.path); err != nil && !os.IsNotExist(err) {
+			return sm.result(), withKind(ErrWrite, fmt.Errorf("ошибка удаления файла %s: %v", sm.rejects.path, err))
+		}
+	}
+
+	// инициализация писателя результата
+	if sm.output, err = newOutputWriter(sm); err != nil {
+		return sm.result(), config.Invalid(err)
+	}
+	sm.startProgress(inputFiles)
 	if err := sm.newOutput(); err != nil {
-		return nil, sm.RowCount, err
+		return sm.result(), withKind(ErrWrite, err)
 	}
 
-	workerCount := 4
+	workerCount := cfg.Workers
+	if workerCount <= 0 {
+		workerCount = runtime.NumCPU()
+	}
+	workerCount = min(workerCount, len(inputFiles))
 
-	ctx, cancel := context.WithCancel(context.Background())
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
 	defer cancel() // гарантирует освобождение ресурсов
 
-	// Создаем отдельный канал для каждого файла
-	rowChans := make([]chan RowPayload, len(inputFiles))
-	for i := range rowChans {
-		rowChans[i] = make(chan RowPayload, 20000) // буфер на файл, можно менять
-	}
+	// общий буфер строк всех файлов: читатели, опередившие писателя, ждут свободного места
+	// или вытесняют строки во временные сегменты на диске
+	buffer := newRowBuffer(ctx, len(inputFiles), cfg.BufferRows, int64(cfg.BufferMB)<<20)
+	// в режиме interleaved писатель забирает строки любых файлов, вытеснение не нужно
+	buffer.spill = cfg.Spill && cfg.MergeMode != config.MergeInterleaved
+	buffer.spillDir = cfg.SpillDir
+	// при пропуске файлов с ошибками строки файла записываются только после его успешного чтения
+	buffer.staged = cfg.OnError == config.OnErrorSkip
+	defer buffer.Cleanup()
 
 	done := make(chan error)
 
-	go sm.writerLoop(ctx, cancel, toReadOnlyChans(rowChans), done)
+	go sm.writerLoop(ctx, cancel, buffer, len(inputFiles), done)
 
-	// воркеры чтения
+	// воркеры чтения; сохраняется первая ошибка чтения,
+	// ошибки отмены из-за сбоя в другой горутине не учитываются
 	var wg sync.WaitGroup
+	var readErr error
+	var readErrOnce sync.Once
 	fileCh := make(chan FileJob, workerCount)
 
 	wg.Add(workerCount)
@@ -489,14 +815,17 @@ func (sm *StreamMerger) MergeFiles(cfg *config.Config) ([]string, int64, error)
 					return
 				}
 
-				if err := sm.processInputFile(ctx, job.Index, job.Path, rowChans[job.Index]); err != nil {
+				if err := sm.processInputFile(ctx, job, buffer); err != nil {
+					if ctx.Err() == nil && cfg.OnError == config.OnErrorSkip {
+						// файл пропускается (ошибка учтена в итогах файла),
+						// его очередь в буфере закрыта, запись переходит к следующему
+						continue
+					}
+					if ctx.Err() == nil || !errors.Is(err, ctx.Err()) {
+						readErrOnce.Do(func() { readErr = withKind(ErrRead, err) })
+					}
 					// Отменяем контекст, чтобы остальные остановились
 					cancel()
-					// Ошибка может быть записана только один раз в канал done:
-					select {
-					case done <- err:
-					default:
-					}
 					return
 				}
 			}
@@ -505,33 +834,128 @@ func (sm *StreamMerger) MergeFiles(cfg *config.Config) ([]string, int64, error)
 
 	// Отправка путей
 	go func() {
-		for i, path := range inputFiles {
-			fileCh <- FileJob{Index: i, Path: path}
+		defer close(fileCh)
+		for i, file := range inputFiles {
+			select {
+			case <-ctx.Done():
+				return
+			case fileCh <- FileJob{Index: i, Path: file.Path, RelPath: file.RelPath, Size: file.Size, ModTime: file.ModTime}:
+			}
 		}
-		close(fileCh)
 	}()
 
 	wg.Wait()
 
 	err = <-done
 	close(done)
+	sm.SpilledRows = buffer.Spilled()
+
+	if readErr != nil {
+		// ошибка чтения первична: писатель в этом случае сообщает об отмене
+		err = readErr
+	}
+	if err != nil && parent.Err() != nil {
+		return sm.cancel(parent.Err())
+	}
 
-	return sm.OutputFiles, sm.RowCount, err
+	if errors.Is(err, errNoFilesRead) {
+		sm.discard()
+	}
+	if readErr == nil {
+		// ошибки писателя - ошибки записи результата, кроме отсутствия прочитанных файлов
+		err = withKind(ErrWrite, err)
+	}
+
+	res := sm.result()
+	res.Partial = err == nil && len(res.FailedFiles) > 0
+	return res, err
 }
 
-// Вспомогательная функция для преобразования []chan T в []<-chan T
-func toReadOnlyChans(chans []chan RowPayload) []<-chan RowPayload {
-	ro := make([]<-chan RowPayload, len(chans))
-	for i, ch := range chans {
-		ro[i] = ch
+// cancel завершает слияние, прерванное отменой контекста:
+// незавершенная часть отбрасывается, созданные файлы результата удаляются.
+// Если файлы создаются через CreateFile, их удаление остается вызывающей стороне,
+// а их имена остаются в итогах.
+func (sm *StreamMerger) cancel(err error) (*MergeResult, error) {
+	sm.cancelled = true
+	sm.discard()
+	return sm.result(), err
+}
+
+// discard отбрасывает незавершенную часть и удаляет созданные файлы результата
+func (sm *StreamMerger) discard() {
+	if sm.partFile != nil {
+		sm.output.AbortPart()
+		sm.partFile.Close()
+		sm.OutputFiles = append(sm.OutputFiles, sm.partName)
+		sm.partFile = nil
+	}
+	for _, h := range sm.held {
+		h.output.AbortPart()
+		h.file.Close()
+		sm.OutputFiles = append(sm.OutputFiles, h.name)
+	}
+	sm.held = nil
+	if sm.rejects != nil {
+		sm.rejects.Abort()
+	}
+	if sm.CreateFile == nil {
+		for _, name := range sm.OutputFiles {
+			os.Remove(name)
+		}
+		if sm.RejectedRows > 0 {
+			os.Remove(sm.rejects.path)
+		}
+		sm.OutputFiles = nil
+		sm.RejectedRows = 0
+	}
+}
+
+// result собирает итоги слияния
+func (sm *StreamMerger) result() *MergeResult {
+	res := &MergeResult{
+		OutputFiles:       sm.OutputFiles,
+		InputFiles:        sm.InputFiles,
+		RowCount:          sm.RowCount,
+		SpilledRows:       sm.SpilledRows,
+		DuplicatesRemoved: sm.DuplicatesRemoved,
+		SummaryFile:       sm.SummaryFile,
+		Cancelled:         sm.cancelled,
+		Files:             sm.filesResult(),
+	}
+	for _, f := range res.Files {
+		if f.Status == FileError {
+			res.FailedFiles = append(res.FailedFiles, FailedFile{File: f.File, Error: f.Error})
+		}
+	}
+	if sm.RejectedRows > 0 {
+		res.RejectedRows = sm.RejectedRows
+		res.RejectsFile = sm.rejects.path
+	}
+	filtered := make([]int, 0, len(sm.filteredRows))
+	for i := range sm.filteredRows {
+		filtered = append(filtered, i)
+	}
+	sort.Ints(filtered)
+	for _, i := range filtered {
+		res.FilteredRows += sm.filteredRows[i]
+		res.Filtered = append(res.Filtered, FilteredRows{File: sm.InputFiles[i], Rows: sm.filteredRows[i]})
+	}
+	indexes := make([]int, 0, len(sm.unknownColumns))
+	for i := range sm.unknownColumns {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		res.UnknownColumns = append(res.UnknownColumns, sm.unknownColumns[i])
 	}
-	return ro
+	return res
 }
 
 // removeExistingPartFiles удаляет существующие частичные файлы результата
 // Используется для очистки перед новым слиянием
 func removeExistingPartFiles(cfg *config.Config) error {
-	pattern := fmt.Sprintf("%s_part*.xlsx", strings.TrimSuffix(cfg.OutputPath, ".xlsx"))
+	base, ext := outputBase(cfg)
+	pattern := fmt.Sprintf("%s_part*%s", base, ext)
 	files, err := filepath.Glob(pattern)
 	if err != nil {
 		return fmt.Errorf("ошибка поиска файлов по шаблону: %v", err)
@@ -545,82 +969,66 @@ func removeExistingPartFiles(cfg *config.Config) error {
 	return nil
 }
 
-func isDateFormat(fmtID int) bool {
-	switch fmtID {
-	case 14, 15, 16, 17, 22, 27, 30, 36, 45, 46, 47:
-		return true
-	}
-	return false
-}
-
-func isNumericFormat(fmtID int) bool {
-	switch fmtID {
-	case 1, 2, 3, 4, 10, 37, 38, 39, 40:
-		return true
-	}
-	return false
-}
-
 // getInputFilesAndTemplatePath собирает входные файлы и определяет шаблон
 // Возвращает:
-// - список XLSX файлов в директории
+// - список входных файлов в директории (с учетом -recursive и фильтров) в порядке обработки
 // - путь к шаблону (наибольший файл или из конфига)
 // - ошибку если файлы не найдены или шаблон недоступен
-func getInputFilesAndTemplatePath(cfg *config.Config) ([]string, string, error) {
-	type fileWithSize struct {
-		Path string
-		Size int64
-	}
-
+func getInputFilesAndTemplatePath(cfg *config.Config) ([]inputFile, string, error) {
 	var templatePath string
 
-	entries, err := os.ReadDir(cfg.InputDir)
+	files, err := collectInputFiles(cfg)
 	if err != nil {
-		return nil, "", fmt.Errorf("ошибка при чтении директории: %w", err)
-	}
-
-	var files []fileWithSize
-
-	for _, entry := range entries {
-		if entry.IsDir() || filepath.Ext(entry.Name()) != ".xlsx" {
-			continue
-		}
-
-		fullPath := filepath.Join(cfg.InputDir, entry.Name())
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-
-		files = append(files, fileWithSize{
-			Path: fullPath,
-			Size: info.Size(),
-		})
+		return nil, "", err
 	}
 
 	if len(files) == 0 {
-		return nil, "", fmt.Errorf("не найдено .xlsx файлов в директории")
+		return nil, "", withKind(ErrNoInputFiles, fmt.Errorf("не найдено входных файлов (%s) в директории", inputExtensions))
 	}
 
-	// Сортировка по размеру по возрастанию
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Size < files[j].Size
-	})
+	// Самый большой файл - шаблон по умолчанию, независимо от порядка обработки.
+	// Книги OpenXML предпочтительнее .xls и CSV: из них берутся стили.
+	largest := files[0]
+	for _, f := range files[1:] {
+		fRank, largestRank := templateRank(f.Path), templateRank(largest.Path)
+		switch {
+		case fRank != largestRank:
+			if fRank < largestRank {
+				largest = f
+			}
+		case f.Size > largest.Size || (f.Size == largest.Size && naturalLess(largest.Path, f.Path)):
+			largest = f
+		}
+	}
 
-	inputFiles := make([]string, len(files))
-	for i, f := range files {
-		inputFiles[i] = f.Path
+	// Упорядочивание согласно -order
+	files, err = sortInputFiles(files, cfg)
+	if err != nil {
+		return nil, "", config.Invalid(err)
 	}
 
 	// Определение шаблона
 	if cfg.TemplatePath != "" {
 		if _, err := os.Stat(cfg.TemplatePath); os.IsNotExist(err) {
-			return nil, "", fmt.Errorf("шаблонный файл не найден: %s", cfg.TemplatePath)
+			return nil, "", withKind(ErrTemplate, fmt.Errorf("шаблонный файл не найден: %s", cfg.TemplatePath))
 		}
 		templatePath = cfg.TemplatePath
 	} else {
-		templatePath = inputFiles[len(inputFiles)-1] // самый большой файл
+		templatePath = largest.Path // самый большой файл
 	}
 
-	return inputFiles, templatePath, nil
+	return files, templatePath, nil
+}
+
+// templateRank возвращает приоритет файла при выборе шаблона по умолчанию (меньше - лучше)
+func templateRank(path string) int {
+	switch {
+	case strings.EqualFold(filepath.Ext(path), ".xlsx"):
+		return 0
+	case isOpenXMLFileName(path):
+		return 1
+	case isCSVFileName(path):
+		return 3
+	}
+	return 2
 }
@@ -0,0 +1,201 @@
+package merger
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+)
+
+// writeFiles создает в dir файлы с заданным содержимым
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// csvConfig возвращает конфигурацию слияния CSV файлов dir в CSV результат
+func csvConfig(t *testing.T, dir string) *config.Config {
+	t.Helper()
+	cfg := config.Default()
+	cfg.InputDir = dir
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.csv")
+	cfg.HasHeaders = true
+	cfg.Order = config.OrderName
+	cfg.Workers = 2
+	return cfg
+}
+
+// schemaMerger создает StreamMerger со схемой headers без чтения шаблона:
+// все колонки - колонки данных с общим числовым форматом и стилем по умолчанию
+func schemaMerger(cfg *config.Config, headers ...string) *StreamMerger {
+	sm := &StreamMerger{Cfg: cfg}
+	sm.Headers = headers
+	sm.DataColumns = len(headers)
+	sm.HeaderStyles = make([]int, len(headers))
+	sm.RowStyles = make([]int, len(headers))
+	sm.ValueTypes = make([]excelize.CellType, len(headers))
+	sm.FormatClasses = make([]NumFmtClass, len(headers))
+	return sm
+}
+
+// runMerge нормализует cfg, выполняет слияние и возвращает итоги
+func runMerge(t *testing.T, cfg *config.Config) (*MergeResult, error) {
+	t.Helper()
+	if err := cfg.Normalize(); err != nil {
+		t.Fatalf("Normalize: %v", err)
+	}
+	sm := NewStreamMerger().(*StreamMerger)
+	return sm.MergeFiles(context.Background(), cfg)
+}
+
+// readCSVOutput читает записи всех частей CSV результата
+func readCSVOutput(t *testing.T, res *MergeResult) [][]string {
+	t.Helper()
+	var records [][]string
+	for _, name := range res.OutputFiles {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		records = append(records, part...)
+	}
+	return records
+}
+
+func TestMergeFilesOnErrorSkip(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.csv": "Код,Имя\n1,a1\n2,a2\n",
+		// ошибка после двух прочитанных строк: незакрытая кавычка
+		"b.csv":  "Код,Имя\n3,b1\n4,b2\n5,\"b3\n",
+		"c.csv":  "Код,Имя\n6,c1\n",
+		"d.xlsx": "не книга",
+	})
+	want := [][]string{{"Код", "Имя"}, {"1", "a1"}, {"2", "a2"}, {"6", "c1"}}
+
+	tests := []struct {
+		name       string
+		mode       string
+		bufferRows int
+	}{
+		{"ordered", config.MergeOrdered, 0},
+		{"ordered small buffer", config.MergeOrdered, 1},
+		{"contiguous", config.MergeContiguous, 1},
+		{"interleaved", config.MergeInterleaved, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := csvConfig(t, dir)
+			cfg.TemplatePath = filepath.Join(dir, "a.csv")
+			cfg.OnError = config.OnErrorSkip
+			cfg.MergeMode = tt.mode
+			cfg.BufferRows = tt.bufferRows
+			res, err := runMerge(t, cfg)
+			if err != nil {
+				t.Fatalf("MergeFiles: %v", err)
+			}
+			got := readCSVOutput(t, res)
+			if tt.mode != config.MergeOrdered && len(got) > 1 {
+				// порядок файлов (и строк при interleaved) зависит от скорости чтения
+				slices.SortFunc(got[1:], func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("результат = %v, ожидается %v", got, want)
+			}
+			if !res.Partial || res.RowCount != 3 {
+				t.Errorf("Partial = %v, RowCount = %d", res.Partial, res.RowCount)
+			}
+			var failed []string
+			for _, f := range res.FailedFiles {
+				failed = append(failed, f.File)
+			}
+			if !reflect.DeepEqual(failed, []string{"b.csv", "d.xlsx"}) {
+				t.Errorf("FailedFiles = %v", failed)
+			}
+			for _, f := range res.Files {
+				if f.Status == FileError && (f.RowsRead != 0 || f.RowsWritten != 0 || f.Duplicates != 0) {
+					t.Errorf("пропущенный файл %s: %+v", f.File, f)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeFilesOnErrorFail(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.csv": "Код\n1\n",
+		"b.csv": "Код\n2\n\"3\n",
+	})
+	cfg := csvConfig(t, dir)
+	cfg.TemplatePath = filepath.Join(dir, "a.csv")
+	_, err := runMerge(t, cfg)
+	if err == nil {
+		t.Fatal("ожидается ошибка чтения b.csv")
+	}
+	if !errors.Is(err, ErrRead) {
+		t.Errorf("ошибка %v не относится к ErrRead", err)
+	}
+}
+
+func TestMergeFilesAllFailed(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.csv": "Код\n1\n",
+		"b.csv": "Код\n\"2\n",
+	})
+	cfg := csvConfig(t, dir)
+	cfg.TemplatePath = filepath.Join(dir, "a.csv")
+	cfg.Include = []string{"b.csv"}
+	cfg.OnError = config.OnErrorSkip
+	res, err := runMerge(t, cfg)
+	if !errors.Is(err, ErrRead) {
+		t.Fatalf("ошибка = %v, ожидается ErrRead", err)
+	}
+	if len(res.OutputFiles) != 0 {
+		t.Errorf("результат не должен сохраняться: %v", res.OutputFiles)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(cfg.OutputPath), "out_part1.csv")); !os.IsNotExist(err) {
+		t.Errorf("файл части не удален: %v", err)
+	}
+}
+
+func TestMergeFilesUnknownColumnsRelPath(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.csv":     "Код,Имя\n1,первая строка шаблона\n2,вторая строка шаблона\n",
+		"sub/b.csv": "Код,Лишняя\n3,x\n",
+	})
+	cfg := csvConfig(t, dir)
+	cfg.Recursive = true
+	cfg.AlignHeaders = true
+	res, err := runMerge(t, cfg)
+	if err != nil {
+		t.Fatalf("MergeFiles: %v", err)
+	}
+	want := []UnknownColumns{{File: "sub/b.csv", Columns: []string{"Лишняя"}}}
+	if !reflect.DeepEqual(res.UnknownColumns, want) {
+		t.Errorf("UnknownColumns = %+v, ожидается %+v", res.UnknownColumns, want)
+	}
+}
@@ -0,0 +1,380 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// aggregateNames - подписи функций итогов в заголовках листа
+var aggregateNames = map[string]string{
+	config.AggSum:      "сумма",
+	config.AggCount:    "количество",
+	config.AggMin:      "минимум",
+	config.AggMax:      "максимум",
+	config.AggAvg:      "среднее",
+	config.AggDistinct: "уникальных",
+}
+
+// aggregate - итог -agg: функция над колонкой схемы
+type aggregate struct {
+	fn  string
+	col int // -1 - число строк (count без колонки)
+}
+
+// aggState - накопленное значение итога в группе
+type aggState struct {
+	count    int64               // непустые значения (для count без колонки - строки)
+	numbers  int64               // числовые значения
+	sum      float64             // сумма числовых значений
+	min, max interface{}         // наименьшее и наибольшее значения
+	distinct map[string]struct{} // различные значения
+}
+
+// summaryGroup - группа строк с одинаковыми значениями колонок -group-by
+type summaryGroup struct {
+	id     string        // текст значений колонок группировки
+	values []interface{} // значения колонок группировки
+	key    []sortValue   // ключ упорядочивания групп
+	states []aggState
+}
+
+// summary считает итоги по группам строк результата по мере записи строк.
+// Группы и различные значения хранятся в памяти.
+type summary struct {
+	sm      *StreamMerger
+	groupBy []int
+	aggs    []aggregate
+	order   *sorter // упорядочивание групп по значениям колонок группировки
+	groups  map[string]*summaryGroup
+	total   *summaryGroup
+	path    string // отдельная книга итогов ("" - лист итогов в каждой книге результата)
+}
+
+// newSummary создает подсчет итогов по настройкам -group-by и -agg.
+// Без -agg считается число строк групп.
+func (sm *StreamMerger) newSummary() (*summary, error) {
+	s := &summary{sm: sm, path: summaryPath(sm.Cfg)}
+	// группы различаются с учетом регистра, поэтому и упорядочиваются с его учетом
+	s.order = &sorter{sm: sm, collator: collate.New(language.Russian)}
+	for _, name := range sm.Cfg.GroupBy {
+		col := sm.columnIndex(name)
+		if col < 0 {
+			return nil, fmt.Errorf("итоги: колонка группировки %q не найдена в шаблоне", name)
+		}
+		s.groupBy = append(s.groupBy, col)
+		s.order.columns = append(s.order.columns, sortColumn{col: col})
+	}
+	specs := sm.Cfg.Aggregates
+	if len(specs) == 0 {
+		specs = []string{config.AggCount}
+	}
+	for _, spec := range specs {
+		name, fn, err := config.Aggregate(spec)
+		if err != nil {
+			return nil, err
+		}
+		a := aggregate{fn: fn, col: -1}
+		if name != "" {
+			if a.col = sm.columnIndex(name); a.col < 0 {
+				return nil, fmt.Errorf("итоги: колонка %q не найдена в шаблоне", name)
+			}
+		}
+		s.aggs = append(s.aggs, a)
+	}
+	s.groups = make(map[string]*summaryGroup)
+	s.total = &summaryGroup{states: make([]aggState, len(s.aggs))}
+	return s, nil
+}
+
+// summaryPath возвращает путь отдельной книги итогов: из -summary-out или
+// <out>_summary.xlsx для форматов без листов; "" - итоги пишутся листом книги результата
+func summaryPath(cfg *config.Config) string {
+	if cfg.SummaryPath != "" || cfg.Format == config.FormatXLSX {
+		return cfg.SummaryPath
+	}
+	base, _ := outputBase(cfg)
+	return base + "_summary.xlsx"
+}
+
+// Add учитывает строку результата
+func (s *summary) Add(p RowPayload) {
+	sm := s.sm
+	var b strings.Builder
+	values := make([]interface{}, len(s.groupBy))
+	for i, col := range s.groupBy {
+		v := summaryValue(sm, p.Cells, col)
+		values[i] = v
+		b.WriteString(sm.formatPlainValue(v, col))
+		b.WriteByte(0)
+	}
+	id := b.String()
+	g := s.groups[id]
+	if g == nil {
+		g = &summaryGroup{id: id, values: values, key: s.order.key(p), states: make([]aggState, len(s.aggs))}
+		s.groups[id] = g
+	}
+	for i, a := range s.aggs {
+		if a.col < 0 {
+			g.states[i].count++
+			s.total.states[i].count++
+			continue
+		}
+		v := summaryValue(sm, p.Cells, a.col)
+		if v == nil {
+			continue
+		}
+		text := sm.formatPlainValue(v, a.col)
+		g.states[i].add(a.fn, v, text)
+		s.total.states[i].add(a.fn, v, text)
+	}
+}
+
+// summaryValue возвращает значение колонки строки; пустой текст - nil
+func summaryValue(sm *StreamMerger, cells []interface{}, col int) interface{} {
+	v := sm.plainValue(cellValue(cells, col), col)
+	if t, ok := v.(string); ok && strings.TrimSpace(t) == "" {
+		return nil
+	}
+	return v
+}
+
+// add учитывает непустое значение v (text - его текст) в итоге функции fn
+func (st *aggState) add(fn string, v interface{}, text string) {
+	st.count++
+	switch fn {
+	case config.AggSum, config.AggAvg:
+		n, ok := v.(float64)
+		if t, isText := v.(string); isText {
+			n, ok = parseNumber(t)
+		}
+		if ok {
+			st.numbers++
+			st.sum += n
+		}
+	case config.AggMin, config.AggMax:
+		if t, ok := v.(string); ok {
+			if n, ok := parseNumber(t); ok {
+				v = n
+			}
+		}
+		cur := &st.min
+		want := -1
+		if fn == config.AggMax {
+			cur, want = &st.max, 1
+		}
+		if *cur == nil {
+			*cur = v
+		} else if c, ok := compareFilterValues(v, *cur); ok && c == want {
+			*cur = v
+		}
+	case config.AggDistinct:
+		if st.distinct == nil {
+			st.distinct = make(map[string]struct{})
+		}
+		st.distinct[text] = struct{}{}
+	}
+}
+
+// value возвращает итог функции fn (nil - нет значений)
+func (st *aggState) value(fn string) interface{} {
+	switch fn {
+	case config.AggSum:
+		if st.numbers > 0 {
+			return st.sum
+		}
+	case config.AggAvg:
+		if st.numbers > 0 {
+			return st.sum / float64(st.numbers)
+		}
+	case config.AggCount:
+		return st.count
+	case config.AggMin:
+		return st.min
+	case config.AggMax:
+		return st.max
+	case config.AggDistinct:
+		return len(st.distinct)
+	}
+	return nil
+}
+
+// header возвращает заголовок колонки итога
+func (s *summary) header(a aggregate) string {
+	if a.col < 0 {
+		return "Количество строк"
+	}
+	return fmt.Sprintf("%s (%s)", s.sm.Headers[a.col], aggregateNames[a.fn])
+}
+
+// style возвращает стиль значений итога: формат исходной колонки для сумм, средних
+// и границ, общий - для количеств
+func (s *summary) style(a aggregate) int {
+	switch {
+	case a.col < 0, a.fn == config.AggCount, a.fn == config.AggDistinct:
+		return 0
+	}
+	return s.sm.RowStyles[a.col]
+}
+
+// columns возвращает колонки схемы, соответствующие колонкам листа итогов (-1 - нет)
+func (s *summary) columns() []int {
+	cols := slices.Clone(s.groupBy)
+	for _, a := range s.aggs {
+		cols = append(cols, a.col)
+	}
+	return cols
+}
+
+// save записывает итоги всех строк в отдельную книгу на основе шаблона
+func (s *summary) save() error {
+	sm := s.sm
+	f, err := sm.createFile(s.path)
+	if err != nil {
+		return fmt.Errorf("ошибка создания книги итогов %s: %v", s.path, err)
+	}
+	x := &xlsxWriter{sm: sm}
+	if err = x.open(f); err == nil {
+		if err = x.writeSummary(s); err == nil {
+			err = x.ClosePart()
+		} else {
+			x.AbortPart()
+		}
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("ошибка сохранения книги итогов: %v", cerr)
+	}
+	if err != nil && sm.CreateFile == nil {
+		os.Remove(s.path)
+	}
+	return err
+}
+
+// writeSummary добавляет в книгу лист итогов: заголовки колонок группировки и итогов,
+// строки групп в порядке значений группировки и строку «Итого» по всем строкам.
+// Стили и ширина колонок берутся из колонок шаблона.
+func (x *xlsxWriter) writeSummary(s *summary) error {
+	sm := x.sm
+	name := sanitizeSheetName(sm.Cfg.SummarySheet)
+	for _, sheet := range x.sheets {
+		if sheet.Name == name {
+			return fmt.Errorf("имя листа итогов совпадает с листом результата: %s", name)
+		}
+	}
+	if _, err := x.file.NewSheet(name); err != nil {
+		return fmt.Errorf("ошибка создания листа %s: %v", name, err)
+	}
+	cols := s.columns()
+	for i, col := range cols {
+		if col < 0 {
+			continue
+		}
+		if src := sm.templateColumn(col); src >= 0 {
+			colName, _ := excelize.ColumnNumberToName(i + 1)
+			srcName, _ := excelize.ColumnNumberToName(src + 1)
+			if width, err := x.file.GetColWidth(x.templateDataSheet, srcName); err == nil {
+				x.file.SetColWidth(name, colName, colName, width)
+			}
+		}
+	}
+	sw, err := x.file.NewStreamWriter(name)
+	if err != nil {
+		return fmt.Errorf("ошибка создания StreamWriter: %v", err)
+	}
+	sheet := &OutputSheet{Name: name, StreamWriter: sw}
+	x.sheets = append(x.sheets, sheet)
+	write := func(row []interface{}, height float64) error {
+		sheet.RowCounter++
+		cell, _ := excelize.CoordinatesToCellName(1, int(sheet.RowCounter))
+		if err := sw.SetRow(cell, row, excelize.RowOpts{Height: height}); err != nil {
+			return fmt.Errorf("ошибка записи листа итогов: %v", err)
+		}
+		return nil
+	}
+
+	headerStyle := 0
+	if len(sm.HeaderStyles) > 0 {
+		headerStyle = sm.HeaderStyles[0]
+	}
+	row := make([]interface{}, len(cols))
+	for i, col := range s.groupBy {
+		row[i] = excelize.Cell{Value: sm.Headers[col], StyleID: sm.HeaderStyles[col]}
+	}
+	for i, a := range s.aggs {
+		style := headerStyle
+		if a.col >= 0 {
+			style = sm.HeaderStyles[a.col]
+		}
+		row[len(s.groupBy)+i] = excelize.Cell{Value: s.header(a), StyleID: style}
+	}
+	if err := write(row, sm.HeightHeader); err != nil {
+		return err
+	}
+
+	groups := make([]*summaryGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	slices.SortFunc(groups, func(a, b *summaryGroup) int {
+		if c := s.order.compare(a.key, b.key); c != 0 {
+			return c
+		}
+		return strings.Compare(a.id, b.id)
+	})
+	if len(s.groupBy) > 0 {
+		for _, g := range groups {
+			row := make([]interface{}, len(cols))
+			for i, col := range s.groupBy {
+				row[i] = excelize.Cell{Value: g.values[i], StyleID: sm.RowStyles[col]}
+			}
+			s.fillRow(row, g, func(style int) int { return style })
+			if err := write(row, 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	// строка «Итого» выделяется полужирным шрифтом
+	bold := make(map[int]int)
+	boldStyle := func(style int) int {
+		if id, ok := bold[style]; ok {
+			return id
+		}
+		id := style
+		if st, err := x.file.GetStyle(style); err == nil {
+			if st.Font == nil {
+				st.Font = &excelize.Font{}
+			}
+			st.Font.Bold = true
+			if newID, err := x.file.NewStyle(st); err == nil {
+				id = newID
+			}
+		}
+		bold[style] = id
+		return id
+	}
+	row = make([]interface{}, len(cols))
+	if len(s.groupBy) > 0 {
+		row[0] = excelize.Cell{Value: "Итого", StyleID: boldStyle(sm.RowStyles[s.groupBy[0]])}
+	}
+	s.fillRow(row, s.total, boldStyle)
+	return write(row, 0)
+}
+
+// fillRow заполняет ячейки итогов группы g в строке листа итогов;
+// style преобразует стиль значения (для строки «Итого»)
+func (s *summary) fillRow(row []interface{}, g *summaryGroup, style func(int) int) {
+	for i, a := range s.aggs {
+		v := g.states[i].value(a.fn)
+		if v == nil {
+			continue
+		}
+		row[len(s.groupBy)+i] = excelize.Cell{Value: v, StyleID: style(s.style(a))}
+	}
+}
@@ -0,0 +1,58 @@
+package merger
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestSummaryEveryPart(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.csv": "Регион,Сумма\nСевер,1\nЮг,2\nСевер,3\n",
+		"b.csv": "Регион,Сумма\nЮг,4\nСевер,5\n",
+	})
+	// итоги по всем строкам результата, а не только строкам части
+	want := [][]string{{"Регион", "Количество строк", "Сумма (сумма)"}, {"Север", "3", "9.00"}, {"Юг", "2", "6.00"}, {"Итого", "5", "15.00"}}
+	for _, manifest := range []bool{false, true} {
+		t.Run(fmt.Sprintf("manifest=%v", manifest), func(t *testing.T) {
+			cfg := csvConfig(t, dir)
+			cfg.OutputPath = filepath.Join(t.TempDir(), "out.xlsx")
+			cfg.MaxRowPerFile = 2
+			cfg.GroupBy = []string{"Регион"}
+			cfg.Aggregates = []string{"count", "Сумма:sum"}
+			cfg.Manifest = manifest
+			res, err := runMerge(t, cfg)
+			if err != nil {
+				t.Fatalf("MergeFiles: %v", err)
+			}
+			if len(res.OutputFiles) < 2 {
+				t.Fatalf("частей %d, ожидается несколько", len(res.OutputFiles))
+			}
+			for i, name := range res.OutputFiles {
+				if want := fmt.Sprintf("out_part%d.xlsx", i+1); filepath.Base(name) != want {
+					t.Errorf("часть %d: %s, ожидается %s", i+1, filepath.Base(name), want)
+				}
+				f, err := excelize.OpenFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows, err := f.GetRows(cfg.SummarySheet)
+				_, manifestErr := f.GetRows(ManifestSheet)
+				f.Close()
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if !reflect.DeepEqual(rows, want) {
+					t.Errorf("%s: итоги = %v, ожидается %v", name, rows, want)
+				}
+				if hasManifest := manifestErr == nil; hasManifest != (manifest && i == 0) {
+					t.Errorf("%s: лист %s = %v", name, ManifestSheet, hasManifest)
+				}
+			}
+		})
+	}
+}
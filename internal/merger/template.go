@@ -0,0 +1,230 @@
+package merger
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// contentTypeWorkbook - тип содержимого книги .xlsx
+const contentTypeWorkbook = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"
+
+// workbookContentTypes - типы содержимого книг с макросами и шаблонов Excel
+var workbookContentTypes = regexp.MustCompile(`application/vnd\.ms-excel\.(sheet|template)\.macroEnabled\.main\+xml|application/vnd\.openxmlformats-officedocument\.spreadsheetml\.template\.main\+xml`)
+
+// vbaRelationship - ссылка книги на проект VBA
+var vbaRelationship = regexp.MustCompile(`<Relationship\b[^>]*/vbaProject"[^>]*/>`)
+
+// templateFile возвращает путь к XLSX файлу, на основе которого создается результат.
+// Книги .xlsm, .xltx и .xltm копируются во временный .xlsx без макросов,
+// для .xls и CSV строится временный шаблон по заголовкам и типам колонок.
+// Временные файлы удаляются функцией cleanup.
+func (sm *StreamMerger) templateFile(path string) (string, func(), error) {
+	noop := func() {}
+	var (
+		tmp string
+		err error
+	)
+	switch {
+	case !isOpenXMLFileName(path):
+		tmp, err = sm.buildSourceTemplate(path)
+	case strings.EqualFold(filepath.Ext(path), ".xlsx"):
+		return path, noop, nil
+	default:
+		tmp, err = stripMacros(path)
+	}
+	if err != nil {
+		return "", noop, err
+	}
+	return tmp, func() { os.Remove(tmp) }, nil
+}
+
+// stripMacros копирует книгу .xlsm/.xltx/.xltm во временный .xlsx:
+// проект VBA удаляется, тип содержимого книги меняется на обычный
+func stripMacros(path string) (string, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return "", fmt.Errorf("ошибка открытия шаблона %s: %v", path, err)
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "xlsx-merger-template-*.xlsx")
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания временного шаблона: %v", err)
+	}
+	fail := func(err error) (string, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("ошибка подготовки шаблона %s: %v", path, err)
+	}
+
+	zw := zip.NewWriter(tmp)
+	for _, f := range r.File {
+		name := strings.ToLower(f.Name)
+		if strings.HasPrefix(name, "xl/vbaproject") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fail(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fail(err)
+		}
+		switch name {
+		case "[content_types].xml":
+			data = workbookContentTypes.ReplaceAll(data, []byte(contentTypeWorkbook))
+		case "xl/_rels/workbook.xml.rels":
+			data = vbaRelationship.ReplaceAll(data, nil)
+		}
+		w, err := zw.Create(f.Name)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fail(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fail(err)
+	}
+	return tmp.Name(), nil
+}
+
+// buildSourceTemplate создает временный XLSX шаблон по файлу, из которого нельзя
+// взять стили (CSV, .xls): первая строка - заголовки, вторая - образец значений,
+// типы колонок определяются по первым cfg.SampleRows строкам первого выбранного листа.
+// Возвращает путь к временному файлу, который нужно удалить после слияния.
+func (sm *StreamMerger) buildSourceTemplate(path string) (string, error) {
+	cfg := sm.Cfg
+	src, err := openSource(path, cfg)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	sheets := sm.sourceSheets(src)
+	if len(sheets) == 0 {
+		return "", fmt.Errorf("в файле %s нет подходящих листов", path)
+	}
+	rows, err := src.Rows(sheets[0])
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения %s: %v", path, err)
+	}
+	defer rows.Close()
+
+	var header []string
+	var sample [][]string
+	hints := make(map[int]excelize.CellType) // типы колонок, известные источнику (даты и логические в .xls)
+	for rowNum := 1; rows.Next() && len(sample) < max(cfg.SampleRows, 1); rowNum++ {
+		record, _ := rows.Columns()
+		if header == nil {
+			header = record
+			if cfg.HasHeaders {
+				continue
+			}
+		}
+		sample = append(sample, record)
+		for col := range record {
+			switch t := src.CellType(sheets[0], col+1, rowNum); t {
+			case excelize.CellTypeDate, excelize.CellTypeBool:
+				hints[col] = t
+			}
+		}
+	}
+	if header == nil {
+		return "", fmt.Errorf("файл %s не содержит данных", path)
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetList()[0]
+
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 14})
+	if err != nil {
+		return "", err
+	}
+	numberStyle, err := f.NewStyle(&excelize.Style{NumFmt: 4})
+	if err != nil {
+		return "", err
+	}
+
+	for col := range header {
+		ref, _ := excelize.CoordinatesToCellName(col+1, 1)
+		f.SetCellValue(sheet, ref, header[col])
+
+		colType := inferColumnType(sample, col)
+		if t, ok := hints[col]; ok {
+			colType = t
+		}
+		ref, _ = excelize.CoordinatesToCellName(col+1, 2)
+		switch colType {
+		case excelize.CellTypeNumber:
+			f.SetCellValue(sheet, ref, 0)
+			f.SetCellStyle(sheet, ref, ref, numberStyle)
+		case excelize.CellTypeDate:
+			f.SetCellValue(sheet, ref, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+			f.SetCellStyle(sheet, ref, ref, dateStyle)
+		case excelize.CellTypeBool:
+			f.SetCellValue(sheet, ref, false)
+		default:
+			f.SetCellValue(sheet, ref, "")
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "xlsx-merger-template-*.xlsx")
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания временного шаблона: %v", err)
+	}
+	tmp.Close()
+	if err := f.SaveAs(tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("ошибка сохранения временного шаблона: %v", err)
+	}
+	return tmp.Name(), nil
+}
+
+// inferColumnType определяет тип колонки по образцу строк:
+// число, дата или логическое значение, если все непустые значения распознаются
+func inferColumnType(sample [][]string, col int) excelize.CellType {
+	isNumber, isDate, isBool, seen := true, true, true, false
+	for _, row := range sample {
+		if col >= len(row) || strings.TrimSpace(row[col]) == "" {
+			continue
+		}
+		seen = true
+		v := row[col]
+		if _, ok := parseNumber(v); !ok {
+			isNumber = false
+		}
+		if _, ok := parseDate(v); !ok {
+			isDate = false
+		}
+		if _, ok := parseBool(v); !ok {
+			isBool = false
+		}
+	}
+	switch {
+	case !seen:
+		return excelize.CellTypeInlineString
+	case isBool && !isNumber:
+		return excelize.CellTypeBool
+	case isNumber:
+		return excelize.CellTypeNumber
+	case isDate:
+		return excelize.CellTypeDate
+	}
+	return excelize.CellTypeInlineString
+}
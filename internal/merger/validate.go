@@ -0,0 +1,225 @@
+package merger
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// columnRule - правило проверки колонки схемы, привязанное к ее позиции
+type columnRule struct {
+	col      int
+	required bool
+	pattern  *regexp.Regexp
+	min, max interface{} // float64 или time.Time
+	allowed  map[string]bool
+}
+
+// columnIndex возвращает позицию колонки схемы по заголовку шаблона (с учетом синонимов),
+// заголовку вычисляемой колонки или букве (A, B, ...); -1, если колонка не найдена
+func (sm *StreamMerger) columnIndex(name string) int {
+	if positions := sm.headerIndex()[normalizeHeader(name)]; len(positions) > 0 && sm.Cfg.HasHeaders {
+		return positions[0]
+	}
+	if sm.Cfg.HasHeaders {
+		for _, d := range sm.derived {
+			if normalizeHeader(sm.Headers[d.pos]) == normalizeHeader(name) {
+				return d.pos
+			}
+		}
+	}
+	if n, err := excelize.ColumnNameToNumber(name); err == nil && n <= len(sm.Headers) {
+		return n - 1
+	}
+	return -1
+}
+
+// compileRules сопоставляет правила из конфигурации с колонками схемы.
+// Колонка задается заголовком шаблона (с учетом синонимов) или буквой (A, B, ...).
+func (sm *StreamMerger) compileRules() error {
+	sm.rules = nil
+	for _, r := range sm.Cfg.Rules {
+		col := sm.columnIndex(r.Column)
+		if col < 0 {
+			return fmt.Errorf("правило проверки: колонка %q не найдена в шаблоне", r.Column)
+		}
+
+		rule := columnRule{col: col, required: r.Required}
+		if r.Regex != "" {
+			pattern, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return fmt.Errorf("правило проверки %q: %v", r.Column, err)
+			}
+			rule.pattern = pattern
+		}
+		for _, bound := range []struct {
+			spec string
+			dst  *interface{}
+		}{{r.Min, &rule.min}, {r.Max, &rule.max}} {
+			if bound.spec == "" {
+				continue
+			}
+			v, ok := sm.ruleBound(bound.spec, col)
+			if !ok {
+				return fmt.Errorf("правило проверки %q: некорректная граница %q", r.Column, bound.spec)
+			}
+			*bound.dst = v
+		}
+		if len(r.Allowed) > 0 {
+			rule.allowed = make(map[string]bool)
+			for _, v := range r.Allowed {
+				rule.allowed[strings.ToLower(v)] = true
+			}
+		}
+		sm.rules = append(sm.rules, rule)
+	}
+	return nil
+}
+
+// ruleBound разбирает границу min/max: дата для колонок с датой, иначе число
+func (sm *StreamMerger) ruleBound(spec string, col int) (interface{}, bool) {
+	if sm.formatClass(col) == NumFmtDate {
+		if t, ok := parseDate(spec); ok {
+			return t, true
+		}
+	}
+	if n, ok := parseNumber(spec); ok {
+		return n, true
+	}
+	return nil, false
+}
+
+// validating сообщает, нужно ли проверять строки
+func (sm *StreamMerger) validating() bool {
+	return sm.Cfg.Validate || len(sm.rules) > 0
+}
+
+// validateRow проверяет строку: raw - исходные значения по позициям схемы,
+// cells - значения после приведения к типам колонок.
+// Возвращает причины отклонения через "; " или пустую строку.
+func (sm *StreamMerger) validateRow(raw []string, cells []interface{}) string {
+	var reasons []string
+	header := func(col int) string {
+		if sm.Cfg.HasHeaders && col < len(sm.Headers) && strings.TrimSpace(sm.Headers[col]) != "" {
+			return sm.Headers[col]
+		}
+		name, _ := excelize.ColumnNumberToName(col + 1)
+		return name
+	}
+	rawValue := func(col int) string {
+		if col < len(raw) {
+			return strings.TrimSpace(raw[col])
+		}
+		return ""
+	}
+
+	typeErrors := make(map[int]bool) // колонки со значением не своего типа
+	if sm.Cfg.Validate {
+		for i := 0; i < sm.DataColumns; i++ {
+			col := sm.dataPosition(i)
+			v := rawValue(col)
+			if v == "" || col >= len(sm.ValueTypes) {
+				continue
+			}
+			// значение, которое не удалось привести к типу колонки, остается строкой
+			if _, isText := cellValue(cells, col).(string); !isText {
+				continue
+			}
+			typeErrors[col] = true
+			switch sm.ValueTypes[col] {
+			case excelize.CellTypeNumber:
+				reasons = append(reasons, fmt.Sprintf("%s: ожидается число, получено %q", header(col), v))
+			case excelize.CellTypeBool:
+				reasons = append(reasons, fmt.Sprintf("%s: ожидается логическое значение, получено %q", header(col), v))
+			case excelize.CellTypeDate:
+				reasons = append(reasons, fmt.Sprintf("%s: ожидается %s, получено %q", header(col), temporalName(sm.formatClass(col)), v))
+			default:
+				typeErrors[col] = false
+			}
+		}
+	}
+
+	for _, rule := range sm.rules {
+		v := rawValue(rule.col)
+		if v == "" {
+			if rule.required {
+				reasons = append(reasons, fmt.Sprintf("%s: значение обязательно", header(rule.col)))
+			}
+			continue
+		}
+		if rule.pattern != nil && !rule.pattern.MatchString(v) {
+			reasons = append(reasons, fmt.Sprintf("%s: значение %q не соответствует шаблону %s", header(rule.col), v, rule.pattern))
+		}
+		if rule.allowed != nil && !rule.allowed[strings.ToLower(v)] {
+			reasons = append(reasons, fmt.Sprintf("%s: недопустимое значение %q", header(rule.col), v))
+		}
+		if rule.min != nil || rule.max != nil {
+			value := sm.plainValue(cellValue(cells, rule.col), rule.col)
+			cmpMin, minOK := compareBound(value, rule.min)
+			cmpMax, maxOK := compareBound(value, rule.max)
+			switch {
+			case !minOK && !maxOK && !typeErrors[rule.col]:
+				reasons = append(reasons, fmt.Sprintf("%s: значение %q нельзя сравнить с границами", header(rule.col), v))
+			case minOK && cmpMin < 0:
+				reasons = append(reasons, fmt.Sprintf("%s: значение %q меньше минимума %s", header(rule.col), v, formatBound(rule.min)))
+			case maxOK && cmpMax > 0:
+				reasons = append(reasons, fmt.Sprintf("%s: значение %q больше максимума %s", header(rule.col), v, formatBound(rule.max)))
+			}
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// cellValue возвращает значение ячейки строки без стиля
+func cellValue(cells []interface{}, col int) interface{} {
+	if col >= len(cells) {
+		return nil
+	}
+	if c, ok := cells[col].(excelize.Cell); ok {
+		return c.Value
+	}
+	return cells[col]
+}
+
+// compareBound сравнивает значение с границей: -1, 0, 1.
+// ok = false, если граница не задана или значение другого типа.
+func compareBound(value, bound interface{}) (int, bool) {
+	switch b := bound.(type) {
+	case float64:
+		if v, ok := value.(float64); ok {
+			return cmp.Compare(v, b), true
+		}
+	case time.Time:
+		if v, ok := value.(time.Time); ok {
+			return v.Compare(b), true
+		}
+	}
+	return 0, false
+}
+
+// formatBound форматирует границу для сообщения
+func formatBound(bound interface{}) string {
+	if t, ok := bound.(time.Time); ok {
+		return formatTime(t, NumFmtDate)
+	}
+	if n, ok := bound.(float64); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(bound)
+}
+
+// temporalName возвращает название типа значения колонки с датой или временем
+func temporalName(class NumFmtClass) string {
+	switch class {
+	case NumFmtTime:
+		return "время"
+	case NumFmtDuration:
+		return "длительность"
+	}
+	return "дата"
+}
@@ -0,0 +1,167 @@
+package merger
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// date1904Offset - разница в днях между системами дат 1900 и 1904
+const date1904Offset = 1462
+
+// dateLayouts - форматы текстовых дат, распознаваемые в колонках с датами
+var dateLayouts = []string{
+	"02.01.2006",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"2.1.2006",
+	"2.1.2006 15:04:05",
+	"2.1.2006 15:04",
+	"02.01.06",
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"02/01/2006",
+	"02/01/2006 15:04:05",
+	"15:04:05",
+	"15:04",
+}
+
+// parseNumber разбирает число, допуская пробелы между разрядами,
+// запятую в качестве десятичного разделителя и знак процента: "1 234,56", "15%"
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n, true
+	}
+
+	percent := strings.HasSuffix(s, "%")
+	s = strings.TrimSuffix(s, "%")
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\u00a0', '\u202f', '\u2009', '\'':
+			return -1
+		}
+		return r
+	}, s)
+	switch {
+	case strings.Contains(s, ",") && strings.Contains(s, "."):
+		// разделитель разрядов - тот, что встречается первым
+		if strings.Index(s, ",") < strings.Index(s, ".") {
+			s = strings.ReplaceAll(s, ",", "")
+		} else {
+			s = strings.ReplaceAll(strings.ReplaceAll(s, ".", ""), ",", ".")
+		}
+	case strings.Count(s, ",") == 1:
+		s = strings.Replace(s, ",", ".", 1)
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	if percent {
+		n /= 100
+	}
+	return n, true
+}
+
+// parseBool разбирает логическое значение: 1/0, true/false, да/нет, yes/no
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "истина", "да", "yes":
+		return true, true
+	case "0", "false", "ложь", "нет", "no":
+		return false, true
+	}
+	return false, false
+}
+
+// parseDate разбирает текстовое представление даты
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// convertDate приводит значение ячейки колонки с датой, временем или длительностью
+// к значению для записи.
+// Серийный номер Excel сохраняется числом (с поправкой на систему дат 1904),
+// чтобы ячейка отображалась стилем шаблона; текстовая дата разбирается в time.Time,
+// текстовое время и длительность (10:30, 36:15:00) - в долю суток.
+// Если значение не удалось распознать, возвращается исходная строка.
+func (sm *StreamMerger) convertDate(raw string, srcDate1904 bool, styleID int, class NumFmtClass) interface{} {
+	if raw == "" {
+		return raw
+	}
+	if serial, err := strconv.ParseFloat(raw, 64); err == nil {
+		if class == NumFmtDate && srcDate1904 != sm.Date1904 {
+			if srcDate1904 {
+				serial += date1904Offset
+			} else {
+				serial -= date1904Offset
+			}
+		}
+		if styleID == 0 && class != NumFmtDuration {
+			// без стиля число не будет выглядеть как дата - передаем время,
+			// StreamWriter назначит ячейке стандартный формат даты
+			if t, err := excelize.ExcelDateToTime(serial, sm.Date1904); err == nil {
+				return t
+			}
+		}
+		return serial
+	}
+	if class == NumFmtTime || class == NumFmtDuration {
+		if days, ok := parseClock(raw); ok {
+			return days
+		}
+	}
+	if t, ok := parseDate(raw); ok {
+		return t
+	}
+	return raw
+}
+
+// parseClock разбирает время вида ч:мм[:сс] в долю суток.
+// Часы могут превышать 24 (длительность).
+func parseClock(s string) (float64, bool) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	var total float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || (i > 0 && n >= 60) {
+			return 0, false
+		}
+		switch i {
+		case 0:
+			total += n * 3600
+		case 1:
+			total += n * 60
+		default:
+			total += n
+		}
+	}
+	return total / 86400, true
+}
+
+// formatClass возвращает класс формата колонки шаблона
+func (sm *StreamMerger) formatClass(col int) NumFmtClass {
+	if col < len(sm.FormatClasses) {
+		return sm.FormatClasses[col]
+	}
+	return NumFmtGeneral
+}
@@ -0,0 +1,43 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+func TestConvertDate(t *testing.T) {
+	date := func(y, m, d, h, min int) time.Time { return time.Date(y, time.Month(m), d, h, min, 0, 0, time.UTC) }
+	tests := []struct {
+		name    string
+		raw     string
+		src1904 bool
+		dst1904 bool
+		styleID int
+		class   NumFmtClass
+		want    interface{}
+	}{
+		{"пустое значение", "", false, false, 1, NumFmtDate, ""},
+		{"серийный номер со стилем - число", "45413", false, false, 1, NumFmtDate, 45413.0},
+		{"серийный номер без стиля - время", "45413.5", false, false, 0, NumFmtDate, date(2024, 5, 1, 12, 0)},
+		{"источник 1904, результат 1900", "43951", true, false, 1, NumFmtDate, 45413.0},
+		{"источник 1900, результат 1904", "45413", false, true, 1, NumFmtDate, 43951.0},
+		{"время не сдвигается системой дат", "0.5", true, false, 1, NumFmtTime, 0.5},
+		{"длительность без стиля остается числом", "1.25", false, false, 0, NumFmtDuration, 1.25},
+		{"текстовая дата", "01.05.2024", false, false, 1, NumFmtDate, date(2024, 5, 1, 0, 0)},
+		{"текстовая дата ISO со временем", "2024-05-01 10:30", false, false, 1, NumFmtDate, date(2024, 5, 1, 10, 30)},
+		{"текстовое время в колонке времени", "10:30", false, false, 1, NumFmtTime, 10.5 / 24},
+		{"длительность больше суток", "36:15:00", false, false, 1, NumFmtDuration, 36.25 / 24},
+		{"время в колонке даты - время суток", "10:30", false, false, 1, NumFmtDate, date(0, 1, 1, 10, 30)},
+		{"нераспознанное значение", "вчера", false, false, 1, NumFmtDate, "вчера"},
+		{"некорректные минуты", "10:75", false, false, 1, NumFmtTime, "10:75"},
+	}
+	for _, tt := range tests {
+		sm := schemaMerger(config.Default())
+		sm.Date1904 = tt.dst1904
+		if got := sm.convertDate(tt.raw, tt.src1904, tt.styleID, tt.class); got != tt.want {
+			t.Errorf("%s: convertDate(%q) = %#v, ожидается %#v", tt.name, tt.raw, got, tt.want)
+		}
+	}
+}
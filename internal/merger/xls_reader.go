@@ -0,0 +1,626 @@
+package merger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"unicode/utf16"
+
+	"github.com/richardlehane/mscfb"
+	"github.com/xuri/excelize/v2"
+)
+
+// Типы записей BIFF8, используемые при чтении
+const (
+	biffFormula    = 0x0006
+	biffEOF        = 0x000A
+	biffDateMode   = 0x0022
+	biffFilePass   = 0x002F
+	biffContinue   = 0x003C
+	biffBoundSheet = 0x0085
+	biffMulRK      = 0x00BD
+	biffXF         = 0x00E0
+	biffSST        = 0x00FC
+	biffLabelSST   = 0x00FD
+	biffNumber     = 0x0203
+	biffLabel      = 0x0204
+	biffBoolErr    = 0x0205
+	biffString     = 0x0207
+	biffRow        = 0x0208
+	biffRK         = 0x027E
+	biffFormat     = 0x041E
+	biffBOF        = 0x0809
+)
+
+// biffVersion8 - версия BIFF8 (Excel 97-2003) в записи BOF
+const biffVersion8 = 0x0600
+
+// xlsCell - значение ячейки листа .xls в виде, совместимом с RawCellValue excelize
+type xlsCell struct {
+	value string
+	typ   excelize.CellType
+}
+
+// xlsSheet - прочитанный лист .xls
+type xlsSheet struct {
+	name    string
+	offset  int
+	rows    [][]xlsCell
+	heights map[int]float64
+	loaded  bool
+	err     error // ошибка чтения листа
+}
+
+// xlsSource читает книги Excel 97-2003 (BIFF8) из контейнера OLE.
+// Лист читается в память целиком при первом обращении: формат ограничен
+// 65536 строками и 256 колонками.
+type xlsSource struct {
+	path     string
+	stream   []byte
+	date1904 bool
+	sst      []string
+	formats  map[int]string // пользовательские форматы по номеру
+	xfFormat []int          // номер формата для каждого XF
+	sheets   []*xlsSheet
+}
+
+// openXLSSource открывает файл .xls. Файлы OpenXML с расширением .xls
+// (так иногда сохраняют выгрузки) открываются через excelize.
+func openXLSSource(path string) (sourceReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия файла %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var sig [4]byte
+	if _, err := io.ReadFull(f, sig[:]); err == nil && bytes.Equal(sig[:], []byte("PK\x03\x04")) {
+		return openXLSXSource(path)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %v", path, err)
+	}
+
+	doc, err := mscfb.New(f)
+	if err != nil {
+		return nil, fmt.Errorf("файл %s не является книгой Excel 97-2003: %v", path, err)
+	}
+	src := &xlsSource{path: path, formats: make(map[int]string)}
+	legacy := false
+	for entry, err := doc.Next(); err == nil; entry, err = doc.Next() {
+		switch entry.Name {
+		case "Workbook":
+			if src.stream, err = io.ReadAll(entry); err != nil {
+				return nil, fmt.Errorf("ошибка чтения %s: %v", path, err)
+			}
+		case "Book":
+			legacy = true
+		}
+	}
+	switch {
+	case src.stream == nil && legacy:
+		return nil, fmt.Errorf("файл %s: формат Excel 5.0/95 и ранее не поддерживается", path)
+	case src.stream == nil:
+		return nil, fmt.Errorf("файл %s не содержит книги Excel", path)
+	}
+	if err := src.readGlobals(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %v", path, err)
+	}
+	return src, nil
+}
+
+// biffRecord - запись BIFF вместе с последующими записями CONTINUE
+type biffRecord struct {
+	typ  uint16
+	segs [][]byte
+	next int // позиция следующей записи в потоке
+}
+
+// data возвращает первый сегмент записи
+func (r biffRecord) data() []byte { return r.segs[0] }
+
+// readRecord читает запись потока, начинающуюся с позиции pos
+func (s *xlsSource) readRecord(pos int) (biffRecord, error) {
+	var rec biffRecord
+	for first := true; pos+4 <= len(s.stream); first = false {
+		typ := binary.LittleEndian.Uint16(s.stream[pos:])
+		size := int(binary.LittleEndian.Uint16(s.stream[pos+2:]))
+		if !first && typ != biffContinue {
+			break
+		}
+		if pos+4+size > len(s.stream) {
+			return rec, fmt.Errorf("запись 0x%04X выходит за границы потока", typ)
+		}
+		if first {
+			rec.typ = typ
+		}
+		rec.segs = append(rec.segs, s.stream[pos+4:pos+4+size])
+		pos += 4 + size
+	}
+	if rec.segs == nil {
+		return rec, io.ErrUnexpectedEOF
+	}
+	rec.next = pos
+	return rec, nil
+}
+
+// readGlobals разбирает глобальную часть книги: листы, общие строки, форматы и систему дат
+func (s *xlsSource) readGlobals() error {
+	rec, err := s.readRecord(0)
+	if err != nil {
+		return err
+	}
+	if rec.typ != biffBOF || len(rec.data()) < 2 || binary.LittleEndian.Uint16(rec.data()) != biffVersion8 {
+		return fmt.Errorf("поддерживается только формат Excel 97-2003 (BIFF8)")
+	}
+
+	for pos := rec.next; pos < len(s.stream); {
+		rec, err := s.readRecord(pos)
+		if err != nil {
+			return err
+		}
+		pos = rec.next
+		data := rec.data()
+
+		switch rec.typ {
+		case biffEOF:
+			return nil
+		case biffFilePass:
+			return fmt.Errorf("книга защищена паролем")
+		case biffDateMode:
+			s.date1904 = len(data) >= 2 && binary.LittleEndian.Uint16(data) == 1
+		case biffFormat:
+			if len(data) < 2 {
+				continue
+			}
+			c := &biffCursor{segs: rec.segs, pos: 2}
+			code, err := c.unicodeString(2)
+			if err != nil {
+				return err
+			}
+			s.formats[int(binary.LittleEndian.Uint16(data))] = code
+		case biffXF:
+			if len(data) >= 4 {
+				s.xfFormat = append(s.xfFormat, int(binary.LittleEndian.Uint16(data[2:])))
+			}
+		case biffSST:
+			if err := s.readSST(rec); err != nil {
+				return err
+			}
+		case biffBoundSheet:
+			if len(data) < 8 {
+				continue
+			}
+			// учитываются только рабочие листы (без диаграмм и листов макросов)
+			if data[5] != 0 {
+				continue
+			}
+			c := &biffCursor{segs: rec.segs, pos: 6}
+			name, err := c.unicodeString(1)
+			if err != nil {
+				return err
+			}
+			s.sheets = append(s.sheets, &xlsSheet{
+				name:   name,
+				offset: int(binary.LittleEndian.Uint32(data)),
+			})
+		}
+	}
+	return nil
+}
+
+// readSST читает таблицу общих строк
+func (s *xlsSource) readSST(rec biffRecord) error {
+	if len(rec.data()) < 8 {
+		return fmt.Errorf("некорректная таблица строк")
+	}
+	count := int(binary.LittleEndian.Uint32(rec.data()[4:]))
+	c := &biffCursor{segs: rec.segs, pos: 8}
+	s.sst = make([]string, 0, min(count, 1<<16))
+	for i := 0; i < count; i++ {
+		str, err := c.richString()
+		if err != nil {
+			return fmt.Errorf("ошибка чтения таблицы строк: %v", err)
+		}
+		s.sst = append(s.sst, str)
+	}
+	return nil
+}
+
+// loadSheet читает ячейки листа в память при первом обращении
+func (s *xlsSource) loadSheet(sheet *xlsSheet) error {
+	if !sheet.loaded {
+		sheet.loaded = true
+		sheet.err = s.readSheet(sheet)
+	}
+	return sheet.err
+}
+
+// readSheet разбирает записи ячеек листа
+func (s *xlsSource) readSheet(sheet *xlsSheet) error {
+	sheet.heights = make(map[int]float64)
+
+	rec, err := s.readRecord(sheet.offset)
+	if err != nil || rec.typ != biffBOF {
+		return fmt.Errorf("лист %s не найден в потоке книги", sheet.name)
+	}
+
+	set := func(row, col int, cell xlsCell) {
+		for len(sheet.rows) <= row {
+			sheet.rows = append(sheet.rows, nil)
+		}
+		for len(sheet.rows[row]) <= col {
+			sheet.rows[row] = append(sheet.rows[row], xlsCell{})
+		}
+		sheet.rows[row][col] = cell
+	}
+	// ячейка формулы со строковым результатом, ожидающая записи STRING
+	pendingRow, pendingCol := -1, -1
+	// вложенные потоки (диаграммы на листе) имеют собственные BOF и EOF
+	depth := 0
+
+	for pos := rec.next; pos < len(s.stream); {
+		rec, err := s.readRecord(pos)
+		if err != nil {
+			return err
+		}
+		pos = rec.next
+		data := rec.data()
+		switch {
+		case rec.typ == biffBOF:
+			depth++
+			continue
+		case rec.typ == biffEOF && depth == 0:
+			return nil
+		case rec.typ == biffEOF:
+			depth--
+			continue
+		case depth > 0:
+			continue
+		}
+		if len(data) < 6 && rec.typ != biffString {
+			continue
+		}
+		var row, col, xf int
+		if len(data) >= 6 {
+			row = int(binary.LittleEndian.Uint16(data))
+			col = int(binary.LittleEndian.Uint16(data[2:]))
+			xf = int(binary.LittleEndian.Uint16(data[4:]))
+		}
+
+		switch rec.typ {
+		case biffLabelSST:
+			if len(data) >= 10 {
+				if i := int(binary.LittleEndian.Uint32(data[6:])); i < len(s.sst) {
+					set(row, col, xlsCell{value: s.sst[i], typ: excelize.CellTypeSharedString})
+				}
+			}
+		case biffLabel:
+			c := &biffCursor{segs: rec.segs, pos: 6}
+			if str, err := c.unicodeString(2); err == nil {
+				set(row, col, xlsCell{value: str, typ: excelize.CellTypeInlineString})
+			}
+		case biffNumber:
+			if len(data) >= 14 {
+				v := math.Float64frombits(binary.LittleEndian.Uint64(data[6:]))
+				set(row, col, s.numberCell(v, xf))
+			}
+		case biffRK:
+			if len(data) >= 10 {
+				set(row, col, s.numberCell(decodeRK(binary.LittleEndian.Uint32(data[6:])), xf))
+			}
+		case biffMulRK:
+			// rw, colFirst, (ixfe, rk)*, colLast
+			for i := 4; i+6 <= len(data)-2; i += 6 {
+				xf := int(binary.LittleEndian.Uint16(data[i:]))
+				set(row, col, s.numberCell(decodeRK(binary.LittleEndian.Uint32(data[i+2:])), xf))
+				col++
+			}
+		case biffBoolErr:
+			if len(data) >= 8 {
+				if data[7] == 0 {
+					set(row, col, xlsCell{value: boolString(data[6] != 0), typ: excelize.CellTypeBool})
+				} else {
+					set(row, col, xlsCell{value: biffErrorText(data[6]), typ: excelize.CellTypeError})
+				}
+			}
+		case biffFormula:
+			if len(data) < 14 {
+				continue
+			}
+			value := data[6:14]
+			if value[6] != 0xFF || value[7] != 0xFF {
+				v := math.Float64frombits(binary.LittleEndian.Uint64(value))
+				set(row, col, s.numberCell(v, xf))
+				continue
+			}
+			switch value[0] {
+			case 0: // строка в следующей записи STRING
+				pendingRow, pendingCol = row, col
+			case 1:
+				set(row, col, xlsCell{value: boolString(value[2] != 0), typ: excelize.CellTypeBool})
+			case 2:
+				set(row, col, xlsCell{value: biffErrorText(value[2]), typ: excelize.CellTypeError})
+			}
+		case biffString:
+			if pendingRow < 0 {
+				continue
+			}
+			c := &biffCursor{segs: rec.segs}
+			if str, err := c.unicodeString(2); err == nil {
+				set(pendingRow, pendingCol, xlsCell{value: str, typ: excelize.CellTypeInlineString})
+			}
+			pendingRow, pendingCol = -1, -1
+		case biffRow:
+			// высота в двадцатых долях пункта; учитывается только заданная вручную
+			if len(data) >= 16 && data[12]&0x40 != 0 {
+				sheet.heights[row] = float64(binary.LittleEndian.Uint16(data[6:])&0x7FFF) / 20
+			}
+		}
+	}
+	return nil
+}
+
+// numberCell формирует числовую ячейку; ячейки с форматом даты получают тип даты
+func (s *xlsSource) numberCell(v float64, xf int) xlsCell {
+	cell := xlsCell{value: strconv.FormatFloat(v, 'f', -1, 64), typ: excelize.CellTypeNumber}
+	if xf < len(s.xfFormat) {
+		id := s.xfFormat[xf]
+		class, ok := builtInNumFmtClasses[id]
+		if code, custom := s.formats[id]; custom {
+			class, ok = classifyNumFmtCode(code), true
+		}
+		if ok && class.IsTemporal() {
+			cell.typ = excelize.CellTypeDate
+		}
+	}
+	return cell
+}
+
+// decodeRK распаковывает компактное число RK
+func decodeRK(rk uint32) float64 {
+	var v float64
+	if rk&0x02 != 0 {
+		v = float64(int32(rk) >> 2)
+	} else {
+		v = math.Float64frombits(uint64(rk&0xFFFFFFFC) << 32)
+	}
+	if rk&0x01 != 0 {
+		v /= 100
+	}
+	return v
+}
+
+func boolString(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// biffErrorText возвращает текст кода ошибки ячейки
+func biffErrorText(code byte) string {
+	switch code {
+	case 0x00:
+		return "#NULL!"
+	case 0x07:
+		return "#DIV/0!"
+	case 0x0F:
+		return "#VALUE!"
+	case 0x17:
+		return "#REF!"
+	case 0x1D:
+		return "#NAME?"
+	case 0x24:
+		return "#NUM!"
+	}
+	return "#N/A"
+}
+
+func (s *xlsSource) Sheets() []string {
+	names := make([]string, len(s.sheets))
+	for i, sheet := range s.sheets {
+		names[i] = sheet.name
+	}
+	return names
+}
+
+func (s *xlsSource) Date1904() bool { return s.date1904 }
+
+func (s *xlsSource) Close() error { return nil }
+
+func (s *xlsSource) sheet(name string) (*xlsSheet, error) {
+	for _, sheet := range s.sheets {
+		if sheet.name == name {
+			return sheet, s.loadSheet(sheet)
+		}
+	}
+	return nil, fmt.Errorf("лист %s не найден", name)
+}
+
+func (s *xlsSource) CellType(sheet string, col, row int) excelize.CellType {
+	sh, err := s.sheet(sheet)
+	if err != nil || row < 1 || row > len(sh.rows) || col < 1 || col > len(sh.rows[row-1]) {
+		return excelize.CellTypeUnset
+	}
+	return sh.rows[row-1][col-1].typ
+}
+
+func (s *xlsSource) Rows(sheet string) (rowIterator, error) {
+	sh, err := s.sheet(sheet)
+	if err != nil {
+		return nil, err
+	}
+	return &xlsRows{sheet: sh, row: -1}, nil
+}
+
+// xlsRows перебирает строки прочитанного листа, включая пустые
+type xlsRows struct {
+	sheet *xlsSheet
+	row   int
+}
+
+func (r *xlsRows) Next() bool {
+	r.row++
+	return r.row < len(r.sheet.rows)
+}
+
+func (r *xlsRows) Columns() ([]string, error) {
+	cells := r.sheet.rows[r.row]
+	// пустые ячейки в конце строки не возвращаются, как и у excelize
+	for len(cells) > 0 && cells[len(cells)-1].value == "" {
+		cells = cells[:len(cells)-1]
+	}
+	values := make([]string, len(cells))
+	for i, cell := range cells {
+		values[i] = cell.value
+	}
+	return values, nil
+}
+
+func (r *xlsRows) Height() float64 { return r.sheet.heights[r.row] }
+
+func (r *xlsRows) Error() error { return nil }
+
+func (r *xlsRows) Close() error { return nil }
+
+// biffCursor последовательно читает данные записи, продолженной записями CONTINUE
+type biffCursor struct {
+	segs [][]byte
+	seg  int
+	pos  int
+}
+
+func (c *biffCursor) atSegmentEnd() bool {
+	return c.pos >= len(c.segs[c.seg])
+}
+
+// nextSegment переходит к следующей записи CONTINUE
+func (c *biffCursor) nextSegment() error {
+	if c.seg+1 >= len(c.segs) {
+		return io.ErrUnexpectedEOF
+	}
+	c.seg++
+	c.pos = 0
+	return nil
+}
+
+func (c *biffCursor) bytes(n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		if c.atSegmentEnd() {
+			if err := c.nextSegment(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		k := min(n-len(out), len(c.segs[c.seg])-c.pos)
+		out = append(out, c.segs[c.seg][c.pos:c.pos+k]...)
+		c.pos += k
+	}
+	return out, nil
+}
+
+func (c *biffCursor) uint(n int) (int, error) {
+	b, err := c.bytes(n)
+	if err != nil {
+		return 0, err
+	}
+	v := 0
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | int(b[i])
+	}
+	return v, nil
+}
+
+// chars читает cch символов строки. На границе записи CONTINUE
+// байт флагов повторяется и может сменить разрядность символов.
+func (c *biffCursor) chars(cch int, highByte bool) (string, error) {
+	units := make([]uint16, 0, cch)
+	for len(units) < cch {
+		if c.atSegmentEnd() {
+			if err := c.nextSegment(); err != nil {
+				return "", err
+			}
+			flags, err := c.uint(1)
+			if err != nil {
+				return "", err
+			}
+			highByte = flags&0x01 != 0
+			continue
+		}
+		if highByte {
+			// символ может разрываться границей записи: младший байт остается
+			// в конце сегмента, старший следует за байтом флагов продолжения
+			lo := uint16(c.segs[c.seg][c.pos])
+			c.pos++
+			if c.atSegmentEnd() {
+				if err := c.nextSegment(); err != nil {
+					return "", err
+				}
+				if _, err := c.uint(1); err != nil {
+					return "", err
+				}
+			}
+			hi, err := c.uint(1)
+			if err != nil {
+				return "", err
+			}
+			units = append(units, lo|uint16(hi)<<8)
+		} else {
+			units = append(units, uint16(c.segs[c.seg][c.pos]))
+			c.pos++
+		}
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// unicodeString читает строку XLUnicodeString (lenSize = 2)
+// или ShortXLUnicodeString (lenSize = 1)
+func (c *biffCursor) unicodeString(lenSize int) (string, error) {
+	cch, err := c.uint(lenSize)
+	if err != nil {
+		return "", err
+	}
+	flags, err := c.uint(1)
+	if err != nil {
+		return "", err
+	}
+	return c.chars(cch, flags&0x01 != 0)
+}
+
+// richString читает строку таблицы SST с необязательными форматированием
+// и фонетическими данными, которые пропускаются
+func (c *biffCursor) richString() (string, error) {
+	cch, err := c.uint(2)
+	if err != nil {
+		return "", err
+	}
+	flags, err := c.uint(1)
+	if err != nil {
+		return "", err
+	}
+	runs, ext := 0, 0
+	if flags&0x08 != 0 {
+		if runs, err = c.uint(2); err != nil {
+			return "", err
+		}
+	}
+	if flags&0x04 != 0 {
+		if ext, err = c.uint(4); err != nil {
+			return "", err
+		}
+	}
+	str, err := c.chars(cch, flags&0x01 != 0)
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.bytes(runs*4 + ext); err != nil {
+		return "", err
+	}
+	return str, nil
+}
@@ -0,0 +1,306 @@
+package merger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// biffRec кодирует запись BIFF: тип, длина и данные
+func biffRec(typ uint16, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	return append(le(typ, uint16(len(body))), body...)
+}
+
+// le кодирует значения в порядке little-endian
+func le(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// biffText кодирует строку с длиной lenSize байт и байтом флагов:
+// латиница - по байту на символ, остальное - UTF-16
+func biffText(s string, lenSize int) []byte {
+	units := utf16.Encode([]rune(s))
+	var out []byte
+	if lenSize == 1 {
+		out = []byte{byte(len(units))}
+	} else {
+		out = le(uint16(len(units)))
+	}
+	compressed := true
+	for _, u := range units {
+		compressed = compressed && u < 0x100
+	}
+	if compressed {
+		out = append(out, 0)
+		for _, u := range units {
+			out = append(out, byte(u))
+		}
+		return out
+	}
+	return append(append(out, 1), le(units)...)
+}
+
+// rkInt кодирует целое число в формате RK
+func rkInt(v int32) uint32 { return uint32(v)<<2 | 0x02 }
+
+// cfbFile упаковывает поток Workbook в контейнер OLE с секторами по 512 байт
+func cfbFile(stream []byte) []byte {
+	const sector = 512
+	// потоки короче 4096 байт хранятся в мини-потоке: дополняем до обычного
+	stream = append(stream, make([]byte, max(0, 4096-len(stream)))...)
+	n := (len(stream) + sector - 1) / sector
+	stream = append(stream, make([]byte, n*sector-len(stream))...)
+	dirSector, fatSector := n, n+1
+
+	fat := make([]uint32, sector/4)
+	for i := range fat {
+		fat[i] = 0xFFFFFFFF // свободный сектор
+	}
+	for i := 0; i < n-1; i++ {
+		fat[i] = uint32(i + 1)
+	}
+	fat[n-1] = 0xFFFFFFFE       // конец цепочки
+	fat[dirSector] = 0xFFFFFFFE // каталог
+	fat[fatSector] = 0xFFFFFFFD // сектор FAT
+
+	entry := func(name string, typ byte, child, start uint32, size uint64) []byte {
+		var nameBuf [64]byte
+		nameLen := 0
+		if name != "" {
+			encoded := le(append(utf16.Encode([]rune(name)), 0))
+			nameLen = copy(nameBuf[:], encoded)
+		}
+		return bytes.Join([][]byte{
+			nameBuf[:], le(uint16(nameLen), typ, byte(1), uint32(0xFFFFFFFF), uint32(0xFFFFFFFF), child),
+			make([]byte, 36), le(start, size),
+		}, nil)
+	}
+	dir := bytes.Join([][]byte{
+		entry("Root Entry", 5, 1, 0xFFFFFFFE, 0),
+		entry("Workbook", 2, 0xFFFFFFFF, 0, uint64(len(stream))),
+		entry("", 0, 0xFFFFFFFF, 0, 0),
+		entry("", 0, 0xFFFFFFFF, 0, 0),
+	}, nil)
+
+	header := bytes.Join([][]byte{
+		{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}, make([]byte, 16),
+		le(uint16(0x3E), uint16(3), uint16(0xFFFE), uint16(9), uint16(6)), make([]byte, 6),
+		le(uint32(0), uint32(1), uint32(dirSector), uint32(0), uint32(4096),
+			uint32(0xFFFFFFFE), uint32(0), uint32(0xFFFFFFFE), uint32(0), uint32(fatSector)),
+	}, nil)
+	for len(header) < sector {
+		header = append(header, le(uint32(0xFFFFFFFF))...)
+	}
+	return bytes.Join([][]byte{header, stream, dir, le(fat)}, nil)
+}
+
+// xlsWorkbook собирает книгу BIFF8 с одним листом "Данные": общие строки
+// с записью CONTINUE внутри символа UTF-16, даты, RK, MULRK, формула
+// со строковым результатом и вложенная диаграмма
+func xlsWorkbook() []byte {
+	bof := func(typ uint16) []byte { return biffRec(biffBOF, le(uint16(biffVersion8), typ, make([]byte, 12))) }
+	eof := biffRec(biffEOF)
+
+	globals := bytes.Join([][]byte{
+		bof(0x0005),
+		biffRec(biffDateMode, le(uint16(0))),
+		biffRec(biffFormat, le(uint16(164)), biffText("dd.mm.yyyy hh:mm", 2)),
+	}, nil)
+	// XF 0 - общий формат, 1 - встроенная дата, 2 - пользовательская дата, 3 - число
+	for _, numFmt := range []uint16{0, 14, 164, 4} {
+		globals = append(globals, biffRec(biffXF, le(uint16(0), numFmt), make([]byte, 16))...)
+	}
+
+	sst := []string{"Клиент", "Дата", "Сумма", "Флаг", "Регион", "Москва", "Казань и область"}
+	var body []byte
+	for _, s := range sst {
+		body = append(body, biffText(s, 2)...)
+	}
+	// граница CONTINUE делит третий символ последней строки между байтами
+	last := biffText(sst[len(sst)-1], 2)
+	split := len(body) - len(last) + 3 + 2*2 + 1
+	globals = append(globals, biffRec(biffSST, le(uint32(len(sst)), uint32(len(sst))), body[:split])...)
+	globals = append(globals, biffRec(biffContinue, []byte{1}, body[split:])...)
+
+	name := biffText("Данные", 1)
+	boundSheet := 4 + 6 + len(name)
+	globals = append(globals, biffRec(biffBoundSheet, le(uint32(len(globals)+boundSheet+len(eof)), byte(0), byte(0)), name)...)
+	globals = append(globals, eof...)
+
+	cell := func(row, col, xf uint16) []byte { return le(row, col, xf) }
+	sheet := bytes.Join([][]byte{
+		bof(0x0010),
+		// строка 1 с высотой 30 пт, заданной вручную
+		biffRec(biffRow, le(uint16(0), uint16(0), uint16(5), uint16(600), uint16(0), uint16(0), uint16(0x40), uint16(0x0F))),
+		biffRec(biffLabelSST, cell(0, 0, 0), le(uint32(0))),
+		biffRec(biffLabelSST, cell(0, 1, 0), le(uint32(1))),
+		biffRec(biffLabelSST, cell(0, 2, 0), le(uint32(2))),
+		biffRec(biffLabelSST, cell(0, 3, 0), le(uint32(3))),
+		biffRec(biffLabelSST, cell(0, 4, 0), le(uint32(4))),
+
+		biffRec(biffLabel, cell(1, 0, 0), biffText("X1 Ёлка", 2)),
+		biffRec(biffRK, cell(1, 1, 1), le(rkInt(45300))),
+		biffRec(biffNumber, cell(1, 2, 3), le(1234.5)),
+		biffRec(biffBoolErr, cell(1, 3, 0), []byte{1, 0}),
+		biffRec(biffLabelSST, cell(1, 4, 0), le(uint32(5))),
+
+		biffRec(biffFormula, cell(2, 0, 0), []byte{0, 0, 0, 0, 0, 0, 0xFF, 0xFF}, make([]byte, 6)),
+		biffRec(biffString, biffText("Формула", 2)),
+		biffRec(biffNumber, cell(2, 1, 2), le(45301.5)),
+		// MULRK: 7 и 123,45 (целое 12345 с делением на 100)
+		biffRec(biffMulRK, le(uint16(2), uint16(2), uint16(3), rkInt(7), uint16(0), rkInt(12345)|0x01, uint16(3))),
+		biffRec(biffLabelSST, cell(2, 4, 0), le(uint32(6))),
+
+		// диаграмма с собственными BOF и EOF не дает ячеек листа
+		bof(0x0020),
+		biffRec(biffNumber, cell(99, 0, 0), le(1.0)),
+		eof,
+
+		biffRec(biffLabel, cell(4, 0, 0), biffText("после пустой", 2)),
+		biffRec(biffBoolErr, cell(4, 1, 0), []byte{0x07, 1}),
+		biffRec(biffRK, cell(4, 2, 3), le(rkInt(150)|0x01)),
+		eof,
+	}, nil)
+	return cfbFile(append(globals, sheet...))
+}
+
+func TestXLSSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.xls")
+	if err := os.WriteFile(path, xlsWorkbook(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := openXLSSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	if sheets := src.Sheets(); !reflect.DeepEqual(sheets, []string{"Данные"}) {
+		t.Fatalf("листы = %v", sheets)
+	}
+	if src.Date1904() {
+		t.Error("ожидается система дат 1900")
+	}
+
+	rows, err := src.Rows("Данные")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	var heights []float64
+	for rows.Next() {
+		cols, err := rows.Columns()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, cols)
+		heights = append(heights, rows.Height())
+	}
+	want := [][]string{
+		{"Клиент", "Дата", "Сумма", "Флаг", "Регион"},
+		{"X1 Ёлка", "45300", "1234.5", "1", "Москва"},
+		{"Формула", "45301.5", "7", "123.45", "Казань и область"},
+		{},
+		{"после пустой", "#DIV/0!", "1.5"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("строки = %q\nожидается %q", got, want)
+	}
+	if heights[0] != 30 || heights[1] != 0 {
+		t.Errorf("высоты строк = %v", heights)
+	}
+
+	types := []struct {
+		col, row int
+		want     excelize.CellType
+	}{
+		{1, 1, excelize.CellTypeSharedString},
+		{1, 2, excelize.CellTypeInlineString},
+		{2, 2, excelize.CellTypeDate}, // встроенный формат 14
+		{3, 2, excelize.CellTypeNumber},
+		{4, 2, excelize.CellTypeBool},
+		{1, 3, excelize.CellTypeInlineString}, // результат формулы
+		{2, 3, excelize.CellTypeDate},         // пользовательский формат даты
+		{3, 3, excelize.CellTypeNumber},
+		{2, 5, excelize.CellTypeError},
+		{1, 4, excelize.CellTypeUnset},
+		{9, 9, excelize.CellTypeUnset},
+	}
+	for _, tt := range types {
+		if typ := src.CellType("Данные", tt.col, tt.row); typ != tt.want {
+			t.Errorf("тип ячейки (%d, %d) = %v, ожидается %v", tt.col, tt.row, typ, tt.want)
+		}
+	}
+}
+
+func TestBiffCursorChars(t *testing.T) {
+	utf := func(s string) []byte { return le(utf16.Encode([]rune(s))) }
+	tests := []struct {
+		name     string
+		segs     [][]byte
+		cch      int
+		highByte bool
+		want     string
+	}{
+		{"однобайтовые", [][]byte{[]byte("abc")}, 3, false, "abc"},
+		{"UTF-16", [][]byte{utf("Юг")}, 2, true, "Юг"},
+		{
+			"смена разрядности на границе",
+			[][]byte{[]byte("ab"), append([]byte{1}, utf("вг")...)},
+			4, false, "abвг",
+		},
+		{
+			"UTF-16 сжимается на границе",
+			[][]byte{utf("аб"), append([]byte{0}, "cd"...)},
+			4, true, "абcd",
+		},
+		{
+			"символ разделен границей",
+			[][]byte{utf("Ка")[:3], append([]byte{1}, utf("Ка")[3:]...)},
+			2, true, "Ка",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &biffCursor{segs: tt.segs}
+			got, err := c.chars(tt.cch, tt.highByte)
+			if err != nil || got != tt.want {
+				t.Errorf("chars = %q, %v; ожидается %q", got, err, tt.want)
+			}
+		})
+	}
+
+	c := &biffCursor{segs: [][]byte{utf("Ка")[:3]}}
+	if _, err := c.chars(2, true); err == nil {
+		t.Error("ожидается ошибка обрыва строки")
+	}
+}
+
+func TestDecodeRK(t *testing.T) {
+	tests := []struct {
+		rk   uint32
+		want float64
+	}{
+		{rkInt(45300), 45300},
+		{rkInt(-5), -5},
+		{rkInt(12345) | 0x01, 123.45},
+		{0x3FF00000, 1}, // старшие 30 бит числа 1.0
+		{0x3FF00000 | 0x01, 0.01},
+	}
+	for _, tt := range tests {
+		if got := decodeRK(tt.rk); got != tt.want {
+			t.Errorf("decodeRK(%#x) = %v, ожидается %v", tt.rk, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package merger
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// OutputSheet описывает лист выходного файла
+type OutputSheet struct {
+	Name         string                 // Имя листа
+	StreamWriter *excelize.StreamWriter // Потоковый писатель Excel
+	RowCounter   int64                  // Счетчик строк на листе
+}
+
+// xlsxWriter пишет результат в XLSX на основе шаблона через excelize.StreamWriter
+type xlsxWriter struct {
+	sm                *StreamMerger
+	w                 io.Writer
+	file              *excelize.File // Текущий выходной файл
+	sheets            []*OutputSheet // Листы текущего выходного файла
+	templateSheets    []string       // Листы шаблона в текущем выходном файле (удаляются при сохранении)
+	templateDataSheet string         // Имя листа данных шаблона в текущем выходном файле
+	part              int            // Номер части (0 - отдельная книга итогов)
+	completed         bool           // Листы итогов части уже добавлены
+}
+
+// NewPart создает новый выходной файл на основе шаблона
+// Возвращает ошибку если:
+// - не удалось открыть шаблон
+// - шаблон не содержит листов
+// - не удалось создать StreamWriter
+func (x *xlsxWriter) NewPart(w io.Writer) error {
+	if err := x.open(w); err != nil {
+		return err
+	}
+	x.part = x.sm.PartCounter
+	if !x.sm.Cfg.SheetPerSource {
+		if _, err := x.outputSheet(""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// open открывает книгу шаблона, которая сохраняется в w, и готовит ее листы к удалению
+func (x *xlsxWriter) open(w io.Writer) error {
+	sm := x.sm
+	x.w = w
+	x.sheets = nil
+	x.completed = false
+
+	var err error
+	x.file, err = excelize.OpenFile(sm.Cfg.TemplatePath)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия шаблона: %v", err)
+	}
+	// Проверка наличия листов в шаблоне
+	sheetList := x.file.GetSheetList()
+	if len(sheetList) == 0 {
+		return fmt.Errorf("шаблон пустой, нет листов")
+	}
+
+	// Листы шаблона переименовываются, чтобы не конфликтовать с листами результата,
+	// и удаляются при сохранении файла
+	x.templateSheets = nil
+	for i, name := range sheetList {
+		tmpName := fmt.Sprintf("__template%d", i+1)
+		if err := x.file.SetSheetName(name, tmpName); err != nil {
+			return fmt.Errorf("ошибка подготовки листа шаблона %s: %v", name, err)
+		}
+		if name == sm.TemplateSheet {
+			x.templateDataSheet = tmpName
+		}
+		x.templateSheets = append(x.templateSheets, tmpName)
+	}
+	return nil
+}
+
+// outputSheet возвращает лист текущего выходного файла для строк исходного листа source.
+// В режиме SheetPerSource для каждого исходного листа создается одноименный лист,
+// иначе все строки пишутся в общий лист OutputSheet.
+// Лист создается при первом обращении: копируется ширина колонок шаблона и пишутся заголовки.
+func (x *xlsxWriter) outputSheet(source string) (*OutputSheet, error) {
+	sm := x.sm
+	name := sm.Cfg.OutputSheet
+	if sm.Cfg.SheetPerSource && source != "" {
+		name = sanitizeSheetName(source)
+	}
+	for _, sheet := range x.sheets {
+		if sheet.Name == name {
+			return sheet, nil
+		}
+	}
+
+	if _, err := x.file.NewSheet(name); err != nil {
+		return nil, fmt.Errorf("ошибка создания листа %s: %v", name, err)
+	}
+
+	// Копируем ширину колонок из листа данных шаблона
+	for colIdx := 1; colIdx <= len(sm.Headers); colIdx++ {
+		src := sm.templateColumn(colIdx - 1)
+		if src < 0 {
+			continue
+		}
+		colName, _ := excelize.ColumnNumberToName(colIdx)
+		srcName, _ := excelize.ColumnNumberToName(src + 1)
+		width, err := x.file.GetColWidth(x.templateDataSheet, srcName)
+		if err == nil {
+			x.file.SetColWidth(name, colName, colName, width)
+		}
+	}
+
+	// Инициализация потокового писателя
+	sw, err := x.file.NewStreamWriter(name)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания StreamWriter: %v", err)
+	}
+	sheet := &OutputSheet{Name: name, StreamWriter: sw}
+
+	// Запись заголовков если требуется
+	if sm.Cfg.HasHeaders && len(sm.Headers) > 0 {
+		headerRow := make([]interface{}, len(sm.Headers))
+		for i, h := range sm.Headers {
+			headerRow[i] = excelize.Cell{
+				Value:   h,
+				StyleID: sm.HeaderStyles[i],
+			}
+		}
+
+		cell := fmt.Sprintf("A%d", sheet.RowCounter+1)
+		if err := sw.SetRow(cell, headerRow, excelize.RowOpts{Height: sm.HeightHeader}); err != nil {
+			return nil, fmt.Errorf("ошибка записи заголовка: %v", err)
+		}
+		sheet.RowCounter++
+	}
+
+	x.sheets = append(x.sheets, sheet)
+	return sheet, nil
+}
+
+func (x *xlsxWriter) RowCount(sheet string) (int64, error) {
+	s, err := x.outputSheet(sheet)
+	if err != nil {
+		return 0, err
+	}
+	return s.RowCounter, nil
+}
+
+func (x *xlsxWriter) WriteRow(sheet string, cells []interface{}, height float64) error {
+	s, err := x.outputSheet(sheet)
+	if err != nil {
+		return err
+	}
+	cell := fmt.Sprintf("A%d", s.RowCounter+1)
+	if err := s.StreamWriter.SetRow(cell, cells, excelize.RowOpts{Height: height}); err != nil {
+		return err
+	}
+	s.RowCounter++
+	return nil
+}
+
+// AbortPart закрывает текущий выходной файл без сохранения
+func (x *xlsxWriter) AbortPart() {
+	x.file.Close()
+}
+
+// complete дописывает пустой лист результата, если строк не было
+func (x *xlsxWriter) complete() error {
+	x.completed = true
+	// В файле должен остаться хотя бы один лист
+	if len(x.sheets) == 0 {
+		if _, err := x.outputSheet(""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClosePart завершает запись листов, удаляет листы шаблона
+// и сохраняет текущий выходной файл
+func (x *xlsxWriter) ClosePart() error {
+	defer x.file.Close()
+
+	if !x.completed {
+		if err := x.complete(); err != nil {
+			return err
+		}
+	}
+	// Итоги по всем строкам результата, если они не пишутся отдельной книгой:
+	// части закрываются после записи последней строки (см. holdPart)
+	if s := x.sm.summary; s != nil && s.path == "" && x.part > 0 {
+		if err := x.writeSummary(s); err != nil {
+			return err
+		}
+	}
+	// Итоги по входным файлам - в первой части
+	if x.part == 1 && x.sm.Cfg.Manifest {
+		if err := x.writeManifest(); err != nil {
+			return err
+		}
+	}
+	for _, sheet := range x.sheets {
+		if err := sheet.StreamWriter.Flush(); err != nil {
+			return fmt.Errorf("ошибка финального flush: %w", err)
+		}
+	}
+	for _, name := range x.templateSheets {
+		if err := x.file.DeleteSheet(name); err != nil {
+			return fmt.Errorf("ошибка удаления листа шаблона: %w", err)
+		}
+	}
+	x.file.SetActiveSheet(0)
+
+	if _, err := x.file.WriteTo(x.w); err != nil {
+		return fmt.Errorf("ошибка сохранения файла: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+package xlsxmerger
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+)
+
+// Option изменяет параметры слияния
+type Option func(*Merger) error
+
+// WithConfig заменяет все параметры слияния копией cfg.
+// Опции, переданные после нее, изменяют эту копию.
+// Порядок входных файлов из cfg считается заданным явно, только если он отличается
+// от порядка по умолчанию; иначе источники WithSource обрабатываются в порядке передачи.
+func WithConfig(cfg *Config) Option {
+	return func(m *Merger) error {
+		if cfg == nil {
+			return fmt.Errorf("конфигурация не задана")
+		}
+		c := *cfg
+		m.cfg = &c
+		m.orderSet = (c.Order != "" && c.Order != config.Default().Order) || c.OrderDesc || c.OrderFile != ""
+		return nil
+	}
+}
+
+// WithInputDir задает папку с входными файлами
+func WithInputDir(dir string) Option {
+	return func(m *Merger) error {
+		m.cfg.InputDir = dir
+		return nil
+	}
+}
+
+// WithSource добавляет входной файл из r. Имя определяет формат по расширению
+// (.xlsx, .xls, .csv и т.д.) и используется в колонке SourceFile и в итогах.
+// Данные читаются в начале слияния.
+func WithSource(name string, r io.Reader) Option {
+	return func(m *Merger) error {
+		if r == nil {
+			return fmt.Errorf("источник %q не задан", name)
+		}
+		m.sources = append(m.sources, source{name: name, r: r})
+		return nil
+	}
+}
+
+// WithOutput задает путь результата; части называются <out>_partN.<ext>.
+// С WithSink путь используется только для имен частей.
+func WithOutput(path string) Option {
+	return func(m *Merger) error {
+		m.cfg.OutputPath = path
+		return nil
+	}
+}
+
+// WithSink направляет части результата и файл отклоненных строк в приемники,
+// создаваемые sink, вместо файлов на диске
+func WithSink(sink SinkFunc) Option {
+	return func(m *Merger) error {
+		m.sink = sink
+		return nil
+	}
+}
+
+// WithProgress задает обработчик событий хода слияния: начало и конец чтения файлов,
+// начало и сохранение частей, периодические отчеты о записанных строках (не чаще 4 раз в секунду).
+// Вызовы последовательны, но выполняются в горутинах слияния - обработчик не должен блокировать.
+func WithProgress(fn func(ProgressEvent)) Option {
+	return func(m *Merger) error {
+		m.progress = fn
+		return nil
+	}
+}
+
+// WithFormat задает формат результата: FormatXLSX, FormatCSV, FormatJSONL или FormatParquet
+func WithFormat(format string) Option {
+	return func(m *Merger) error {
+		m.cfg.Format = format
+		return nil
+	}
+}
+
+// WithTemplate задает файл шаблона
+func WithTemplate(path string) Option {
+	return func(m *Merger) error {
+		m.cfg.TemplatePath = path
+		return nil
+	}
+}
+
+// WithHeaders сообщает, что первая строка входных листов - заголовки
+func WithHeaders(hasHeaders bool) Option {
+	return func(m *Merger) error {
+		m.cfg.HasHeaders = hasHeaders
+		return nil
+	}
+}
+
+// WithAlignHeaders включает сопоставление колонок по заголовкам шаблона
+// с синонимами aliases (заголовок шаблона -> варианты во входных файлах)
+func WithAlignHeaders(aliases map[string][]string) Option {
+	return func(m *Merger) error {
+		m.cfg.AlignHeaders = true
+		m.cfg.HeaderAliases = aliases
+		return nil
+	}
+}
+
+// WithUnknownColumns задает обработку колонок, отсутствующих в шаблоне:
+// UnknownColumnsReport или UnknownColumnsAppend
+func WithUnknownColumns(mode string) Option {
+	return func(m *Merger) error {
+		m.cfg.UnknownColumns = mode
+		return nil
+	}
+}
+
+// WithSourceColumn добавляет колонку SourceFile с именем входного файла
+// (то же, что вычисляемая колонка с источником DerivedFile)
+func WithSourceColumn(add bool) Option {
+	return func(m *Merger) error {
+		m.cfg.AddSourceFile = add
+		return nil
+	}
+}
+
+// WithColumns добавляет вычисляемые колонки результата
+func WithColumns(columns ...DerivedColumn) Option {
+	return func(m *Merger) error {
+		m.cfg.Columns = append(m.cfg.Columns, columns...)
+		return nil
+	}
+}
+
+// WithColumnSpecs добавляет вычисляемые колонки в формате ключа -column
+// ("Лист:sheet", "НДС:after=Сумма,expr=Сумма * 0.2")
+func WithColumnSpecs(specs ...string) Option {
+	return func(m *Merger) error {
+		for _, spec := range specs {
+			column, err := config.ParseDerivedColumn(spec)
+			if err != nil {
+				return err
+			}
+			m.cfg.Columns = append(m.cfg.Columns, column)
+		}
+		return nil
+	}
+}
+
+// WithMaxRows задает максимальное число строк в одной части результата
+func WithMaxRows(n int64) Option {
+	return func(m *Merger) error {
+		if n < 0 {
+			return fmt.Errorf("максимальное число строк не может быть отрицательным: %d", n)
+		}
+		m.cfg.MaxRowPerFile = n
+		return nil
+	}
+}
+
+// WithSampleRows задает число строк шаблона для анализа стилей
+func WithSampleRows(n int) Option {
+	return func(m *Merger) error {
+		m.cfg.SampleRows = n
+		return nil
+	}
+}
+
+// WithSheet выбирает входной лист по имени (без учета регистра)
+func WithSheet(name string) Option {
+	return func(m *Merger) error {
+		m.cfg.SheetName = name
+		return nil
+	}
+}
+
+// WithSheetRegex выбирает входные листы по регулярному выражению
+func WithSheetRegex(expr string) Option {
+	return func(m *Merger) error {
+		m.cfg.SheetRegex = expr
+		return nil
+	}
+}
+
+// WithSheetIndex выбирает входной лист по номеру (с 1)
+func WithSheetIndex(index int) Option {
+	return func(m *Merger) error {
+		m.cfg.SheetIndex = index
+		return nil
+	}
+}
+
+// WithAllSheets включает чтение всех листов входных файлов
+func WithAllSheets() Option {
+	return func(m *Merger) error {
+		m.cfg.AllSheets = true
+		return nil
+	}
+}
+
+// WithSplitSheets создает отдельный лист результата для каждого имени входного листа
+func WithSplitSheets() Option {
+	return func(m *Merger) error {
+		m.cfg.SheetPerSource = true
+		return nil
+	}
+}
+
+// WithOutputSheet задает имя листа результата
+func WithOutputSheet(name string) Option {
+	return func(m *Merger) error {
+		m.cfg.OutputSheet = name
+		return nil
+	}
+}
+
+// WithOrder задает порядок входных файлов: OrderSize, OrderName, OrderNatural или OrderModTime
+func WithOrder(order string, desc bool) Option {
+	return func(m *Merger) error {
+		m.cfg.Order = order
+		m.cfg.OrderDesc = desc
+		m.orderSet = true
+		return nil
+	}
+}
+
+// WithOrderFile задает порядок входных файлов списком из файла
+func WithOrderFile(path string) Option {
+	return func(m *Merger) error {
+		m.cfg.Order = OrderList
+		m.cfg.OrderFile = path
+		m.orderSet = true
+		return nil
+	}
+}
+
+// WithMergeMode задает порядок строк результата: MergeOrdered (файлы по порядку),
+// MergeContiguous (файлы целиком в порядке готовности) или MergeInterleaved
+// (строки файлов вперемешку по мере чтения)
+func WithMergeMode(mode string) Option {
+	return func(m *Merger) error {
+		m.cfg.MergeMode = mode
+		return nil
+	}
+}
+
+// WithRecursive включает поиск файлов во вложенных папках
+func WithRecursive() Option {
+	return func(m *Merger) error {
+		m.cfg.Recursive = true
+		return nil
+	}
+}
+
+// WithInclude задает маски включаемых файлов ("2024/**/*.xlsx")
+func WithInclude(patterns ...string) Option {
+	return func(m *Merger) error {
+		m.cfg.Include = append(m.cfg.Include, patterns...)
+		return nil
+	}
+}
+
+// WithExclude задает маски исключаемых файлов
+func WithExclude(patterns ...string) Option {
+	return func(m *Merger) error {
+		m.cfg.Exclude = append(m.cfg.Exclude, patterns...)
+		return nil
+	}
+}
+
+// WithCSV задает разделитель, символ кавычек и кодировку входных CSV файлов.
+// Пустые значения оставляют параметры по умолчанию.
+func WithCSV(delimiter, quote, encoding string) Option {
+	return func(m *Merger) error {
+		if delimiter != "" {
+			m.cfg.CSVDelimiter = delimiter
+		}
+		if quote != "" {
+			m.cfg.CSVQuote = quote
+		}
+		if encoding != "" {
+			m.cfg.CSVEncoding = encoding
+		}
+		return nil
+	}
+}
+
+// WithValidation включает проверку типов значений и добавляет правила проверки колонок
+func WithValidation(rules ...ValidationRule) Option {
+	return func(m *Merger) error {
+		m.cfg.Validate = true
+		m.cfg.Rules = append(m.cfg.Rules, rules...)
+		return nil
+	}
+}
+
+// WithRules добавляет правила проверки колонок в формате ключа -rule
+// ("Сумма:required,min=0")
+func WithRules(specs ...string) Option {
+	return func(m *Merger) error {
+		for _, spec := range specs {
+			rule, err := config.ParseValidationRule(spec)
+			if err != nil {
+				return err
+			}
+			m.cfg.Rules = append(m.cfg.Rules, rule)
+		}
+		return nil
+	}
+}
+
+// WithFilter задает выражение фильтра строк: в результат попадают только строки,
+// для которых оно истинно ("\"Регион\" = 'Москва' AND Сумма > 0")
+func WithFilter(expr string) Option {
+	return func(m *Merger) error {
+		m.cfg.Filter = expr
+		return nil
+	}
+}
+
+// WithDedup включает удаление повторяющихся строк всех входных файлов.
+// keep - DedupFirst или DedupLast; keys - колонки ключа (заголовки шаблона или буквы),
+// без них строки сравниваются целиком.
+func WithDedup(keep string, keys ...string) Option {
+	return func(m *Merger) error {
+		m.cfg.Dedup = true
+		m.cfg.DedupKeep = keep
+		m.cfg.DedupKeys = append([]string(nil), keys...)
+		return nil
+	}
+}
+
+// WithSort упорядочивает строки результата по колонкам keys (заголовки шаблона или буквы,
+// с суффиксом ":desc" - по убыванию). Сортировка держит в памяти до mb мегабайт строк
+// (0 - значение по умолчанию), остальные сохраняет во временные файлы папки WithSpill.
+func WithSort(mb int, keys ...string) Option {
+	return func(m *Merger) error {
+		if mb < 0 {
+			return fmt.Errorf("память сортировки не может быть отрицательной: %d МБ", mb)
+		}
+		m.cfg.Sort = append([]string(nil), keys...)
+		if mb > 0 {
+			m.cfg.SortMB = mb
+		}
+		return nil
+	}
+}
+
+// WithSummary добавляет лист итогов: строки группируются по колонкам groupBy,
+// для групп считаются итоги aggs вида "Сумма:sum", "Клиент:distinct" или "count"
+// (без aggs - число строк) по всем строкам результата. Итоги пишутся листом
+// в каждую книгу результата или в отдельную книгу WithSummaryFile.
+func WithSummary(groupBy []string, aggs ...string) Option {
+	return func(m *Merger) error {
+		for _, spec := range aggs {
+			if _, _, err := config.Aggregate(spec); err != nil {
+				return err
+			}
+		}
+		m.cfg.GroupBy = append([]string(nil), groupBy...)
+		m.cfg.Aggregates = append([]string(nil), aggs...)
+		return nil
+	}
+}
+
+// WithSummaryFile задает отдельную книгу итогов WithSummary по всем строкам результата
+func WithSummaryFile(path string) Option {
+	return func(m *Merger) error {
+		m.cfg.SummaryPath = path
+		return nil
+	}
+}
+
+// WithManifest добавляет в первую часть результата лист Manifest с итогами по входным файлам
+// (только для формата xlsx). Первая часть сохраняется после записи всех строк.
+func WithManifest() Option {
+	return func(m *Merger) error {
+		m.cfg.Manifest = true
+		return nil
+	}
+}
+
+// WithOnError задает обработку ошибок чтения входных файлов: OnErrorFail (по умолчанию) прерывает
+// слияние, OnErrorSkip пропускает файл - он попадает в Result.FailedFiles, а Result.Partial
+// сообщает о частичном результате
+func WithOnError(policy string) Option {
+	return func(m *Merger) error {
+		m.cfg.OnError = policy
+		return nil
+	}
+}
+
+// WithTimeout ограничивает время слияния; по истечении слияние прерывается как при отмене контекста
+func WithTimeout(d time.Duration) Option {
+	return func(m *Merger) error {
+		if d < 0 {
+			return fmt.Errorf("ограничение времени не может быть отрицательным: %s", d)
+		}
+		m.cfg.Timeout = config.Duration(d)
+		return nil
+	}
+}
+
+// WithRejects задает файл отклоненных строк (.xlsx или .csv)
+func WithRejects(path string) Option {
+	return func(m *Merger) error {
+		m.cfg.RejectsPath = path
+		return nil
+	}
+}
+
+// WithWorkers задает число одновременно читаемых входных файлов (0 - по числу CPU)
+func WithWorkers(n int) Option {
+	return func(m *Merger) error {
+		if n < 0 {
+			return fmt.Errorf("число читателей не может быть отрицательным: %d", n)
+		}
+		m.cfg.Workers = n
+		return nil
+	}
+}
+
+// WithBuffer ограничивает строки, прочитанные, но еще не записанные в результат:
+// не более rows строк и не более mb мегабайт (0 - без ограничения).
+// Читатели, опередившие запись, ждут освобождения места.
+func WithBuffer(rows, mb int) Option {
+	return func(m *Merger) error {
+		if rows < 0 || mb < 0 {
+			return fmt.Errorf("ограничения буфера не могут быть отрицательными: %d строк, %d МБ", rows, mb)
+		}
+		m.cfg.BufferRows = rows
+		m.cfg.BufferMB = mb
+		return nil
+	}
+}
+
+// WithSpill включает вытеснение строк файлов, опередивших запись, во временные файлы
+// в папке dir ("" - системная временная папка), когда буфер WithBuffer заполнен.
+// Читатели при этом не ждут записи, а память остается ограниченной при любом числе файлов.
+func WithSpill(dir string) Option {
+	return func(m *Merger) error {
+		m.cfg.Spill = true
+		m.cfg.SpillDir = dir
+		return nil
+	}
+}
@@ -0,0 +1,357 @@
+// Package xlsxmerger - публичный API слияния XLSX, XLS и CSV файлов в один результат
+// (XLSX на основе шаблона, CSV, JSON Lines или Parquet) с делением на части.
+//
+// Пример:
+//
+//	res, err := xlsxmerger.Merge(ctx,
+//		xlsxmerger.WithInputDir("./input"),
+//		xlsxmerger.WithOutput("./output/merged.xlsx"),
+//		xlsxmerger.WithHeaders(true),
+//	)
+//
+// Источники можно передать как io.Reader (WithSource), а части результата
+// получить в произвольные io.Writer (WithSink).
+package xlsxmerger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/ryabkov82/xlsx-merger/internal/merger"
+)
+
+// Config - полная конфигурация слияния (те же параметры, что у ключей командной строки)
+type Config = config.Config
+
+// ValidationRule - правило проверки значений колонки
+type ValidationRule = config.ValidationRule
+
+// DerivedColumn - вычисляемая колонка результата (см. WithColumns)
+type DerivedColumn = config.DerivedColumn
+
+// Источники значений вычисляемых колонок
+const (
+	DerivedFile    = config.DerivedFile
+	DerivedSheet   = config.DerivedSheet
+	DerivedRow     = config.DerivedRow
+	DerivedModTime = config.DerivedModTime
+	DerivedRegex   = config.DerivedRegex
+	DerivedExpr    = config.DerivedExpr
+)
+
+// Форматы результата
+const (
+	FormatXLSX    = config.FormatXLSX
+	FormatCSV     = config.FormatCSV
+	FormatJSONL   = config.FormatJSONL
+	FormatParquet = config.FormatParquet
+)
+
+// Порядок обработки входных файлов
+const (
+	OrderSize    = config.OrderSize
+	OrderName    = config.OrderName
+	OrderNatural = config.OrderNatural
+	OrderModTime = config.OrderModTime
+	OrderList    = config.OrderList
+)
+
+// Режимы слияния (см. WithMergeMode)
+const (
+	MergeOrdered     = config.MergeOrdered
+	MergeContiguous  = config.MergeContiguous
+	MergeInterleaved = config.MergeInterleaved
+)
+
+// Какая из повторяющихся строк остается (см. WithDedup)
+const (
+	DedupFirst = config.DedupFirst
+	DedupLast  = config.DedupLast
+)
+
+// Обработка ошибок чтения входных файлов (см. WithOnError)
+const (
+	OnErrorFail = config.OnErrorFail
+	OnErrorSkip = config.OnErrorSkip
+)
+
+// Обработка колонок, отсутствующих в шаблоне
+const (
+	UnknownColumnsAppend = config.UnknownColumnsAppend
+	UnknownColumnsReport = config.UnknownColumnsReport
+)
+
+// ConfigError - ошибка параметров слияния: опций, конфигурации или параметров,
+// не подходящих к входным файлам (проверяется errors.As)
+type ConfigError = config.Error
+
+// Виды ошибок Merge (проверяются errors.Is); прерывание возвращает ошибку контекста
+var (
+	ErrNoInputFiles = merger.ErrNoInputFiles // входные файлы не найдены
+	ErrTemplate     = merger.ErrTemplate     // шаблон не найден или не читается
+	ErrRead         = merger.ErrRead         // ошибка чтения входного файла
+	ErrWrite        = merger.ErrWrite        // ошибка записи результата
+)
+
+// ProgressEvent - событие хода слияния (см. WithProgress)
+type ProgressEvent = merger.ProgressEvent
+
+// Типы событий хода слияния
+const (
+	EventStart        = merger.EventStart
+	EventFileStarted  = merger.EventFileStarted
+	EventFileFinished = merger.EventFileFinished
+	EventPartStarted  = merger.EventPartStarted
+	EventPartSaved    = merger.EventPartSaved
+	EventRows         = merger.EventRows
+	EventFinished     = merger.EventFinished
+)
+
+// SinkFunc создает приемник для файла результата с указанным именем:
+// части результата (<out>_partN.<ext>), файл отклоненных строк и книга итогов.
+// Приемник закрывается после записи файла.
+type SinkFunc func(name string) (io.WriteCloser, error)
+
+// Result содержит итоги слияния
+type Result struct {
+	OutputFiles    []string         `json:"output_files,omitempty"`       // Имена созданных частей результата
+	InputFiles     []string         `json:"input_files,omitempty"`        // Входные файлы в порядке обработки
+	RowCount       int64            `json:"row_count"`                    // Количество записанных строк
+	RejectedRows   int64            `json:"rejected_rows,omitempty"`      // Количество отклоненных строк
+	RejectsFile    string           `json:"rejects_file,omitempty"`       // Файл отклоненных строк
+	SummaryFile    string           `json:"summary_file,omitempty"`       // Отдельная книга итогов
+	SpilledRows    int64            `json:"spilled_rows,omitempty"`       // Строки, вытесненные во временные файлы на диске
+	Duplicates     int64            `json:"duplicates_removed,omitempty"` // Удаленные повторяющиеся строки
+	FilteredRows   int64            `json:"filtered_rows,omitempty"`      // Строки, отброшенные фильтром
+	Filtered       []FilteredRows   `json:"filtered,omitempty"`           // Строки, отброшенные фильтром, по файлам
+	UnknownColumns []UnknownColumns `json:"unknown_columns,omitempty"`    // Колонки, отсутствующие в шаблоне
+	Files          []FileStats      `json:"files,omitempty"`              // Итоги по входным файлам
+	FailedFiles    []FailedFile     `json:"failed_files,omitempty"`       // Файлы, пропущенные из-за ошибок чтения
+	Partial        bool             `json:"partial,omitempty"`            // Результат сохранен, файлы FailedFiles пропущены
+	Cancelled      bool             `json:"-"`                            // Слияние прервано отменой контекста или по таймауту
+}
+
+// FilteredRows - число строк входного файла, отброшенных фильтром (см. WithFilter)
+type FilteredRows = merger.FilteredRows
+
+// FileStats - итоги обработки входного файла: размер, прочитанные листы, строки и ошибка
+type FileStats = merger.InputFileStats
+
+// FailedFile - входной файл, пропущенный из-за ошибки чтения (см. WithOnError)
+type FailedFile = merger.FailedFile
+
+// Состояния входного файла в FileStats
+const (
+	FileOK      = merger.FileOK
+	FileEmpty   = merger.FileEmpty
+	FileSkipped = merger.FileSkipped
+	FileError   = merger.FileError
+)
+
+// UnknownColumns - колонки входного файла, не сопоставленные с шаблоном
+type UnknownColumns struct {
+	File    string   `json:"file"`
+	Columns []string `json:"columns"`
+}
+
+// source - входной файл, переданный как io.Reader
+type source struct {
+	name string
+	r    io.Reader
+}
+
+// Merger выполняет слияние с заданными параметрами.
+// Один Merger можно использовать для нескольких слияний.
+type Merger struct {
+	cfg      *Config
+	sources  []source
+	sink     SinkFunc
+	progress func(ProgressEvent)
+	orderSet bool // порядок задан явно (для WithSource по умолчанию - порядок передачи)
+}
+
+// New создает Merger с параметрами по умолчанию, измененными опциями
+func New(opts ...Option) (*Merger, error) {
+	m := &Merger{cfg: config.Default()}
+	if err := m.apply(opts); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Merge выполняет слияние с параметрами по умолчанию, измененными опциями
+func Merge(ctx context.Context, opts ...Option) (*Result, error) {
+	m, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return m.Merge(ctx)
+}
+
+func (m *Merger) apply(opts []Option) error {
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return config.Invalid(err)
+		}
+	}
+	return nil
+}
+
+// clone копирует Merger, чтобы опции одного вызова Merge не влияли на следующие
+func (m *Merger) clone() *Merger {
+	c := *m
+	cfg := *m.cfg
+	cfg.Include = slices.Clone(cfg.Include)
+	cfg.Exclude = slices.Clone(cfg.Exclude)
+	cfg.IncludeRegex = slices.Clone(cfg.IncludeRegex)
+	cfg.ExcludeRegex = slices.Clone(cfg.ExcludeRegex)
+	cfg.Rules = slices.Clone(cfg.Rules)
+	cfg.Columns = slices.Clone(cfg.Columns)
+	cfg.Sort = slices.Clone(cfg.Sort)
+	cfg.GroupBy = slices.Clone(cfg.GroupBy)
+	cfg.Aggregates = slices.Clone(cfg.Aggregates)
+	c.cfg = &cfg
+	c.sources = slices.Clone(m.sources)
+	return &c
+}
+
+// Merge выполняет слияние. Опции применяются только к этому вызову.
+// При отмене ctx или истечении Config.Timeout слияние прерывается, записанные файлы
+// удаляются (кроме созданных через WithSink), Result.Cancelled = true,
+// а ошибка - context.Canceled или context.DeadlineExceeded.
+func (m *Merger) Merge(ctx context.Context, opts ...Option) (*Result, error) {
+	run := m.clone()
+	if err := run.apply(opts); err != nil {
+		return nil, err
+	}
+	cfg := run.cfg
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return &Result{Cancelled: true}, err
+	}
+	if len(run.sources) > 0 {
+		if cfg.InputDir != "" {
+			return nil, config.Invalid(fmt.Errorf("входная папка и источники io.Reader взаимоисключающие"))
+		}
+		dir, err := run.spoolSources(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return &Result{Cancelled: true}, err
+			}
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+	} else if cfg.InputDir == "" {
+		return nil, config.Invalid(fmt.Errorf("не заданы входные файлы: WithInputDir или WithSource"))
+	}
+	if err := cfg.Normalize(); err != nil {
+		return nil, err
+	}
+
+	sm := merger.NewStreamMerger().(*merger.StreamMerger)
+	if run.sink != nil {
+		sm.CreateFile = run.sink
+	}
+	sm.Progress = run.progress
+	res, err := sm.MergeFiles(ctx, cfg)
+	return newResult(res), err
+}
+
+// spoolSources сохраняет источники io.Reader во временную папку, которая становится
+// входной папкой слияния. Если порядок не задан явно, файлы обрабатываются
+// в порядке передачи. Возвращает временную папку для удаления после слияния.
+func (m *Merger) spoolSources(ctx context.Context) (string, error) {
+	dir, err := os.MkdirTemp("", "xlsx-merger-sources-*")
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания временной папки: %v", err)
+	}
+	fail := func(err error) (string, error) {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	inputDir := filepath.Join(dir, "input")
+	var list strings.Builder
+	seen := make(map[string]bool)
+	for _, src := range m.sources {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		name := path.Clean(filepath.ToSlash(src.name))
+		if name == "." || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fail(config.Invalid(fmt.Errorf("некорректное имя источника: %q", src.name)))
+		}
+		if seen[strings.ToLower(name)] {
+			return fail(config.Invalid(fmt.Errorf("повторяющееся имя источника: %q", src.name)))
+		}
+		seen[strings.ToLower(name)] = true
+		if strings.Contains(name, "/") {
+			m.cfg.Recursive = true
+		}
+
+		target := filepath.Join(inputDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return fail(fmt.Errorf("ошибка создания временной папки: %v", err))
+		}
+		f, err := os.Create(target)
+		if err != nil {
+			return fail(fmt.Errorf("ошибка сохранения источника %s: %v", name, err))
+		}
+		_, err = io.Copy(f, src.r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fail(fmt.Errorf("ошибка сохранения источника %s: %v", name, err))
+		}
+		list.WriteString(name + "\n")
+	}
+
+	m.cfg.InputDir = inputDir
+	if !m.orderSet {
+		m.cfg.Order = config.OrderList
+		m.cfg.OrderFile = filepath.Join(dir, "order.txt")
+		if err := os.WriteFile(m.cfg.OrderFile, []byte(list.String()), 0o644); err != nil {
+			return fail(fmt.Errorf("ошибка сохранения порядка источников: %v", err))
+		}
+	}
+	return dir, nil
+}
+
+func newResult(res *merger.MergeResult) *Result {
+	if res == nil {
+		return nil
+	}
+	out := &Result{
+		OutputFiles:  res.OutputFiles,
+		InputFiles:   res.InputFiles,
+		RowCount:     res.RowCount,
+		RejectedRows: res.RejectedRows,
+		RejectsFile:  res.RejectsFile,
+		SummaryFile:  res.SummaryFile,
+		SpilledRows:  res.SpilledRows,
+		Duplicates:   res.DuplicatesRemoved,
+		FilteredRows: res.FilteredRows,
+		Filtered:     res.Filtered,
+		Files:        res.Files,
+		FailedFiles:  res.FailedFiles,
+		Partial:      res.Partial,
+		Cancelled:    res.Cancelled,
+	}
+	for _, u := range res.UnknownColumns {
+		out.UnknownColumns = append(out.UnknownColumns, UnknownColumns{File: u.File, Columns: u.Columns})
+	}
+	return out
+}
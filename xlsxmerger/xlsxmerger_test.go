@@ -0,0 +1,202 @@
+package xlsxmerger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ryabkov82/xlsx-merger/internal/config"
+	"github.com/ryabkov82/xlsx-merger/internal/merger"
+)
+
+// memSink собирает файлы результата в памяти
+type memSink struct {
+	names []string
+	files map[string]*bytes.Buffer
+}
+
+type memFile struct{ *bytes.Buffer }
+
+func (memFile) Close() error { return nil }
+
+func (s *memSink) create(name string) (io.WriteCloser, error) {
+	if s.files == nil {
+		s.files = make(map[string]*bytes.Buffer)
+	}
+	buf := &bytes.Buffer{}
+	s.names = append(s.names, name)
+	s.files[name] = buf
+	return memFile{buf}, nil
+}
+
+// sourceOpts возвращает опции источников CSV с колонкой Код и одной строкой данных
+func sourceOpts(names ...string) []Option {
+	var opts []Option
+	for _, name := range names {
+		opts = append(opts, WithSource(name, strings.NewReader("Код\n"+strings.TrimSuffix(name, ".csv")+"\n")))
+	}
+	return opts
+}
+
+// mergeSources сливает источники в CSV через приемник в памяти и возвращает строки данных
+func mergeSources(t *testing.T, opts ...Option) (*Result, []string) {
+	t.Helper()
+	sink := &memSink{}
+	opts = append([]Option{WithOutput("out.csv"), WithHeaders(true), WithSink(sink.create)}, opts...)
+	res, err := Merge(context.Background(), opts...)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if !reflect.DeepEqual(sink.names, res.OutputFiles) {
+		t.Fatalf("приемники %v, ожидаются части %v", sink.names, res.OutputFiles)
+	}
+	var rows []string
+	for _, name := range sink.names {
+		lines := strings.Split(strings.TrimSpace(strings.TrimPrefix(sink.files[name].String(), "\ufeff")), "\n")
+		if !strings.HasPrefix(lines[0], "Код") {
+			t.Fatalf("%s: заголовок %q", name, lines[0])
+		}
+		rows = append(rows, lines[1:]...)
+	}
+	return res, rows
+}
+
+func TestMergeSourcesToSink(t *testing.T) {
+	byName := config.Default()
+	byName.Order = OrderName
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{"порядок передачи", nil, []string{"b", "c", "a"}},
+		{"WithConfig по умолчанию", []Option{WithConfig(config.Default()), WithOutput("out.csv"), WithHeaders(true)}, []string{"b", "c", "a"}},
+		{"WithOrder", []Option{WithOrder(OrderName, false)}, []string{"a", "b", "c"}},
+		{"WithConfig с порядком", []Option{WithConfig(byName), WithOutput("out.csv"), WithHeaders(true)}, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append(tt.opts, sourceOpts("b.csv", "c.csv", "a.csv")...)
+			res, rows := mergeSources(t, opts...)
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("строки %v, ожидается %v", rows, tt.want)
+			}
+			if res.RowCount != 3 || len(res.InputFiles) != 3 {
+				t.Errorf("строк %d, файлов %v", res.RowCount, res.InputFiles)
+			}
+		})
+	}
+}
+
+// cancelReader отменяет контекст при первом чтении
+type cancelReader struct {
+	cancel context.CancelFunc
+}
+
+func (r cancelReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return 0, io.EOF
+}
+
+func TestMergeSourcesCancel(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := &memSink{}
+	res, err := Merge(ctx,
+		WithOutput("out.csv"), WithSink(sink.create),
+		WithSource("a.csv", strings.NewReader("Код\n1\n")),
+		WithSource("b.csv", cancelReader{cancel}),
+		WithSource("c.csv", strings.NewReader("Код\n3\n")),
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ошибка %v, ожидается context.Canceled", err)
+	}
+	if res == nil || !res.Cancelled {
+		t.Errorf("результат %+v, ожидается Cancelled", res)
+	}
+	// временная папка источников удалена
+	left, _ := filepath.Glob(filepath.Join(tmp, "xlsx-merger-sources-*"))
+	if len(left) > 0 {
+		t.Errorf("остались временные папки %v", left)
+	}
+	if len(sink.names) > 0 {
+		t.Errorf("созданы приемники %v", sink.names)
+	}
+}
+
+func TestMergeSourcesSkip(t *testing.T) {
+	res, rows := mergeSources(t, append(sourceOpts("a.csv", "b.csv"),
+		WithSource("broken.csv", strings.NewReader("Код\n\"x\n")),
+		WithSource("empty.csv", strings.NewReader("")),
+		WithOnError(OnErrorSkip),
+	)...)
+	if !reflect.DeepEqual(rows, []string{"a", "b"}) {
+		t.Errorf("строки %v", rows)
+	}
+	if !res.Partial {
+		t.Error("ожидается Partial")
+	}
+	if len(res.FailedFiles) != 1 || res.FailedFiles[0].File != "broken.csv" || res.FailedFiles[0].Error == "" {
+		t.Errorf("FailedFiles = %+v", res.FailedFiles)
+	}
+	status := make(map[string]string)
+	for _, f := range res.Files {
+		status[f.File] = f.Status
+	}
+	want := map[string]string{"a.csv": FileOK, "b.csv": FileOK, "broken.csv": FileError, "empty.csv": FileEmpty}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("состояния файлов %v, ожидается %v", status, want)
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	if newResult(nil) != nil {
+		t.Error("newResult(nil) должен возвращать nil")
+	}
+	res := &merger.MergeResult{
+		OutputFiles:       []string{"out_part1.xlsx"},
+		InputFiles:        []string{"a.xlsx", "b.xlsx"},
+		RowCount:          5,
+		DuplicatesRemoved: 2,
+		UnknownColumns:    []merger.UnknownColumns{{File: "a.xlsx", Columns: []string{"Лишняя"}}},
+		Files: []merger.InputFileStats{
+			{File: "a.xlsx", Status: merger.FileOK, RowsRead: 7, RowsWritten: 5, Duplicates: 2},
+			{File: "b.xlsx", Status: merger.FileSkipped, Error: "повреждена"},
+		},
+		FailedFiles: []merger.FailedFile{{File: "b.xlsx", Error: "повреждена"}},
+		Partial:     true,
+	}
+	out := newResult(res)
+	if !reflect.DeepEqual(out.OutputFiles, res.OutputFiles) || !reflect.DeepEqual(out.InputFiles, res.InputFiles) ||
+		out.RowCount != 5 || out.Duplicates != 2 {
+		t.Errorf("итоги %+v", out)
+	}
+	if !out.Partial || out.Cancelled {
+		t.Errorf("Partial = %v, Cancelled = %v", out.Partial, out.Cancelled)
+	}
+	if !reflect.DeepEqual(out.Files, res.Files) || !reflect.DeepEqual(out.FailedFiles, res.FailedFiles) {
+		t.Errorf("Files = %+v, FailedFiles = %+v", out.Files, out.FailedFiles)
+	}
+	want := []UnknownColumns{{File: "a.xlsx", Columns: []string{"Лишняя"}}}
+	if !reflect.DeepEqual(out.UnknownColumns, want) {
+		t.Errorf("UnknownColumns = %+v", out.UnknownColumns)
+	}
+}
+
+func TestMergeSourcesUnknownColumns(t *testing.T) {
+	res, _ := mergeSources(t, WithAlignHeaders(nil),
+		WithSource("a.csv", strings.NewReader("Код,Имя\n1,первая строка шаблона\n")),
+		WithSource("sub/b.csv", strings.NewReader("Код,Лишняя\nb,x\n")),
+	)
+	want := []UnknownColumns{{File: "sub/b.csv", Columns: []string{"Лишняя"}}}
+	if !reflect.DeepEqual(res.UnknownColumns, want) {
+		t.Errorf("UnknownColumns = %+v, ожидается %+v", res.UnknownColumns, want)
+	}
+}